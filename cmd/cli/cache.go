@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect or clear the PTR/ASN lookup cache used by trace and sweep",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stats",
+		Short: "Show cache size, age and hit rate",
+		Args:  cobra.NoArgs,
+		Run:   runCacheStats,
+	}, &cobra.Command{
+		Use:   "clear",
+		Short: "Delete every cached lookup",
+		Args:  cobra.NoArgs,
+		Run:   runCacheClear,
+	})
+	return cmd
+}
+
+func runCacheStats(cmd *cobra.Command, args []string) {
+	cache, err := openLookupCache()
+	if err != nil {
+		log.Fatalf("open lookup cache: %v", err)
+	}
+	s := cache.Stats()
+	total := s.Entries["ptr"] + s.Entries["asn"] + s.Entries["name"]
+	fmt.Printf("cache:    %s (%.1f KB)\n", s.Path, float64(s.Bytes)/1024)
+	fmt.Printf("entries:  %d of max %d (ptr %d, asn %d, as names %d), %d expired\n",
+		total, s.MaxEntries, s.Entries["ptr"], s.Entries["asn"], s.Entries["name"], s.Expired)
+	fmt.Printf("ttl:      %s (empty answers %s)\n", s.TTL, s.TTL/4)
+	if total > 0 {
+		fmt.Printf("age:      oldest %s ago, newest %s ago\n",
+			time.Since(s.Oldest).Round(time.Second), time.Since(s.Newest).Round(time.Second))
+	}
+	if lookups := s.Hits + s.Misses; lookups > 0 {
+		fmt.Printf("hit rate: %.1f%% (%d hits, %d misses)\n", float64(s.Hits)/float64(lookups)*100, s.Hits, s.Misses)
+	}
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) {
+	cache, err := openLookupCache()
+	if err != nil {
+		log.Fatalf("open lookup cache: %v", err)
+	}
+	s := cache.Stats()
+	if err := cache.Clear(); err != nil {
+		log.Fatalf("clear lookup cache: %v", err)
+	}
+	fmt.Printf("cleared %d lookups from %s\n", s.Entries["ptr"]+s.Entries["asn"]+s.Entries["name"], s.Path)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+)
+
+// config holds settings read from $XDG_CONFIG_HOME/intspeed/config.json
+// (or the platform equivalent). Environment variables override it, and
+// flags override both.
+type config struct {
+	// ASNDB is an ip2asn TSV or MRT RIB dump used to map hops to ASNs
+	// offline; empty or "cymru" queries Team Cymru's DNS.
+	ASNDB string `json:"asn_db,omitempty"`
+	// PeeringDB is a full PeeringDB JSON export used to spot exchange
+	// (IXP) hops and their members.
+	PeeringDB string `json:"peeringdb,omitempty"`
+	// CacheTTL is how long PTR and ASN lookups are remembered, as a Go
+	// duration ("24h"); CacheMaxEntries bounds the cache file.
+	CacheTTL        string `json:"cache_ttl,omitempty"`
+	CacheMaxEntries int    `json:"cache_max_entries,omitempty"`
+	// Endpoints are added to the built-in registry, e.g. your own
+	// reflector or an iperf3 server you may use; kinds that are otherwise
+	// opt-in (iperf3) are tested on these without --kind.
+	Endpoints []localEndpoint `json:"endpoints,omitempty"`
+}
+
+// localEndpoint is a user-supplied endpoint and the location it tests.
+type localEndpoint struct {
+	Location string `json:"location"`
+	endpoints.Endpoint
+}
+
+var (
+	asnDB     string
+	peeringDB string
+)
+
+// loadConfig reads the config file, if there is one.
+func loadConfig() config {
+	var cfg config
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return cfg
+	}
+	path := filepath.Join(dir, "intspeed", "config.json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg
+	}
+	if err != nil {
+		log.Fatalf("read config: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Fatalf("parse %s: %v", path, err)
+	}
+	return cfg
+}
+
+// setting picks a flag's value, else the environment variable's, else
+// the config file's.
+func setting(flag, env string, fromConfig func(config) string) string {
+	if flag != "" {
+		return flag
+	}
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return fromConfig(loadConfig())
+}
+
+// asnResolver is the hop→ASN backend chosen by --asn-db, else
+// $INTSPEED_ASN_DB, else the config file's asn_db. It is loaded once.
+var asnResolver = sync.OnceValue(func() aspath.Resolver {
+	path := setting(asnDB, "INTSPEED_ASN_DB", func(c config) string { return c.ASNDB })
+	if path == "" || path == "cymru" {
+		return aspath.Cymru
+	}
+	table, err := aspath.LoadTable(path)
+	if err != nil {
+		log.Fatalf("load ASN database: %v", err)
+	}
+	if verbose {
+		v4, v6 := table.Prefixes()
+		log.Printf("ASN database %s: %d IPv4 + %d IPv6 prefixes", path, v4, v6)
+	}
+	return table
+})
+
+// peeringDBData is the dataset chosen by --peeringdb, else
+// $INTSPEED_PEERINGDB, else the config file's peeringdb; nil if none.
+var peeringDBData = sync.OnceValue(func() *aspath.PeeringDB {
+	path := setting(peeringDB, "INTSPEED_PEERINGDB", func(c config) string { return c.PeeringDB })
+	if path == "" {
+		return nil
+	}
+	db, err := aspath.LoadPeeringDB(path)
+	if err != nil {
+		log.Fatalf("load PeeringDB: %v", err)
+	}
+	if verbose {
+		ixs, ports := db.Exchanges()
+		log.Printf("PeeringDB %s: %d exchanges, %d member ports", path, ixs, ports)
+	}
+	return db
+})
+
+// lookupCache is the on-disk PTR/ASN cache shared by every command that
+// traces, or nil if it can't be opened (lookups then just aren't cached).
+var lookupCache = sync.OnceValue(func() *aspath.Cache {
+	cache, err := openLookupCache()
+	if err != nil {
+		log.Printf("warning: lookup cache: %v", err)
+		return nil
+	}
+	return cache
+})
+
+func openLookupCache() (*aspath.Cache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	cfg := loadConfig()
+	ttl := 24 * time.Hour
+	if cfg.CacheTTL != "" {
+		if ttl, err = time.ParseDuration(cfg.CacheTTL); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("cache_ttl must be a positive duration like \"24h\", not %q", cfg.CacheTTL)
+		}
+	}
+	maxEntries := 50000
+	if cfg.CacheMaxEntries > 0 {
+		maxEntries = cfg.CacheMaxEntries
+	}
+	return aspath.OpenCache(filepath.Join(dir, "intspeed", "lookups.json"), ttl, maxEntries)
+}
+
+// saveLookupCache writes back whatever the run looked up.
+func saveLookupCache() {
+	if cache := lookupCache(); cache != nil {
+		if err := cache.Save(); err != nil {
+			log.Printf("warning: save lookup cache: %v", err)
+		}
+	}
+}
@@ -37,6 +37,8 @@ func main() {
 	rootCmd.PersistentFlags().IntVarP(&threads, "threads", "t", 2, "Threads per test")
 	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 180, "Timeout seconds per location")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
+	rootCmd.PersistentFlags().StringVar(&asnDB, "asn-db", "", "Offline IP→ASN database (ip2asn TSV or MRT RIB dump, optionally .gz/.bz2) for traces; \"cymru\" = Team Cymru DNS (env INTSPEED_ASN_DB, config asn_db)")
+	rootCmd.PersistentFlags().StringVar(&peeringDB, "peeringdb", "", "PeeringDB JSON export (optionally .gz/.bz2) to mark IXP hops in traces (env INTSPEED_PEERINGDB, config peeringdb)")
 
 	var testCmd = &cobra.Command{
 		Use:   "test",
@@ -58,7 +60,7 @@ func main() {
 		Run:   generateHTMLReport,
 	}
 
-	rootCmd.AddCommand(testCmd, locationsCmd, htmlCmd, newSweepCmd(), newTraceCmd())
+	rootCmd.AddCommand(testCmd, locationsCmd, htmlCmd, newSweepCmd(), newTraceCmd(), newRoutesCmd(), newTransitCmd(), newCacheCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+)
+
+// reverseTrace is the path back to us, traced by a RIPE Atlas probe near
+// the endpoint (--reverse).
+type reverseTrace struct {
+	aspath.AtlasTrace
+	ASPath []aspath.AS `json:"as_path,omitempty"`
+	// Asymmetry compares the return AS path, read from our end, with the
+	// forward one: Added are the ASes only the return path crosses,
+	// Removed those only the forward path does. nil when they match.
+	Asymmetry *aspath.PathChange `json:"asymmetry,omitempty"`
+}
+
+// loadReverse reads the Atlas results in file and looks up their hops'
+// names and networks as a trace would.
+func loadReverse(ctx context.Context, file string) []aspath.AtlasTrace {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("--reverse: %v", err)
+	}
+	defer f.Close()
+	traces, err := aspath.ReadAtlas(f)
+	if err != nil {
+		log.Fatalf("--reverse: %s: %v", file, err)
+	}
+	if len(traces) == 0 {
+		log.Fatalf("--reverse: no traceroute results in %s", file)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	opts := aspath.Options{Resolver: asnResolver(), Cache: lookupCache(), PeeringDB: peeringDBData()}
+	for i := range traces {
+		aspath.Enrich(ctx, traces[i].Hops, opts)
+	}
+	return traces
+}
+
+// pickReverse takes the newest of traces over family ("" for any) and
+// compares its AS path with the forward one; nil if there is none.
+func pickReverse(traces []aspath.AtlasTrace, family string, forward []aspath.AS) *reverseTrace {
+	var best *aspath.AtlasTrace
+	for i, t := range traces {
+		if f := atlasFamily(t); family != "" && f != family {
+			continue
+		}
+		if best == nil || t.Timestamp.After(best.Timestamp) {
+			best = &traces[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	r := &reverseTrace{AtlasTrace: *best, ASPath: aspath.ASPath(best.Hops)}
+	if len(forward) > 0 && len(r.ASPath) > 0 {
+		r.Asymmetry = aspath.ComparePaths(forward, reversedPath(r.ASPath))
+	}
+	return r
+}
+
+// atlasFamily is the address family t was traced over: its measurement's
+// af, or for results without one a guess from its addresses. The probe's
+// own address can be of the other family, so it is the last resort.
+func atlasFamily(t aspath.AtlasTrace) string {
+	switch t.AF {
+	case 4:
+		return "v4"
+	case 6:
+		return "v6"
+	}
+	addr := t.Dst
+	if addr == "" {
+		addr = t.From
+	}
+	if strings.Contains(addr, ":") {
+		return "v6"
+	}
+	return "v4"
+}
+
+// reversedPath reads a return path from our end, so it lines up with the
+// forward one. An exchange is noted on the AS entered through it, which
+// read backwards is the AS after it.
+func reversedPath(path []aspath.AS) []aspath.AS {
+	rev := slices.Clone(path)
+	slices.Reverse(rev)
+	for i := range rev {
+		rev[i].IXP = ""
+		if j := len(path) - i; j < len(path) {
+			rev[i].IXP = path[j].IXP
+		}
+	}
+	return rev
+}
+
+// printReverse prints the return trace's hops, then the forward and return
+// AS paths side by side from our end, and where they part.
+func printReverse(r *reverseTrace, forward []aspath.AS) {
+	fmt.Printf("\nreturn trace from Atlas probe %d", r.Probe)
+	if r.From != "" {
+		fmt.Printf(" (%s)", r.From)
+	}
+	if r.Measurement != 0 {
+		fmt.Printf(", measurement %d", r.Measurement)
+	}
+	fmt.Printf(", %s\n", r.Timestamp.Local().Format("2006-01-02 15:04"))
+	printHops(r.Hops)
+	if len(forward) == 0 || len(r.ASPath) == 0 {
+		return
+	}
+
+	back := reversedPath(r.ASPath)
+	w := len("FORWARD")
+	for _, as := range forward {
+		w = max(w, len([]rune(asLabel(as))))
+	}
+	fmt.Printf("\n%3s  %-*s    %s\n", "", w, "FORWARD", "RETURN (from our end)")
+	fmt.Println(strings.Repeat("─", 78))
+	for i := range max(len(forward), len(back)) {
+		cell := func(path []aspath.AS) (string, string) {
+			if i >= len(path) {
+				return "", ""
+			}
+			return path[i].ASN, osc8(aspath.PeeringDBURL(path[i].ASN), asLabel(path[i]))
+		}
+		fa, fl := cell(forward)
+		ba, bl := cell(back)
+		mark := "  "
+		if fa != ba {
+			mark = "≠ "
+		}
+		pad := w
+		if i < len(forward) {
+			pad = max(w-len([]rune(asLabel(forward[i]))), 0)
+		}
+		fmt.Printf("%3d  %s%s  %s%s\n", i+1, fl, strings.Repeat(" ", pad), mark, bl)
+	}
+
+	c := r.Asymmetry
+	if c == nil {
+		fmt.Println("\nsymmetric: the return path crosses the same ASes in the same order")
+		return
+	}
+	var what []string
+	for _, as := range c.Added {
+		what = append(what, "return only "+asLabel(as))
+	}
+	for _, as := range c.Removed {
+		what = append(what, "forward only "+asLabel(as))
+	}
+	if len(what) == 0 {
+		what = append(what, "same ASes in a different order")
+	}
+	fmt.Printf("\n⚠ asymmetric: %s\n", strings.Join(what, " · "))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+)
+
+func TestReversedPath(t *testing.T) {
+	// The return path from the far end: ntt, into cogent over DE-CIX, into
+	// our ISP over AMS-IX.
+	path := []aspath.AS{{ASN: "2914"}, {ASN: "174", IXP: "DE-CIX"}, {ASN: "64500", IXP: "AMS-IX"}}
+	orig := slices.Clone(path)
+	// From our end the exchanges sit between the same ASes: AMS-IX leads
+	// from us into cogent, DE-CIX from cogent into ntt.
+	want := []aspath.AS{{ASN: "64500"}, {ASN: "174", IXP: "AMS-IX"}, {ASN: "2914", IXP: "DE-CIX"}}
+	if got := reversedPath(path); !slices.Equal(got, want) {
+		t.Errorf("reversedPath = %+v, want %+v", got, want)
+	}
+	if !slices.Equal(path, orig) {
+		t.Errorf("reversedPath changed its argument to %+v", path)
+	}
+
+	for _, tt := range []struct{ path, want []aspath.AS }{
+		{nil, nil},
+		{[]aspath.AS{{ASN: "174", IXP: "DE-CIX"}}, []aspath.AS{{ASN: "174"}}},
+		{[]aspath.AS{{ASN: "2914"}, {ASN: "174"}}, []aspath.AS{{ASN: "174"}, {ASN: "2914"}}},
+	} {
+		if got := reversedPath(tt.path); !slices.Equal(got, tt.want) {
+			t.Errorf("reversedPath(%+v) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPickReverse(t *testing.T) {
+	at := func(probe, af int, from, dst string, hour int) aspath.AtlasTrace {
+		return aspath.AtlasTrace{Probe: probe, AF: af, From: from, Dst: dst, Timestamp: time.Date(2026, 1, 1, hour, 0, 0, 0, time.UTC)}
+	}
+	traces := []aspath.AtlasTrace{
+		// A dual-stack probe's IPv6 trace, reported from its IPv4 address.
+		at(1, 6, "198.51.100.7", "2001:db8::1", 12),
+		at(2, 4, "198.51.100.7", "192.0.2.1", 10),
+		// No af: told by the address traced to.
+		at(3, 0, "198.51.100.8", "2001:db8::1", 11),
+		at(4, 0, "", "192.0.2.1", 9),
+	}
+	for _, tt := range []struct {
+		family string
+		traces []aspath.AtlasTrace
+		want   int // probe; 0 for none
+	}{
+		{"", traces, 1},
+		{"v4", traces, 2},
+		{"v6", traces, 1},
+		{"v6", traces[2:], 3},
+		{"v4", traces[2:], 4},
+		{"v4", traces[:1], 0},
+	} {
+		r := pickReverse(tt.traces, tt.family, nil)
+		got := 0
+		if r != nil {
+			got = r.Probe
+		}
+		if got != tt.want {
+			t.Errorf("pickReverse(%d traces, %q) took probe %d, want %d", len(tt.traces), tt.family, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+	"github.com/rotkonetworks/intspeed/pkg/engine"
+	"github.com/spf13/cobra"
+)
+
+var (
+	routesLatest bool
+	routesJSON   bool
+)
+
+func newRoutesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "Compare the AS paths saved by sweep and trace across runs",
+	}
+	diff := &cobra.Command{
+		Use:   "diff [location]",
+		Short: "List every AS path change in the saved sweep and trace results",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runRoutesDiff,
+	}
+	diff.Flags().BoolVar(&routesLatest, "latest", false, "Only compare each location's latest run with the one before")
+	diff.Flags().BoolVar(&routesJSON, "json", false, "Print the changes as JSON")
+	cmd.AddCommand(diff)
+	return cmd
+}
+
+// routeSnapshot is one run's AS path to a location's endpoint, with what
+// that run measured over it: sweeps record throughput and HTTP latency,
+// traces the destination's RTT.
+type routeSnapshot struct {
+	At           time.Time   `json:"at"`
+	Source       string      `json:"source"` // "sweep" or "trace"
+	File         string      `json:"file"`
+	Location     string      `json:"-"`
+	Endpoint     string      `json:"-"`
+	Host         string      `json:"-"`
+	Family       string      `json:"-"`
+	Path         []aspath.AS `json:"as_path"`
+	LatencyMs    float64     `json:"latency_ms,omitempty"`
+	DownloadMbps float64     `json:"download_mbps,omitempty"`
+	UploadMbps   float64     `json:"upload_mbps,omitempty"`
+}
+
+// key identifies the destination a path leads to. A sweep traces the
+// endpoint it downloaded from and a trace the first one or --endpoint, so
+// a location's paths are only comparable when they went to the same host.
+func (s routeSnapshot) key() string {
+	return strings.Join([]string{s.Location, s.Endpoint, s.Host, s.Family}, "\x00")
+}
+
+// routeChange is the AS path to a location's endpoint differing from the
+// previous run's.
+type routeChange struct {
+	Location string `json:"location"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Family   string `json:"family,omitempty"`
+	aspath.PathChange
+	Before routeSnapshot `json:"before"`
+	After  routeSnapshot `json:"after"`
+}
+
+// sweepSnapshots takes the AS paths out of a sweep's results.
+func sweepSnapshots(at time.Time, file string, results []engine.LocationResult) []routeSnapshot {
+	var snaps []routeSnapshot
+	for _, r := range results {
+		runs := []engine.LocationResult{r}
+		if r.IPv6 != nil {
+			runs = append(runs, *r.IPv6)
+		}
+		for _, run := range runs {
+			if len(run.ASPath) == 0 {
+				continue
+			}
+			snaps = append(snaps, routeSnapshot{
+				At: at, Source: "sweep", File: file, Location: r.Location, Endpoint: run.DownloadVia, Host: run.TraceHost,
+				Family: run.Family, Path: run.ASPath,
+				LatencyMs: run.LatencyMs, DownloadMbps: run.DownloadMbps, UploadMbps: run.UploadMbps,
+			})
+		}
+	}
+	return snaps
+}
+
+// traceSnapshots takes the AS paths out of trace reports.
+func traceSnapshots(file string, reports []traceReport) []routeSnapshot {
+	var snaps []routeSnapshot
+	for _, r := range reports {
+		if len(r.ASPath) == 0 {
+			continue
+		}
+		s := routeSnapshot{
+			At: r.Timestamp, Source: "trace", File: file, Location: r.Location, Endpoint: r.Endpoint, Host: r.Host,
+			Family: r.Family, Path: r.ASPath,
+		}
+		if n := len(r.Stats); n > 0 {
+			s.LatencyMs = r.Stats[n-1].AvgMs
+		} else if n := len(r.Hops); n > 0 && r.Hops[n-1].IP != "" {
+			s.LatencyMs = r.Hops[n-1].RTTMs
+		}
+		snaps = append(snaps, s)
+	}
+	return snaps
+}
+
+// loadRouteHistory reads the AS paths of every sweep and trace saved in
+// dir, oldest first. Files that don't parse are skipped.
+func loadRouteHistory(dir string) []routeSnapshot {
+	var snaps []routeSnapshot
+	for _, pattern := range []string{"sweep_*.json", "trace_*.json"} {
+		files, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, file := range files {
+			if strings.HasSuffix(file, "_latest.json") {
+				continue
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			if strings.HasPrefix(filepath.Base(file), "sweep_") {
+				var sweep struct {
+					Timestamp time.Time               `json:"timestamp"`
+					Results   []engine.LocationResult `json:"results"`
+				}
+				if json.Unmarshal(data, &sweep) == nil {
+					snaps = append(snaps, sweepSnapshots(sweep.Timestamp, file, sweep.Results)...)
+				}
+			} else {
+				var reports []traceReport
+				if json.Unmarshal(data, &reports) == nil {
+					snaps = append(snaps, traceSnapshots(file, reports)...)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(snaps, func(a, b routeSnapshot) int { return a.At.Compare(b.At) })
+	return snaps
+}
+
+// diffRoutes compares each snapshot with the previous one to the same
+// destination (routeSnapshot.key), in history order, and returns the
+// changes.
+func diffRoutes(history []routeSnapshot) []routeChange {
+	var changes []routeChange
+	prev := map[string]routeSnapshot{}
+	for _, s := range history {
+		if c, ok := compareRoutes(prev, s); ok {
+			changes = append(changes, c)
+		}
+		prev[s.key()] = s
+	}
+	return changes
+}
+
+// compareRoutes compares s with the previous snapshot to its destination
+// in prev; ok is false if there is none or the path is the same.
+func compareRoutes(prev map[string]routeSnapshot, s routeSnapshot) (c routeChange, ok bool) {
+	p, ok := prev[s.key()]
+	if !ok {
+		return c, false
+	}
+	change := aspath.ComparePaths(p.Path, s.Path)
+	if change == nil {
+		return c, false
+	}
+	return routeChange{
+		Location: s.Location, Endpoint: s.Endpoint, Host: s.Host, Family: s.Family,
+		PathChange: *change, Before: p, After: s,
+	}, true
+}
+
+func runRoutesDiff(cmd *cobra.Command, args []string) {
+	history := loadRouteHistory(outputDir)
+	if len(args) > 0 {
+		history = slices.DeleteFunc(history, func(s routeSnapshot) bool { return !strings.EqualFold(s.Location, args[0]) })
+	}
+	if len(history) == 0 {
+		log.Fatalf("no saved AS paths in %s — run `intspeed sweep` or `intspeed trace` first", outputDir)
+	}
+	changes := diffRoutes(history)
+	if routesLatest {
+		latest := map[string]time.Time{}
+		for _, s := range history {
+			latest[s.key()] = s.At
+		}
+		changes = slices.DeleteFunc(changes, func(c routeChange) bool { return !c.After.At.Equal(latest[c.After.key()]) })
+	}
+	if routesJSON {
+		json.NewEncoder(os.Stdout).Encode(changes)
+		return
+	}
+	runs := map[string]bool{}
+	for _, s := range history {
+		runs[s.File] = true
+	}
+	fmt.Printf("route changes: %d across %d saved runs in %s\n", len(changes), len(runs), outputDir)
+	for _, c := range changes {
+		fmt.Println()
+		printRouteChange(c)
+	}
+}
+
+// printRouteChange shows both paths, what differs between them and how
+// the measurements moved.
+func printRouteChange(c routeChange) {
+	label := c.Location
+	if c.Endpoint != "" {
+		label += " · " + c.Endpoint
+	}
+	if c.Family != "" {
+		label += " (" + c.Family + ")"
+	}
+	fmt.Printf("%s · %s → %s\n", label, c.Before.At.Local().Format("2006-01-02 15:04"), c.After.At.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("  was: %s\n", formatASPath(c.Before.Path))
+	fmt.Printf("  now: %s\n", formatASPath(c.After.Path))
+	var what []string
+	for _, as := range c.Added {
+		what = append(what, "+"+asLabel(as))
+	}
+	for _, as := range c.Removed {
+		what = append(what, "−"+asLabel(as))
+	}
+	switch {
+	case c.NewLen > c.OldLen:
+		what = append(what, fmt.Sprintf("longer (%d → %d ASes)", c.OldLen, c.NewLen))
+	case c.NewLen < c.OldLen:
+		what = append(what, fmt.Sprintf("shorter (%d → %d ASes)", c.OldLen, c.NewLen))
+	case len(c.Added) == 0:
+		what = append(what, "reordered")
+	}
+	fmt.Printf("  %s\n", strings.Join(what, " · "))
+	if moved := routeMetrics(c.Before, c.After); moved != "" {
+		fmt.Printf("  %s\n", moved)
+	}
+}
+
+// routeMetrics compares what two runs measured, where both measured the
+// same thing: latency from runs of the same kind, throughput from sweeps.
+func routeMetrics(before, after routeSnapshot) string {
+	var parts []string
+	metric := func(name string, a, b float64, unit string) {
+		if a > 0 && b > 0 {
+			parts = append(parts, fmt.Sprintf("%s %.1f → %.1f %s (%+.0f%%)", name, a, b, unit, (b-a)/a*100))
+		}
+	}
+	if before.Source != after.Source {
+		return ""
+	}
+	metric("latency", before.LatencyMs, after.LatencyMs, "ms")
+	metric("download", before.DownloadMbps, after.DownloadMbps, "Mbps")
+	metric("upload", before.UploadMbps, after.UploadMbps, "Mbps")
+	return strings.Join(parts, " · ")
+}
+
+func asLabel(as aspath.AS) string {
+	if as.Name != "" {
+		return fmt.Sprintf("%s (AS%s)", as.Name, as.ASN)
+	}
+	return "AS" + as.ASN
+}
+
+// printRouteAlerts warns about every current path that differs from the
+// latest one saved in outputDir to the same destination. Call it before
+// saving the current run.
+func printRouteAlerts(current []routeSnapshot) {
+	if len(current) == 0 {
+		return
+	}
+	prev := map[string]routeSnapshot{}
+	for _, s := range loadRouteHistory(outputDir) {
+		prev[s.key()] = s
+	}
+	var changes []routeChange
+	for _, s := range current {
+		if c, ok := compareRoutes(prev, s); ok {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("\n⚠️  ROUTE CHANGES since the previous run\n")
+	fmt.Println(strings.Repeat("─", 78))
+	for i, c := range changes {
+		if i > 0 {
+			fmt.Println()
+		}
+		printRouteChange(c)
+	}
+}
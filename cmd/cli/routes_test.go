@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+	"github.com/rotkonetworks/intspeed/pkg/engine"
+)
+
+func TestDiffRoutes(t *testing.T) {
+	cogent, ntt, telia, lumen := aspath.AS{ASN: "174"}, aspath.AS{ASN: "2914"}, aspath.AS{ASN: "1299"}, aspath.AS{ASN: "3356"}
+	t0 := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return t0.Add(time.Duration(i) * time.Hour) }
+	sweep := func(i int, location, endpoint, host string, path ...aspath.AS) routeSnapshot {
+		return sweepSnapshots(at(i), "", []engine.LocationResult{
+			{Location: location, DownloadVia: endpoint, TraceHost: host, ASPath: path},
+		})[0]
+	}
+	trace := func(i int, location, endpoint, host, family string, path ...aspath.AS) routeSnapshot {
+		return traceSnapshots("", []traceReport{
+			{Timestamp: at(i), Location: location, Endpoint: endpoint, Host: host, Family: family, ASPath: path},
+		})[0]
+	}
+
+	history := []routeSnapshot{
+		sweep(0, "Amsterdam", "ams-a", "a.example", cogent, ntt),
+		sweep(1, "Amsterdam", "ams-b", "b.example", cogent, lumen),          // fastest was another endpoint
+		trace(2, "Amsterdam", "ams-a", "a.example", "", cogent, telia, ntt), // a trace to the first: compared
+		trace(3, "Amsterdam", "ams-a", "a.example", "v6", cogent, ntt),      // other family
+		sweep(4, "Amsterdam", "ams-a", "2001:db8::a", cogent, ntt),          // the endpoint moved
+		sweep(5, "Frankfurt", "ams-a", "a.example", lumen),                  // other location
+		sweep(6, "Amsterdam", "ams-b", "b.example", cogent, lumen),
+	}
+	changes := diffRoutes(history)
+	if len(changes) != 1 {
+		for _, c := range changes {
+			t.Logf("%s/%s (%s, %q): %v → %v", c.Location, c.Endpoint, c.Host, c.Family, c.Before.Path, c.After.Path)
+		}
+		t.Fatalf("%d changes, want 1", len(changes))
+	}
+	c := changes[0]
+	if !c.Before.At.Equal(at(0)) || !c.After.At.Equal(at(2)) || c.Before.Source != "sweep" || c.After.Source != "trace" {
+		t.Errorf("change from %s %v to %s %v, want the sweep at %v to the trace at %v",
+			c.Before.Source, c.Before.At, c.After.Source, c.After.At, at(0), at(2))
+	}
+	if c.Location != "Amsterdam" || c.Endpoint != "ams-a" || c.Host != "a.example" || c.Family != "" {
+		t.Errorf("change to %s/%s (%s, %q), want Amsterdam/ams-a (a.example)", c.Location, c.Endpoint, c.Host, c.Family)
+	}
+	if len(c.Added) != 1 || c.Added[0] != telia || len(c.Removed) != 0 {
+		t.Errorf("added %v, removed %v; want telia added", c.Added, c.Removed)
+	}
+}
+
+func TestSweepSnapshots(t *testing.T) {
+	path := []aspath.AS{{ASN: "174"}}
+	snaps := sweepSnapshots(time.Now(), "sweep.json", []engine.LocationResult{
+		{
+			Location: "Amsterdam", Family: "v4", DownloadVia: "ams-a", TraceHost: "192.0.2.1", ASPath: path,
+			IPv6: &engine.LocationResult{Family: "v6", DownloadVia: "ams-b", TraceHost: "2001:db8::b", ASPath: path},
+		},
+		{Location: "Frankfurt", DownloadVia: "fra-a", TraceError: "no route"}, // nothing traced
+	})
+	if len(snaps) != 2 {
+		t.Fatalf("%d snapshots, want 2: %+v", len(snaps), snaps)
+	}
+	for i, want := range []routeSnapshot{
+		{Location: "Amsterdam", Endpoint: "ams-a", Host: "192.0.2.1", Family: "v4"},
+		{Location: "Amsterdam", Endpoint: "ams-b", Host: "2001:db8::b", Family: "v6"},
+	} {
+		if got := snaps[i]; got.key() != want.key() {
+			t.Errorf("snapshot %d is of %s/%s (%s, %s), want %s/%s (%s, %s)", i,
+				got.Location, got.Endpoint, got.Host, got.Family, want.Location, want.Endpoint, want.Host, want.Family)
+		}
+	}
+}
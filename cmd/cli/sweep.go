@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rotkonetworks/intspeed/pkg/aspath"
@@ -25,6 +27,17 @@ var (
 	sweepJSON         bool
 	sweepASPath       bool
 	sweepMaxEndpoints int
+	sweepStreams      int
+	sweepDuration     int
+	sweepLoaded       bool
+	sweepPhases       bool
+	sweepUDPMbps      int64
+	sweepLossProbes   int
+	sweepFamily       string
+	sweepTraceRate    int
+	sweepPMTU         bool
+	sweepKinds        string
+	sweepEndpoints    []string
 )
 
 func newSweepCmd() *cobra.Command {
@@ -38,26 +51,51 @@ func newSweepCmd() *cobra.Command {
 	cmd.Flags().IntVar(&sweepPings, "pings", 4, "Latency samples per endpoint")
 	cmd.Flags().StringVar(&sweepLocations, "locations", "", "Comma-separated subset of locations (default: all)")
 	cmd.Flags().BoolVar(&sweepJSON, "json", false, "Print raw JSON results to stdout")
-	cmd.Flags().BoolVar(&sweepASPath, "aspath", true, "Trace AS-level path per location (needs root/CAP_NET_RAW)")
+	cmd.Flags().BoolVar(&sweepASPath, "aspath", true, "Trace each location's route and AS path into the results (needs root/CAP_NET_RAW, or unprivileged ICMP sockets on Linux)")
+	cmd.Flags().IntVar(&sweepTraceRate, "trace-rate", 200, "Max traceroute probes per second across all AS path traces (0 = unlimited)")
+	cmd.Flags().BoolVar(&sweepPMTU, "pmtu", false, "Also discover each traced path's MTU and the hop that limits it (needs root/CAP_NET_RAW)")
 	cmd.Flags().IntVar(&sweepMaxEndpoints, "max-endpoints", 0, "Max endpoints latency-tested per city (0 = all)")
+	cmd.Flags().IntVar(&sweepStreams, "streams", 1, "Parallel connections per download/upload test")
+	cmd.Flags().BoolVar(&sweepPhases, "phases", false, "Show DNS/TCP/TLS/TTFB breakdown per endpoint")
+	cmd.Flags().Int64Var(&sweepUDPMbps, "udp-mbps", 0, "Run iperf3 tests over UDP at this rate, reporting loss and jitter (0 = TCP)")
+	cmd.Flags().StringVar(&sweepKinds, "kind", "", "Comma-separated endpoint kinds to test, e.g. iperf3 (default: all but single-client iperf3 servers)")
+	cmd.Flags().StringArrayVar(&sweepEndpoints, "endpoint", nil, "Also test LOCATION=KIND:HOST (or :URL), e.g. Paris=iperf3:iperf.example.net; repeatable")
+	cmd.Flags().IntVar(&sweepLossProbes, "loss", 0, "Send this many UDP probes to a reflector endpoint per location for loss/jitter, e.g. one added with --endpoint LOCATION=reflector:HOST (0 = off)")
+	cmd.Flags().StringVar(&sweepFamily, "family", "", "Address family: v4, v6, or both to compare them side by side (default: system choice)")
+	cmd.Flags().BoolVar(&sweepLoaded, "loaded-latency", false, "Measure latency under load (bufferbloat) during download/upload")
+	cmd.Flags().IntVar(&sweepDuration, "duration", 0, "Seconds per download/upload test, excluding ramp-up (0 = fixed size)")
 	return cmd
 }
 
 func runSweep(cmd *cobra.Command, args []string) {
-	reg, err := endpoints.Load()
-	if err != nil {
-		log.Fatalf("load endpoint registry: %v", err)
-	}
+	reg := loadRegistry(sweepEndpoints)
 
 	opts := engine.Options{
 		DownloadBytes: sweepDownloadMB * 1_000_000,
 		UploadBytes:   sweepUploadMB * 1_000_000,
 		PingCount:     sweepPings,
 		MaxEndpoints:  sweepMaxEndpoints,
+		Streams:       sweepStreams,
+		TestDuration:  time.Duration(sweepDuration) * time.Second,
+		LoadedLatency: sweepLoaded,
+		UDPRate:       sweepUDPMbps * 1_000_000,
+		LossProbes:    sweepLossProbes,
+		Family:        sweepFamily,
+	}
+	switch sweepFamily {
+	case engine.FamilyAny, engine.FamilyV4, engine.FamilyV6, engine.FamilyBoth:
+	default:
+		log.Fatalf("--family must be v4, v6 or both, not %q", sweepFamily)
 	}
 	if sweepLocations != "" {
 		opts.Locations = strings.Split(sweepLocations, ",")
 	}
+	if sweepKinds != "" {
+		opts.Kinds = strings.Split(sweepKinds, ",")
+	}
+	if sweepLossProbes > 0 && !hasKind(reg, "reflector") {
+		log.Fatalf("--loss needs a reflector endpoint: run `intspeed-server --reflector 8862` on a host and add it with --endpoint LOCATION=reflector:HOST or in the config file")
+	}
 
 	if !sweepJSON {
 		fmt.Printf("🌍 intspeed sweep — registry verified %s\n\n", reg.Verified)
@@ -71,9 +109,11 @@ func runSweep(cmd *cobra.Command, args []string) {
 		case "location_start":
 			fmt.Printf("[%d/%d] %s\n", p.Index, p.Total, p.Location)
 		case "download":
-			fmt.Printf("        ↓ %.1f Mbps via %s\n", p.Value, p.Endpoint)
+			fmt.Printf("        ↓ %.1f Mbps via %s%s\n", p.Value, p.Endpoint, familyTag(p.Family))
 		case "upload":
-			fmt.Printf("        ↑ %.1f Mbps via %s\n", p.Value, p.Endpoint)
+			fmt.Printf("        ↑ %.1f Mbps via %s%s\n", p.Value, p.Endpoint, familyTag(p.Family))
+		case "loss":
+			fmt.Printf("        ✕ %.2f%% loss via %s%s\n", p.Value, p.Endpoint, familyTag(p.Family))
 		case "location_done":
 			if p.Error != "" {
 				fmt.Printf("        ⚠️  %s\n", p.Error)
@@ -81,12 +121,27 @@ func runSweep(cmd *cobra.Command, args []string) {
 		}
 	})
 
+	var (
+		socket string
+		err    error
+	)
+	if sweepASPath {
+		if socket, err = aspath.SocketMode("", "icmp"); err == nil {
+			traceRoutes(reg, results, aspath.TraceMany)
+			if sweepPMTU {
+				// After the traces, so their bursts don't read as loss.
+				pathMTUs(reg, results)
+			}
+		}
+	}
+	now := time.Now()
 	if sweepJSON {
 		json.NewEncoder(os.Stdout).Encode(results)
 	} else {
 		printSweepTable(results)
 		if sweepASPath {
-			printASPaths(reg, results)
+			printASPaths(results, socket, err)
+			printRouteAlerts(sweepSnapshots(now, "", results))
 		}
 	}
 
@@ -94,9 +149,9 @@ func runSweep(cmd *cobra.Command, args []string) {
 		out := struct {
 			Timestamp time.Time               `json:"timestamp"`
 			Results   []engine.LocationResult `json:"results"`
-		}{time.Now(), results}
+		}{now, results}
 		if data, err := json.MarshalIndent(out, "", "  "); err == nil {
-			file := filepath.Join(outputDir, fmt.Sprintf("sweep_%s.json", time.Now().Format("2006-01-02_15-04-05")))
+			file := filepath.Join(outputDir, fmt.Sprintf("sweep_%s.json", now.Format("2006-01-02_15-04-05")))
 			os.WriteFile(file, data, 0644)
 			os.WriteFile(filepath.Join(outputDir, "sweep_latest.json"), data, 0644)
 			if !sweepJSON {
@@ -106,46 +161,194 @@ func runSweep(cmd *cobra.Command, args []string) {
 	}
 }
 
-// printASPaths traceroutes each location's download endpoint and prints the
-// AS-level path, every AS hyperlinked (OSC 8) to its PeeringDB entry.
-func printASPaths(reg *endpoints.Registry, results []engine.LocationResult) {
-	fmt.Printf("\nAS PATHS (via traceroute, each AS links to peeringdb)\n")
-	fmt.Println(strings.Repeat("─", 78))
-	for _, r := range results {
-		if r.DownloadVia == "" {
-			continue
+// loadRegistry loads the endpoint registry plus the config file's
+// endpoints and any given as LOCATION=KIND:HOST or LOCATION=KIND:URL
+// (--endpoint).
+func loadRegistry(extra []string) *endpoints.Registry {
+	reg, err := endpoints.Load()
+	if err != nil {
+		log.Fatalf("load endpoint registry: %v", err)
+	}
+	for _, e := range loadConfig().Endpoints {
+		if e.Location == "" || e.Kind == "" || e.Host == "" && e.URL == "" {
+			log.Fatalf("config endpoint %q: needs location, kind and host or url", e.Name)
 		}
-		host := endpointHost(reg, r.Location, r.DownloadVia)
-		if host == "" {
-			continue
+		if e.Name == "" {
+			e.Name = e.Host + e.URL
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
-		hops, err := aspath.Trace(ctx, host, 30, 700*time.Millisecond)
-		cancel()
-		if err == aspath.ErrNoPermission {
-			fmt.Println("skipped: raw ICMP needs privileges — run as root or:")
-			fmt.Println("  sudo setcap cap_net_raw+ep $(which intspeed)")
-			return
+		reg.Add(e.Location, e.Endpoint)
+	}
+	for _, v := range extra {
+		loc, spec, _ := strings.Cut(v, "=")
+		kind, addr, _ := strings.Cut(spec, ":")
+		if loc == "" || kind == "" || addr == "" {
+			log.Fatalf("--endpoint %q: want LOCATION=KIND:HOST or LOCATION=KIND:URL", v)
 		}
-		if err != nil {
-			fmt.Printf("%-13s trace failed: %v\n", r.Location, err)
-			continue
+		ep := endpoints.Endpoint{Name: addr, Kind: kind, Host: addr, Upload: true}
+		if strings.Contains(addr, "://") {
+			ep.Host, ep.URL = "", addr
 		}
-		path := aspath.ASPath(hops)
-		if len(path) == 0 {
-			fmt.Printf("%-13s no mappable hops\n", r.Location)
-			continue
+		reg.Add(loc, ep)
+	}
+	return reg
+}
+
+// pathMTUs discovers the path MTU toward each location's download
+// endpoint, over each family measured, and attaches it to the result. The
+// discoveries run at once: each has its own socket and waits mostly on
+// timeouts.
+func pathMTUs(reg *endpoints.Registry, results []engine.LocationResult) {
+	var wg sync.WaitGroup
+	for i := range results {
+		runs := []*engine.LocationResult{&results[i]}
+		if results[i].IPv6 != nil {
+			runs = append(runs, results[i].IPv6)
 		}
-		segs := make([]string, len(path))
-		for i, as := range path {
-			label := as.Name
-			if label == "" {
-				label = "as" + as.ASN
+		for _, run := range runs {
+			if run.DownloadVia == "" {
+				continue
+			}
+			host := endpointHost(reg, results[i].Location, run.DownloadVia)
+			if host == "" {
+				continue
 			}
-			segs[i] = osc8(aspath.PeeringDBURL(as.ASN), label)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+				defer cancel()
+				pmtu, err := aspath.PathMTU(ctx, host, aspath.Options{HopTimeout: 700 * time.Millisecond, Family: run.Family})
+				if err != nil {
+					run.PMTUError = err.Error()
+					return
+				}
+				run.PMTU = pmtu
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// traceRoutes traceroutes each location's download endpoint with trace
+// (aspath.TraceMany), over each family measured, and attaches the hops
+// and AS path to the result.
+func traceRoutes(reg *endpoints.Registry, results []engine.LocationResult, trace func(context.Context, []string, aspath.Options) ([][]aspath.Hop, []error)) {
+	// Every location is traced at once; one TraceMany per address family.
+	type job struct {
+		res  *engine.LocationResult
+		host string
+	}
+	var jobs []job
+	byFamily := map[string][]int{}
+	var families []string
+	for i := range results {
+		runs := []*engine.LocationResult{&results[i]}
+		if results[i].IPv6 != nil {
+			runs = append(runs, results[i].IPv6)
+		}
+		for _, run := range runs {
+			if run.DownloadVia == "" {
+				continue
+			}
+			host := endpointHost(reg, results[i].Location, run.DownloadVia)
+			if host == "" {
+				continue
+			}
+			if _, ok := byFamily[run.Family]; !ok {
+				families = append(families, run.Family)
+			}
+			run.TraceHost = host
+			byFamily[run.Family] = append(byFamily[run.Family], len(jobs))
+			jobs = append(jobs, job{res: run, host: host})
+		}
+	}
+	for _, family := range families {
+		idx := byFamily[family]
+		hosts := make([]string, len(idx))
+		for i, j := range idx {
+			hosts[i] = jobs[j].host
+		}
+		// Each family gets the whole budget: a slow IPv4 run mustn't leave
+		// IPv6 traces cut short.
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		hops, errs := trace(ctx, hosts, aspath.Options{HopTimeout: 700 * time.Millisecond, Family: family, Rate: sweepTraceRate, Resolver: asnResolver(), Cache: lookupCache(), PeeringDB: peeringDBData()})
+		for i, j := range idx {
+			res := jobs[j].res
+			if errs[i] != nil {
+				res.TraceError = errs[i].Error()
+				continue
+			}
+			res.Hops, res.ASPath = hops[i], aspath.ASPath(hops[i])
+		}
+		cancel()
+	}
+	saveLookupCache()
+}
+
+// printASPaths prints the AS path traceRoutes found per location, every
+// AS hyperlinked (OSC 8) to its PeeringDB entry, and the path MTU pathMTUs
+// found.
+func printASPaths(results []engine.LocationResult, socket string, err error) {
+	via := "traceroute"
+	if socket == "dgram" {
+		via = "unprivileged traceroute"
+	}
+	fmt.Printf("\nAS PATHS (via %s, each AS links to peeringdb)\n", via)
+	fmt.Println(strings.Repeat("─", 78))
+	if err == aspath.ErrNoPermission {
+		printPrivilegeHint("skipped: ", "icmp")
+		return
+	}
+
+	for _, r := range results {
+		runs := []engine.LocationResult{r}
+		if r.IPv6 != nil {
+			runs = append(runs, *r.IPv6)
+		}
+		for _, run := range runs {
+			label := r.Location
+			if r.IPv6 != nil {
+				label += " " + run.Family
+			}
+			switch {
+			case run.TraceError != "":
+				fmt.Printf("%-13s trace failed: %s\n", label, run.TraceError)
+			case run.Hops == nil:
+			case len(run.ASPath) == 0:
+				fmt.Printf("%-13s no mappable hops\n", label)
+			default:
+				fmt.Printf("%-13s %s\n", label, formatASPath(run.ASPath))
+			}
+			switch {
+			case run.PMTUError != "":
+				fmt.Printf("%-13s path MTU: %s\n", "", run.PMTUError)
+			case run.PMTU != nil:
+				fmt.Printf("%-13s path MTU: %s\n", "", describePMTU(run.PMTU, run.Hops))
+			}
+		}
+	}
+}
+
+// formatASPath renders an AS path as linked names, marking where it
+// crossed an exchange: "cogent →[DE-CIX Frankfurt]→ ntt".
+func formatASPath(path []aspath.AS) string {
+	var b strings.Builder
+	for i, as := range path {
+		switch {
+		case i > 0 && as.IXP != "":
+			b.WriteString(" →[" + as.IXP + "]→ ")
+		case i > 0:
+			b.WriteString(" → ")
+		case as.IXP != "":
+			b.WriteString("[" + as.IXP + "]→ ")
+		}
+		name := as.Name
+		if name == "" {
+			name = "as" + as.ASN
 		}
-		fmt.Printf("%-13s %s\n", r.Location, strings.Join(segs, " → "))
+		b.WriteString(osc8(aspath.PeeringDBURL(as.ASN), name))
 	}
+	return b.String()
 }
 
 // osc8 wraps text in an OSC 8 terminal hyperlink.
@@ -164,7 +367,11 @@ func endpointHost(reg *endpoints.Registry, location, name string) string {
 			continue
 		}
 		if e.Host != "" {
-			return strings.Split(e.Host, ":")[0]
+			// host:port, [v6]:port, or a bare host, which may be IPv6.
+			if h, _, err := net.SplitHostPort(e.Host); err == nil {
+				return h
+			}
+			return strings.Trim(e.Host, "[]")
 		}
 		if u, err := url.Parse(e.URL); err == nil {
 			return u.Hostname()
@@ -184,4 +391,166 @@ func printSweepTable(results []engine.LocationResult) {
 		fmt.Printf("%-13s %7.1fms %6.1fms %7.1f Mb %7.1f Mb   %s\n",
 			r.Location, r.LatencyMs, r.JitterMs, r.DownloadMbps, r.UploadMbps, r.DownloadVia)
 	}
+	if sweepFamily == engine.FamilyBoth {
+		printFamilyTable(results)
+	}
+	if sweepPhases {
+		printPhasesTable(results)
+	}
+	if sweepUDPMbps > 0 {
+		printUDPTable(results)
+	}
+	if sweepLoaded {
+		printLoadedTable(results)
+	}
+	if sweepLossProbes > 0 {
+		printLossTable(results)
+	}
+}
+
+// familyTag labels progress lines when the sweep is family-restricted.
+func familyTag(family string) string {
+	if family == "" {
+		return ""
+	}
+	return " (" + family + ")"
+}
+
+// printFamilyTable puts each location's IPv4 and IPv6 results side by
+// side. A large latency gap usually means the two families take different
+// transit; the AS paths show which.
+func printFamilyTable(results []engine.LocationResult) {
+	fmt.Printf("\n%-13s %9s %9s %10s %10s %10s %10s\n", "V4 / V6", "PING v4", "PING v6", "DOWN v4", "DOWN v6", "UP v4", "UP v6")
+	fmt.Println(strings.Repeat("─", 78))
+	for _, r := range results {
+		v6 := r.IPv6
+		if v6 == nil {
+			v6 = &engine.LocationResult{}
+		}
+		ms := func(v float64) string {
+			if v == 0 {
+				return fmt.Sprintf("%9s", "—")
+			}
+			return fmt.Sprintf("%7.1fms", v)
+		}
+		mb := func(v float64) string {
+			if v == 0 {
+				return fmt.Sprintf("%10s", "—")
+			}
+			return fmt.Sprintf("%7.1f Mb", v)
+		}
+		fmt.Printf("%-13s %s %s %s %s %s %s\n", r.Location,
+			ms(r.LatencyMs), ms(v6.LatencyMs), mb(r.DownloadMbps), mb(v6.DownloadMbps), mb(r.UploadMbps), mb(v6.UploadMbps))
+	}
+}
+
+// familyRows flattens FamilyBoth results for the detail tables: each
+// IPv6 measurement follows its IPv4 one, and both are labelled with their
+// family.
+func familyRows(results []engine.LocationResult) []engine.LocationResult {
+	out := make([]engine.LocationResult, 0, len(results))
+	for _, r := range results {
+		if r.IPv6 == nil {
+			out = append(out, r)
+			continue
+		}
+		v6 := *r.IPv6
+		r.Location += " " + r.Family
+		v6.Location += " " + v6.Family
+		out = append(out, r, v6)
+	}
+	return out
+}
+
+// printPhasesTable breaks each endpoint's latency into connection setup
+// phases; e.g. a normal TCP connect with a slow TLS handshake points at
+// middlebox interference rather than distance. Setup phases show "—" for
+// endpoints whose pings all reused an open connection.
+func printPhasesTable(results []engine.LocationResult) {
+	fmt.Printf("\n%-13s %-22s %8s %8s %8s %8s\n", "PHASES", "ENDPOINT", "DNS", "TCP", "TLS", "TTFB")
+	fmt.Println(strings.Repeat("─", 78))
+	for _, r := range familyRows(results) {
+		for _, e := range r.Endpoints {
+			if e.Error != "" {
+				continue
+			}
+			setup := func(v float64) string {
+				if e.Reused {
+					return fmt.Sprintf("%8s", "—")
+				}
+				return fmt.Sprintf("%6.1fms", v)
+			}
+			fmt.Printf("%-13s %-22.22s %s %s %s %6.1fms\n",
+				r.Location, e.Name, setup(e.DNSMs), setup(e.ConnectMs), setup(e.TLSMs), e.TTFBMs)
+		}
+	}
+}
+
+// printUDPTable lists receiver-side loss and jitter of UDP throughput
+// sessions (iperf3 endpoints only).
+func printUDPTable(results []engine.LocationResult) {
+	fmt.Printf("\n%-13s %9s %9s %9s %9s\n", "UDP", "DN LOSS", "DN JIT", "UP LOSS", "UP JIT")
+	fmt.Println(strings.Repeat("─", 78))
+	for _, r := range familyRows(results) {
+		if r.DownloadUDP == nil && r.UploadUDP == nil {
+			continue
+		}
+		cell := func(u *engine.UDPStats) string {
+			if u == nil {
+				return fmt.Sprintf("%9s %9s", "—", "—")
+			}
+			return fmt.Sprintf("%8.2f%% %7.2fms", u.LossPercent, u.JitterMs)
+		}
+		fmt.Printf("%-13s %s %s\n", r.Location, cell(r.DownloadUDP), cell(r.UploadUDP))
+	}
+}
+
+// printLoadedTable sets the median idle latency to each transfer's endpoint
+// against the median while saturating that direction; a large rise means
+// deep, unmanaged queues on the path.
+func printLoadedTable(results []engine.LocationResult) {
+	fmt.Printf("\n%-13s %9s %9s %9s %9s %8s\n", "LOADED", "IDLE ↓", "DOWN", "IDLE ↑", "UP", "RPM")
+	fmt.Println(strings.Repeat("─", 78))
+	ms := func(v float64) string {
+		if v == 0 {
+			return "—"
+		}
+		return fmt.Sprintf("%.1fms", v)
+	}
+	for _, r := range familyRows(results) {
+		if r.ResponsivenessRPM == 0 {
+			continue
+		}
+		fmt.Printf("%-13s %9s %9s %9s %9s %8.0f\n", r.Location,
+			ms(r.IdleDownloadMs), ms(r.LoadedDownloadMs), ms(r.IdleUploadMs), ms(r.LoadedUploadMs), r.ResponsivenessRPM)
+	}
+}
+
+// hasKind reports whether any location in reg has an endpoint of kind.
+func hasKind(reg *endpoints.Registry, kind string) bool {
+	for _, l := range reg.Locations {
+		for _, e := range l.Endpoints {
+			if e.Kind == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// printLossTable shows reflector probe results split by direction: loss on
+// the way out only is typically the uplink or the user's ISP egress, loss
+// on the way back the far side or the return route. TAIL is loss at the
+// end of the run that the reflector's counter can't place.
+func printLossTable(results []engine.LocationResult) {
+	fmt.Printf("\n%-13s %8s %7s %7s %7s %7s %7s %9s %9s\n", "LOSS", "TOTAL", "FWD", "RET", "TAIL", "REORD", "DUP", "FWD JIT", "RET JIT")
+	fmt.Println(strings.Repeat("─", 86))
+	for _, r := range familyRows(results) {
+		l := r.Loss
+		if l == nil {
+			continue
+		}
+		fmt.Printf("%-13s %7.2f%% %7d %7d %7d %7d %7d %7.2fms %7.2fms\n",
+			r.Location, l.LossPercent, l.ForwardLost, l.ReturnLost, l.UnknownLost, l.Reordered, l.Duplicates, l.ForwardJitterMs, l.ReturnJitterMs)
+	}
 }
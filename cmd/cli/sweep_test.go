@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/engine"
+)
+
+func TestFamilyRows(t *testing.T) {
+	results := []engine.LocationResult{
+		{Location: "Frankfurt", Family: engine.FamilyV4, LatencyMs: 10,
+			IPv6: &engine.LocationResult{Location: "Frankfurt", Family: engine.FamilyV6, LatencyMs: 12}},
+		{Location: "Tokyo", Family: engine.FamilyV4, LatencyMs: 200}, // v6 cut short
+		{Location: "Paris", LatencyMs: 15},                           // single-family sweep
+	}
+	var got []string
+	var ms []float64
+	for _, r := range familyRows(results) {
+		got = append(got, r.Location)
+		ms = append(ms, r.LatencyMs)
+	}
+	if want := []string{"Frankfurt v4", "Frankfurt v6", "Tokyo", "Paris"}; !slices.Equal(got, want) {
+		t.Errorf("rows %q, want %q", got, want)
+	}
+	if want := []float64{10, 12, 200, 15}; !slices.Equal(ms, want) {
+		t.Errorf("latencies %v, want %v", ms, want)
+	}
+	if results[0].Location != "Frankfurt" || results[0].IPv6.Location != "Frankfurt" {
+		t.Error("familyRows relabelled the results themselves")
+	}
+}
+
+func TestTraceRoutes(t *testing.T) {
+	// Keep the lookup cache and config away from the user's.
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	reg := &endpoints.Registry{Locations: []endpoints.LocationEndpoints{
+		{Name: "Frankfurt", Endpoints: []endpoints.Endpoint{{Name: "ep", URL: "https://fra.example.net/"}}},
+		{Name: "Tokyo", Endpoints: []endpoints.Endpoint{{Name: "ep", Host: "tyo.example.net:5201"}}},
+	}}
+	results := []engine.LocationResult{
+		{Location: "Frankfurt", Family: engine.FamilyV4, DownloadVia: "ep",
+			IPv6: &engine.LocationResult{Location: "Frankfurt", Family: engine.FamilyV6, DownloadVia: "ep"}},
+		{Location: "Tokyo", Family: engine.FamilyV4, DownloadVia: "ep"},
+	}
+
+	// A tracer whose hops say which family traced them, and which keeps
+	// IPv4 busy a while.
+	deadlines := map[string]time.Time{}
+	var traced []string
+	trace := func(ctx context.Context, hosts []string, opts aspath.Options) ([][]aspath.Hop, []error) {
+		deadlines[opts.Family], _ = ctx.Deadline()
+		hops, errs := make([][]aspath.Hop, len(hosts)), make([]error, len(hosts))
+		for i, host := range hosts {
+			traced = append(traced, host+" "+opts.Family)
+			if host == "tyo.example.net" {
+				errs[i] = fmt.Errorf("no route")
+				continue
+			}
+			asn := map[string]string{engine.FamilyV4: "64500", engine.FamilyV6: "64506"}[opts.Family]
+			hops[i] = []aspath.Hop{{TTL: 1, IP: "192.0.2.1", ASN: asn}}
+		}
+		if opts.Family == engine.FamilyV4 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return hops, errs
+	}
+	traceRoutes(reg, results, trace)
+
+	if want := []string{"fra.example.net v4", "tyo.example.net v4", "fra.example.net v6"}; !slices.Equal(traced, want) {
+		t.Errorf("traced %q, want %q", traced, want)
+	}
+	fra, fra6 := results[0], results[0].IPv6
+	if fra.TraceHost != "fra.example.net" || len(fra.ASPath) != 1 || fra.ASPath[0].ASN != "64500" {
+		t.Errorf("Frankfurt v4: host %q, path %+v; want the IPv4 trace", fra.TraceHost, fra.ASPath)
+	}
+	if fra6.TraceHost != "fra.example.net" || len(fra6.ASPath) != 1 || fra6.ASPath[0].ASN != "64506" {
+		t.Errorf("Frankfurt v6: host %q, path %+v; want the IPv6 trace", fra6.TraceHost, fra6.ASPath)
+	}
+	if tyo := results[1]; tyo.TraceError != "no route" || tyo.Hops != nil {
+		t.Errorf("Tokyo: error %q, hops %+v", tyo.TraceError, tyo.Hops)
+	}
+	// IPv6 got a timeout of its own, not what IPv4 left of a shared one.
+	if d := deadlines[engine.FamilyV6].Sub(deadlines[engine.FamilyV4]); d < 50*time.Millisecond {
+		t.Errorf("IPv6 deadline only %v after IPv4's", d)
+	}
+}
@@ -2,17 +2,58 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rotkonetworks/intspeed/pkg/aspath"
 	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/iperf3"
 	"github.com/spf13/cobra"
 )
 
-var traceEndpoint string
+var (
+	traceEndpoint string
+	traceFamily   string
+	traceProto    string
+	traceMode     string
+	traceCycles   int
+	traceWatch    bool
+	traceJSON     bool
+	traceRate     int
+	tracePMTU     bool
+	traceReverse  string
+)
+
+// traceReport is one traced target, as printed by --json and saved to the
+// output directory.
+type traceReport struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Location  string            `json:"location"`
+	Endpoint  string            `json:"endpoint"`
+	Host      string            `json:"host"`
+	Family    string            `json:"family,omitempty"`
+	Proto     string            `json:"proto"`
+	Mode      string            `json:"mode"`
+	Socket    string            `json:"socket"` // "raw", or "dgram" when unprivileged
+	Hops      []aspath.Hop      `json:"hops,omitempty"`
+	Stats     []aspath.HopStats `json:"stats,omitempty"` // --cycles / --watch
+	ASPath    []aspath.AS       `json:"as_path,omitempty"`
+	Anomalies []aspath.Anomaly  `json:"anomalies,omitempty"`
+	PMTU      *aspath.PMTU      `json:"pmtu,omitempty"` // --pmtu
+	PMTUError string            `json:"pmtu_error,omitempty"`
+	Reverse   *reverseTrace     `json:"reverse,omitempty"` // --reverse
+	Error     string            `json:"error,omitempty"`
+}
 
 func newTraceCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -22,19 +63,56 @@ func newTraceCmd() *cobra.Command {
 		Run:   runTrace,
 	}
 	cmd.Flags().StringVar(&traceEndpoint, "endpoint", "", "Endpoint name within the location (default: first)")
+	cmd.Flags().StringVar(&traceProto, "proto", "icmp", "Probe protocol: icmp, udp, or tcp (SYN to the endpoint's own port; slower in paris and mda modes, one TTL in flight per flow)")
+	cmd.Flags().StringVar(&traceMode, "mode", "classic", "Load-balancer handling: classic, paris (one consistent flow), or mda (enumerate ECMP branches)")
+	cmd.Flags().StringVar(&traceFamily, "family", "", "Address family: v4, v6, or both (default: v4 if the endpoint has it)")
+	cmd.Flags().IntVar(&traceCycles, "cycles", 0, "mtr mode: probe every hop this many times, one cycle per second, and report loss and RTT stats")
+	cmd.Flags().BoolVar(&traceWatch, "watch", false, "mtr mode until interrupted, with a live-refreshing table")
+	cmd.Flags().IntVar(&traceRate, "rate", 100, "Max probes per second (0 = unlimited)")
+	cmd.Flags().BoolVar(&tracePMTU, "pmtu", false, "Also discover the path MTU with DF probes and the hop that limits it")
+	cmd.Flags().StringVar(&traceReverse, "reverse", "", "RIPE Atlas traceroute results (JSON) from a probe near the endpoint back to you, to compare the return path")
+	cmd.Flags().BoolVar(&traceJSON, "json", false, "Print raw JSON results to stdout")
 	return cmd
 }
 
 func runTrace(cmd *cobra.Command, args []string) {
-	reg, err := endpoints.Load()
-	if err != nil {
-		log.Fatalf("load endpoint registry: %v", err)
+	reg := loadRegistry(nil)
+
+	families := []string{traceFamily}
+	switch traceFamily {
+	case "", "v4", "v6":
+	case "both":
+		families = []string{"v4", "v6"}
+	default:
+		log.Fatalf("--family must be v4, v6 or both, not %q", traceFamily)
+	}
+
+	switch traceProto {
+	case "icmp", "udp", "tcp":
+	default:
+		log.Fatalf("--proto must be icmp, udp or tcp, not %q", traceProto)
+	}
+
+	switch traceMode {
+	case "classic", "paris", "mda":
+	default:
+		log.Fatalf("--mode must be classic, paris or mda, not %q", traceMode)
+	}
+
+	if (traceCycles > 0 || traceWatch) && traceMode == "mda" {
+		log.Fatalf("--mode mda enumerates branches once; use classic or paris with --cycles/--watch")
 	}
 
 	target := "all"
 	if len(args) > 0 {
 		target = args[0]
 	}
+	if traceWatch && strings.EqualFold(target, "all") {
+		log.Fatalf("--watch needs a single location")
+	}
+	if traceReverse != "" && strings.EqualFold(target, "all") {
+		log.Fatalf("--reverse needs a single location")
+	}
 
 	var locs []endpoints.LocationEndpoints
 	if strings.EqualFold(target, "all") {
@@ -47,11 +125,49 @@ func runTrace(cmd *cobra.Command, args []string) {
 		locs = []endpoints.LocationEndpoints{*loc}
 	}
 
+	// Interrupting an mtr run ends it with the report so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var reverse []aspath.AtlasTrace
+	if traceReverse != "" {
+		reverse = loadReverse(ctx, traceReverse)
+	}
+
+	var reports []traceReport
 	for i, loc := range locs {
-		if i > 0 {
-			fmt.Println()
+		for j, family := range families {
+			if ctx.Err() != nil {
+				break
+			}
+			if !traceJSON && (i > 0 || j > 0) {
+				fmt.Println()
+			}
+			if r, ok := traceLocation(ctx, reg, loc, family, reverse); ok {
+				reports = append(reports, r)
+			}
+		}
+	}
+	saveLookupCache()
+
+	if traceJSON {
+		json.NewEncoder(os.Stdout).Encode(reports)
+	}
+	if len(reports) == 0 {
+		return
+	}
+	if !traceJSON {
+		printRouteAlerts(traceSnapshots("", reports))
+	}
+	if err := os.MkdirAll(outputDir, 0755); err == nil {
+		if data, err := json.MarshalIndent(reports, "", "  "); err == nil {
+			file := filepath.Join(outputDir, fmt.Sprintf("trace_%s.json", time.Now().Format("2006-01-02_15-04-05")))
+			os.WriteFile(file, data, 0644)
+			os.WriteFile(filepath.Join(outputDir, "trace_latest.json"), data, 0644)
+			if !traceJSON {
+				fmt.Printf("\n📊 Results saved: %s\n", file)
+			}
 		}
-		traceLocation(reg, loc)
 	}
 }
 
@@ -64,7 +180,10 @@ func canonicalName(reg *endpoints.Registry, name string) string {
 	return name
 }
 
-func traceLocation(reg *endpoints.Registry, loc endpoints.LocationEndpoints) {
+// traceLocation traces one location's endpoint, printing as it goes unless
+// --json is set, and sets the return path from reverse beside it. ok is
+// false when there was nothing to trace.
+func traceLocation(ctx context.Context, reg *endpoints.Registry, loc endpoints.LocationEndpoints, family string, reverse []aspath.AtlasTrace) (traceReport, bool) {
 	ep := loc.Endpoints[0]
 	if traceEndpoint != "" {
 		found := false
@@ -80,55 +199,402 @@ func traceLocation(reg *endpoints.Registry, loc endpoints.LocationEndpoints) {
 	}
 	host := endpointHost(reg, loc.Name, ep.Name)
 	if host == "" {
-		fmt.Printf("%s: no resolvable endpoint\n", loc.Name)
-		return
+		if !traceJSON {
+			fmt.Printf("%s: no resolvable endpoint\n", loc.Name)
+		}
+		return traceReport{}, false
 	}
 
-	fmt.Printf("trace to %s (%s · %s)\n", host, loc.Name, ep.Name)
-	fmt.Printf("%4s  %-15s %9s  %-22s %s\n", "TTL", "IP", "RTT", "AS", "PTR")
-	fmt.Println(strings.Repeat("─", 96))
-
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-	hops, err := aspath.Trace(ctx, host, 30, 800*time.Millisecond)
+	opts := aspath.Options{Family: family, Proto: traceProto, Mode: traceMode, Rate: traceRate, Resolver: asnResolver(), Cache: lookupCache(), PeeringDB: peeringDBData()}
+	title := fmt.Sprintf("%s · %s", loc.Name, ep.Name)
+	if family != "" {
+		title += " · " + family
+	}
+	switch traceProto {
+	case "tcp":
+		opts.Port = endpointPort(ep)
+		title += fmt.Sprintf(" · tcp/%d", opts.Port)
+	case "udp":
+		title += " · udp"
+	}
+	if traceMode != "classic" {
+		title += " · " + traceMode
+	}
+	socket, err := aspath.SocketMode(family, traceProto)
 	if err == aspath.ErrNoPermission {
-		fmt.Println("raw ICMP needs privileges — run as root or:")
-		fmt.Println("  sudo setcap cap_net_raw+ep $(which intspeed)")
-		return
+		if !traceJSON {
+			printPrivilegeHint("", traceProto)
+		}
+		return traceReport{}, false
+	}
+	if socket == "dgram" {
+		title += " · unprivileged"
+	}
+	title = fmt.Sprintf("trace to %s (%s)", host, title)
+	report := traceReport{
+		Timestamp: time.Now(),
+		Location:  loc.Name,
+		Endpoint:  ep.Name,
+		Host:      host,
+		Family:    family,
+		Proto:     traceProto,
+		Mode:      traceMode,
+		Socket:    socket,
+	}
+
+	var hops []aspath.Hop
+	if traceCycles > 0 || traceWatch {
+		report.Stats, err = watchLocation(ctx, host, opts, title)
+		hops = statsHops(report.Stats)
+	} else {
+		if !traceJSON {
+			fmt.Println(title)
+		}
+		tctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		hops, err = aspath.Trace(tctx, host, opts)
+		cancel()
+		report.Hops = hops
 	}
 	if err != nil {
-		fmt.Printf("trace failed: %v\n", err)
-		return
+		if !traceJSON {
+			fmt.Printf("trace failed: %v\n", err)
+		}
+		report.Error = err.Error()
+		return report, true
+	}
+	report.ASPath = aspath.ASPath(hops)
+	report.Anomalies = aspath.CheckPath(hops)
+	report.Reverse = pickReverse(reverse, family, report.ASPath)
+	if tracePMTU && ctx.Err() == nil {
+		pctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		report.PMTU, err = aspath.PathMTU(pctx, host, opts)
+		cancel()
+		if err != nil {
+			report.PMTUError = err.Error()
+		}
+	}
+	if traceJSON {
+		return report, true
 	}
 
+	if report.Stats == nil {
+		printHops(hops)
+	}
+	if len(report.ASPath) > 0 {
+		fmt.Printf("\nas-path: %s\n", formatASPath(report.ASPath))
+	}
+	if report.PMTUError != "" {
+		fmt.Printf("path MTU: %s\n", report.PMTUError)
+	} else if report.PMTU != nil {
+		fmt.Printf("path MTU: %s\n", describePMTU(report.PMTU, hops))
+	}
+	if report.Reverse != nil {
+		printReverse(report.Reverse, report.ASPath)
+	}
+	return report, true
+}
+
+// printPrivilegeHint says how to let traces with proto run without root:
+// the capability, or for ICMP on Linux the ping sockets sysctl.
+func printPrivilegeHint(prefix, proto string) {
+	if proto == "icmp" && runtime.GOOS == "linux" {
+		fmt.Println(prefix + "tracing needs privileges — run as root, or allow unprivileged ICMP:")
+		fmt.Println("  sudo sysctl net.ipv4.ping_group_range=\"0 2147483647\"")
+		fmt.Println("or grant raw sockets:")
+	} else {
+		fmt.Println(prefix + "raw ICMP needs privileges — run as root or:")
+	}
+	fmt.Println("  sudo setcap cap_net_raw+ep $(which intspeed)")
+}
+
+// describePMTU explains a path MTU result, naming the limiting hop as it
+// appears in the trace.
+func describePMTU(p *aspath.PMTU, hops []aspath.Hop) string {
+	hop := fmt.Sprintf("TTL %d", p.LimitTTL)
 	for _, h := range hops {
-		if h.IP == "" {
-			fmt.Printf("%4d  %-15s %9s\n", h.TTL, "*", "")
+		if h.TTL == p.LimitTTL && h.IP != "" {
+			hop = fmt.Sprintf("TTL %d %s", h.TTL, h.IP)
+			if h.PTR != "" {
+				hop += " (" + h.PTR + ")"
+			}
+		}
+	}
+	switch {
+	case p.Reason == "":
+		return fmt.Sprintf("%d (no limit up to %d, %d probes)", p.MTU, p.Max, p.Probes)
+	case p.Reason == "local":
+		return fmt.Sprintf("%d, limited by the local interface", p.MTU)
+	case p.Reason == "too-big" && p.LimitTTL > 0:
+		return fmt.Sprintf("%d ⚠ %s reports next-hop MTU %d", p.MTU, hop, p.ReportedMTU)
+	case p.Reason == "too-big":
+		return fmt.Sprintf("%d ⚠ %s reports next-hop MTU %d", p.MTU, p.LimitIP, p.ReportedMTU)
+	case p.LimitTTL > 0:
+		return fmt.Sprintf("%d ⚠ black hole: larger packets vanish without an ICMP error before %s", p.MTU, hop)
+	default:
+		return fmt.Sprintf("%d ⚠ black hole: larger packets vanish without an ICMP error", p.MTU)
+	}
+}
+
+// watchLocation runs the mtr loop. On a terminal the table is redrawn in
+// place after every cycle; the final one stays on screen as the report.
+func watchLocation(ctx context.Context, host string, opts aspath.Options, title string) ([]aspath.HopStats, error) {
+	live := false
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		live = !traceJSON
+	}
+	cycle := 0
+	stats, err := aspath.Watch(ctx, host, opts, traceCycles, time.Second, func(s []aspath.HopStats) {
+		cycle++
+		if live {
+			fmt.Print("\x1b[H\x1b[2J") // home, clear screen
+			fmt.Println(title)
+			printStats(s)
+			fmt.Printf("\ncycle %d — Ctrl-C to stop\n", cycle)
+		}
+	})
+	if err != nil || traceJSON {
+		return stats, err
+	}
+	if live {
+		fmt.Print("\x1b[H\x1b[2J")
+	}
+	fmt.Printf("%s — %d cycles\n", title, cycle)
+	printStats(stats)
+	return stats, nil
+}
+
+// printHops prints a single trace. Load-balanced TTLs print one row per
+// branch, joined by a bracket and annotated with the share of flows that
+// took each when known.
+func printHops(hops []aspath.Hop) {
+	// IPv6 hops need a wider column than the classic 15.
+	ipw := 15
+	for _, h := range hops {
+		ipw = max(ipw, len(h.IP))
+		for _, b := range h.Branches {
+			ipw = max(ipw, len(b.IP))
+		}
+	}
+	fmt.Printf("%4s  %-*s %9s  %-22s %s\n", "TTL", ipw+2, "IP", "RTT", "AS", "PTR")
+	fmt.Println(strings.Repeat("─", 83+ipw))
+
+	marks := anomalyMarks(hops)
+	var diamonds []string
+	for _, h := range hops {
+		if len(h.Branches) == 0 {
+			fmt.Println(hopRow(fmt.Sprint(h.TTL), "  ", h, ipw) + marks[h.TTL])
 			continue
 		}
-		as := ""
-		if h.ASN != "" {
-			label := h.ASName
-			if label == "" {
-				label = "as" + h.ASN
+		total := 0
+		for _, b := range h.Branches {
+			total += len(b.Flows)
+		}
+		for i, b := range h.Branches {
+			ttl, glyph, mark := "", "├ ", ""
+			switch i {
+			case 0:
+				ttl, glyph, mark = fmt.Sprint(h.TTL), "┌ ", marks[h.TTL]
+			case len(h.Branches) - 1:
+				glyph = "└ "
+			}
+			share := ""
+			if total > 0 { // imported traces don't say which flow went where
+				share = fmt.Sprintf("  [%d/%d flows]", len(b.Flows), total)
 			}
-			as = osc8(aspath.PeeringDBURL(h.ASN), fmt.Sprintf("%-10s", label)) + fmt.Sprintf(" %-11s", "("+h.ASN+")")
-		} else {
-			as = fmt.Sprintf("%-22s", "—")
+			fmt.Printf("%s%s%s\n", hopRow(ttl, glyph, b, ipw), share, mark)
 		}
-		fmt.Printf("%4d  %-15s %8.1fms  %s %s\n", h.TTL, h.IP, h.RTTMs, as, h.PTR)
+		diamonds = append(diamonds, fmt.Sprintf("TTL %d ×%d", h.TTL, len(h.Branches)))
 	}
+	if len(diamonds) > 0 {
+		fmt.Printf("\nload-balanced: %s\n", strings.Join(diamonds, ", "))
+	}
+	printTunnels(hops)
+	printAnomalies(hops)
+}
+
+// printStats prints mtr --report style per-hop statistics.
+func printStats(stats []aspath.HopStats) {
+	ipw := 15
+	for _, s := range stats {
+		ipw = max(ipw, len(s.IP))
+	}
+	fmt.Printf("%4s  %-*s %6s %5s %7s %7s %7s %7s %7s  %-22s %s\n",
+		"TTL", ipw, "IP", "LOSS%", "SNT", "LAST", "AVG", "BEST", "WRST", "STDEV", "AS", "PTR")
+	fmt.Println(strings.Repeat("─", 120+ipw-15))
+	hops := statsHops(stats)
+	marks := anomalyMarks(hops)
+	for _, s := range stats {
+		if s.Received == 0 {
+			fmt.Printf("%4d  %-*s %5.1f%% %5d\n", s.TTL, ipw, "*", s.LossPercent, s.Sent)
+			continue
+		}
+		fmt.Printf("%4d  %-*s %5.1f%% %5d %7.1f %7.1f %7.1f %7.1f %7.1f  %s %s%s\n",
+			s.TTL, ipw, s.IP, s.LossPercent, s.Sent, s.LastMs, s.AvgMs, s.BestMs, s.WorstMs, s.StdDevMs, asCell(s.Hop), s.PTR, ixpCell(s.Hop)+mplsCell(s.Hop)+marks[s.TTL])
+	}
+	printTunnels(hops)
+	printAnomalies(hops)
+}
 
-	path := aspath.ASPath(hops)
-	if len(path) > 0 {
-		segs := make([]string, len(path))
-		for i, as := range path {
-			label := as.Name
-			if label == "" {
-				label = "as" + as.ASN
+// statsHops turns mtr records into hops timed by their best RTT.
+func statsHops(stats []aspath.HopStats) []aspath.Hop {
+	hops := make([]aspath.Hop, len(stats))
+	for i, s := range stats {
+		hops[i] = s.Hop
+		hops[i].RTTMs = s.BestMs
+	}
+	return hops
+}
+
+// printAnomalies lists where the path's locations and RTTs don't add up;
+// anomalyMarks flags the same hops in the table.
+func printAnomalies(hops []aspath.Hop) {
+	anomalies := aspath.CheckPath(hops)
+	if len(anomalies) == 0 {
+		return
+	}
+	fmt.Println("\npath checks:")
+	for _, a := range anomalies {
+		fmt.Printf("  ⚠ TTL %d %s: %s\n", a.TTL, a.Kind, a.Detail)
+	}
+}
+
+func anomalyMarks(hops []aspath.Hop) map[int]string {
+	marks := map[int]string{}
+	for _, a := range aspath.CheckPath(hops) {
+		if marks[a.TTL] == "" {
+			marks[a.TTL] = "  ⚠ " + a.Kind
+		}
+	}
+	return marks
+}
+
+// printTunnels summarizes the runs of consecutive hops that quoted MPLS
+// labels: where a carrier's label-switched core begins and ends. Label
+// switches that don't decrement the IP TTL are invisible inside a run.
+func printTunnels(hops []aspath.Hop) {
+	labelled := func(h aspath.Hop) bool {
+		if len(h.MPLS) > 0 {
+			return true
+		}
+		for _, b := range h.Branches {
+			if len(b.MPLS) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	var spans []string
+	for i := 0; i < len(hops); i++ {
+		if !labelled(hops[i]) {
+			continue
+		}
+		j := i
+		for j+1 < len(hops) && labelled(hops[j+1]) {
+			j++
+		}
+		span := fmt.Sprintf("TTL %d", hops[i].TTL)
+		if j > i {
+			span = fmt.Sprintf("TTL %d–%d", hops[i].TTL, hops[j].TTL)
+		}
+		if as := aspath.ASPath(hops[i : j+1]); len(as) > 0 {
+			names := make([]string, len(as))
+			for k, a := range as {
+				names[k] = a.Name
+				if names[k] == "" {
+					names[k] = "as" + a.ASN
+				}
 			}
-			segs[i] = osc8(aspath.PeeringDBURL(as.ASN), label)
+			span += " (" + strings.Join(names, " → ") + ")"
 		}
-		fmt.Printf("\nas-path: %s\n", strings.Join(segs, " → "))
+		spans = append(spans, span)
+		i = j
+	}
+	if len(spans) > 0 {
+		fmt.Printf("\nmpls: %s\n", strings.Join(spans, ", "))
+	}
+}
+
+// hopRow formats one traced interface; glyph marks load-balanced branches.
+func hopRow(ttl, glyph string, h aspath.Hop, ipw int) string {
+	if h.IP == "" {
+		return fmt.Sprintf("%4s  %s%-*s %9s", ttl, glyph, ipw, "*", "")
+	}
+	return fmt.Sprintf("%4s  %s%-*s %8.1fms  %s %s%s%s", ttl, glyph, ipw, h.IP, h.RTTMs, asCell(h), h.PTR, ixpCell(h), mplsCell(h))
+}
+
+// ixpCell names the exchange a hop sits on and the candidate buildings.
+func ixpCell(h aspath.Hop) string {
+	if h.IXP == nil {
+		return ""
+	}
+	cell := " [IXP " + h.IXP.Name
+	if h.IXP.City != "" && !strings.Contains(h.IXP.Name, h.IXP.City) {
+		cell += ", " + h.IXP.City
+	}
+	if fs := h.IXP.Facilities; len(fs) > 0 {
+		cell += " · " + strings.Join(fs[:min(len(fs), 2)], " / ")
+		if len(fs) > 2 {
+			cell += fmt.Sprintf(" +%d", len(fs)-2)
+		}
+	}
+	return cell + "]"
+}
+
+// mplsCell is a hop's quoted MPLS label stack, top first, or "".
+func mplsCell(h aspath.Hop) string {
+	if len(h.MPLS) == 0 {
+		return ""
+	}
+	entries := make([]string, len(h.MPLS))
+	for i, l := range h.MPLS {
+		entries[i] = fmt.Sprintf("L=%d TTL=%d", l.Label, l.TTL)
+		if l.TC != 0 {
+			entries[i] += fmt.Sprintf(" TC=%d", l.TC)
+		}
+	}
+	return " [MPLS " + strings.Join(entries, ", ") + "]"
+}
+
+// asCell is a hop's 22-column AS label, linked to PeeringDB. Exchange
+// LAN hops show the member network whose port answered.
+func asCell(h aspath.Hop) string {
+	if h.IXP != nil && h.IXP.MemberASN != "" {
+		h.ASN, h.ASName = h.IXP.MemberASN, h.IXP.MemberName
+	}
+	if h.ASN == "" {
+		return fmt.Sprintf("%-22s", "—")
+	}
+	label := h.ASName
+	if label == "" {
+		label = "as" + h.ASN
+	}
+	return osc8(aspath.PeeringDBURL(h.ASN), fmt.Sprintf("%-10s", label)) + fmt.Sprintf(" %-11s", "("+h.ASN+")")
+}
+
+// endpointPort is the TCP port the speed test itself connects to, so TCP
+// probes take the same path through per-flow balancers and filters.
+func endpointPort(ep endpoints.Endpoint) int {
+	if ep.Host != "" {
+		addr := ep.Host
+		if ep.Kind == "iperf3" {
+			addr = iperf3.HostPort(addr)
+		}
+		if _, p, err := net.SplitHostPort(addr); err == nil {
+			port, _ := strconv.Atoi(p)
+			return port
+		}
+		return 443
+	}
+	u, err := url.Parse(ep.URL)
+	if err != nil {
+		return 443
+	}
+	if p := u.Port(); p != "" {
+		port, _ := strconv.Atoi(p)
+		return port
+	}
+	if u.Scheme == "http" {
+		return 80
 	}
+	return 443
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/engine"
+	"github.com/rotkonetworks/intspeed/pkg/locations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	transitISP  string
+	transitFrom string
+	transitJSON bool
+)
+
+func newTransitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "transit [sweep.json...]",
+		Short: "Group sweep results by the upstream AS after your ISP and compare their performance",
+		Long: `Attributes every location in saved sweep results (default: the latest
+sweep in --output) to its transit: the first AS its AS path enters after
+your ISP's. Per transit it reports the median latency, how far that is
+above what the distance allows, and the median throughput, so a slow
+region can be pinned on the upstream its traffic takes.`,
+		Run: runTransit,
+	}
+	cmd.Flags().StringVar(&transitISP, "isp", "", "Your ISP's ASN (default: the first AS most paths start with)")
+	cmd.Flags().StringVar(&transitFrom, "from", "", "Your city or its airport code, for latency inflation (default: guessed from your ISP's router names)")
+	cmd.Flags().BoolVar(&transitJSON, "json", false, "Print the per-transit summary as JSON")
+	return cmd
+}
+
+// transitGroup is the performance of every location reached through one
+// upstream AS.
+type transitGroup struct {
+	aspath.AS
+	// Direct is set when the upstream is the own network of every
+	// location's endpoint, i.e. the ISP peers with it rather than buying
+	// transit to it.
+	Direct    bool     `json:"direct,omitempty"`
+	Locations []string `json:"locations"`
+	// Medians over the locations. InflationX is latency over the fastest
+	// round trip the distance allows (0 when --from is unknown).
+	LatencyMs    float64 `json:"median_latency_ms"`
+	InflationX   float64 `json:"median_inflation,omitempty"`
+	DownloadMbps float64 `json:"median_download_mbps"`
+	UploadMbps   float64 `json:"median_upload_mbps"`
+
+	latency, inflation, download, upload []float64
+	direct                               int // locations whose endpoint is in the upstream
+}
+
+func runTransit(cmd *cobra.Command, args []string) {
+	reg := loadRegistry(nil)
+	files := args
+	if len(files) == 0 {
+		files = []string{filepath.Join(outputDir, "sweep_latest.json")}
+	}
+	var results []engine.LocationResult
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("read sweep results: %v", err)
+		}
+		var sweep struct {
+			Results []engine.LocationResult `json:"results"`
+		}
+		if err := json.Unmarshal(data, &sweep); err != nil {
+			log.Fatalf("parse %s: %v", file, err)
+		}
+		for _, r := range sweep.Results {
+			results = append(results, r)
+			if r.IPv6 != nil {
+				v6 := *r.IPv6
+				v6.Location = r.Location
+				results = append(results, v6)
+			}
+		}
+	}
+
+	isp := transitISP
+	if isp == "" {
+		isp = commonFirstAS(results)
+	}
+	isp = strings.TrimPrefix(strings.ToUpper(isp), "AS")
+	if isp == "" {
+		log.Fatalf("no AS paths in %s — run `intspeed sweep` with --aspath (the default) first", strings.Join(files, ", "))
+	}
+	var from *aspath.Geo
+	if transitFrom != "" {
+		if from = aspath.Place(transitFrom); from == nil {
+			log.Fatalf("--from: unknown city %q (try a nearby hub's airport code)", transitFrom)
+		}
+	} else {
+		from = ispLocation(results, isp)
+	}
+
+	summary, skipped := groupTransit(reg, results, isp, from)
+
+	if transitJSON {
+		json.NewEncoder(os.Stdout).Encode(summary)
+		return
+	}
+	fmt.Printf("TRANSIT after AS%s", isp)
+	if from != nil {
+		fmt.Printf(" · latency inflation from %s", from.City)
+	}
+	fmt.Println()
+	fmt.Printf("%-24s %4s %9s %8s %10s %10s   %s\n", "UPSTREAM", "LOCS", "LATENCY", "INFL", "DOWN", "UP", "LOCATIONS")
+	fmt.Println(strings.Repeat("─", 78))
+	for _, g := range summary {
+		name := g.Name
+		if name == "" {
+			name = "as" + g.ASN
+		}
+		label := fmt.Sprintf("%s (%s)", name, g.ASN)
+		switch {
+		case g.Direct:
+			label += " direct"
+		case g.IXP != "":
+			label += " peer"
+		}
+		infl := "—"
+		if g.InflationX > 0 {
+			infl = fmt.Sprintf("×%.1f", g.InflationX)
+		}
+		pad := max(24-len([]rune(label)), 0)
+		fmt.Printf("%s%s %4d %7.1fms %8s %5.1f Mbps %5.1f Mbps   %s\n",
+			osc8(aspath.PeeringDBURL(g.ASN), label), strings.Repeat(" ", pad), len(g.Locations),
+			g.LatencyMs, infl, g.DownloadMbps, g.UploadMbps, strings.Join(g.Locations, ", "))
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("\nno AS path past AS%s: %s\n", isp, strings.Join(skipped, ", "))
+	}
+}
+
+// groupTransit attributes each result to the AS its path enters after
+// isp's and summarizes every upstream, slowest first. skipped lists the
+// traced results whose path doesn't get past isp.
+func groupTransit(reg *endpoints.Registry, results []engine.LocationResult, isp string, from *aspath.Geo) (summary []*transitGroup, skipped []string) {
+	// Registry metadata names the endpoints' networks when the traced path
+	// left them unnamed.
+	names := map[string]string{}
+	for _, loc := range reg.Locations {
+		for _, e := range loc.Endpoints {
+			if e.ASN != "" && e.ASName != "" {
+				names[e.ASN] = e.ASName
+			}
+		}
+	}
+
+	groups := map[string]*transitGroup{}
+	var order []string
+	for _, r := range results {
+		label := r.Location
+		if r.Family != "" {
+			label += " " + r.Family
+		}
+		i := slices.IndexFunc(r.ASPath, func(as aspath.AS) bool { return as.ASN == isp })
+		if i < 0 || i+1 >= len(r.ASPath) {
+			if r.DownloadVia != "" {
+				skipped = append(skipped, label)
+			}
+			continue
+		}
+		up := r.ASPath[i+1]
+		if up.Name == "" {
+			up.Name = names[up.ASN]
+		}
+		g := groups[up.ASN]
+		if g == nil {
+			g = &transitGroup{AS: up}
+			groups[up.ASN] = g
+			order = append(order, up.ASN)
+		}
+		if ep := endpointOf(reg, r.Location, r.DownloadVia); ep != nil && ep.ASN == up.ASN {
+			g.direct++
+		}
+		g.Locations = append(g.Locations, label)
+		if lat := viaLatency(r); lat > 0 {
+			g.latency = append(g.latency, lat)
+			if to := locationGeo(r.Location); from != nil && to != nil {
+				// Locations next door would inflate without bound.
+				if floor := aspath.MinRTTMs(from, to); floor >= 1 {
+					g.inflation = append(g.inflation, lat/floor)
+				}
+			}
+		}
+		if r.DownloadMbps > 0 {
+			g.download = append(g.download, r.DownloadMbps)
+		}
+		if r.UploadMbps > 0 {
+			g.upload = append(g.upload, r.UploadMbps)
+		}
+	}
+
+	summary = make([]*transitGroup, 0, len(order))
+	for _, asn := range order {
+		g := groups[asn]
+		g.Direct = g.direct == len(g.Locations)
+		g.LatencyMs, g.InflationX = engine.Median(g.latency), engine.Median(g.inflation)
+		g.DownloadMbps, g.UploadMbps = engine.Median(g.download), engine.Median(g.upload)
+		summary = append(summary, g)
+	}
+	// Slowest first: that's the upstream to take to the ISP. Groups with no
+	// throughput measured go last, the most inflated latency first.
+	slices.SortStableFunc(summary, func(a, b *transitGroup) int {
+		if (a.DownloadMbps == 0) != (b.DownloadMbps == 0) {
+			if a.DownloadMbps == 0 {
+				return 1
+			}
+			return -1
+		}
+		if a.DownloadMbps == 0 {
+			return cmp.Compare(b.InflationX, a.InflationX)
+		}
+		return cmp.Compare(a.DownloadMbps, b.DownloadMbps)
+	})
+	return summary, skipped
+}
+
+// commonFirstAS is the AS most paths start in: the ISP's, as long as the
+// traces ran from its network.
+func commonFirstAS(results []engine.LocationResult) string {
+	count := map[string]int{}
+	best := ""
+	for _, r := range results {
+		if len(r.ASPath) == 0 {
+			continue
+		}
+		asn := r.ASPath[0].ASN
+		count[asn]++
+		if count[asn] > count[best] {
+			best = asn
+		}
+	}
+	return best
+}
+
+// ispLocation guesses where we are from the first of the ISP's routers
+// whose name gives a location away.
+func ispLocation(results []engine.LocationResult, isp string) *aspath.Geo {
+	for _, r := range results {
+		for _, h := range r.Hops {
+			if h.ASN == isp && h.Geo != nil {
+				return h.Geo
+			}
+		}
+	}
+	return nil
+}
+
+func locationGeo(name string) *aspath.Geo {
+	for _, l := range locations.GlobalLocations {
+		if l.Name == name {
+			return &aspath.Geo{City: l.Name, Country: l.CountryCode, Lat: l.Lat, Lon: l.Lon}
+		}
+	}
+	return nil
+}
+
+func endpointOf(reg *endpoints.Registry, location, name string) *endpoints.Endpoint {
+	loc := reg.ForLocation(location)
+	if loc == nil {
+		return nil
+	}
+	for i := range loc.Endpoints {
+		if loc.Endpoints[i].Name == name {
+			return &loc.Endpoints[i]
+		}
+	}
+	return nil
+}
+
+// viaLatency is the latency to the endpoint r downloaded from, which the
+// AS path was traced to; r.LatencyMs may be another endpoint's.
+func viaLatency(r engine.LocationResult) float64 {
+	for _, e := range r.Endpoints {
+		if e.Name == r.DownloadVia && e.Error == "" {
+			return e.LatencyMs
+		}
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/engine"
+)
+
+// transitResult is a location downloaded from endpoint "ep" at down Mbps,
+// latency ms away, over path.
+func transitResult(location, family string, latency, down float64, path ...string) engine.LocationResult {
+	r := engine.LocationResult{
+		Location:     location,
+		Family:       family,
+		DownloadMbps: down,
+		UploadMbps:   down / 2,
+		DownloadVia:  "ep",
+		Endpoints: []engine.EndpointResult{
+			{Name: "other", LatencyMs: 1}, // a faster endpoint the path wasn't traced to
+			{Name: "ep", LatencyMs: latency},
+		},
+	}
+	for _, asn := range path {
+		r.ASPath = append(r.ASPath, aspath.AS{ASN: asn})
+	}
+	return r
+}
+
+func TestCommonFirstAS(t *testing.T) {
+	results := []engine.LocationResult{
+		transitResult("London", "", 20, 100, "64500", "174"),
+		transitResult("Paris", "", 30, 100, "3320", "174"),
+		transitResult("Tokyo", "", 250, 100, "3320", "2914"),
+		transitResult("Chicago", "", 0, 0),
+		transitResult("Toronto", "", 120, 100, "3320", "3356"),
+	}
+	if got := commonFirstAS(results); got != "3320" {
+		t.Errorf("commonFirstAS = %q, want 3320", got)
+	}
+	if got := commonFirstAS(nil); got != "" {
+		t.Errorf("commonFirstAS of no paths = %q", got)
+	}
+}
+
+func TestGroupTransit(t *testing.T) {
+	reg := &endpoints.Registry{Locations: []endpoints.LocationEndpoints{
+		{Name: "Amsterdam", Endpoints: []endpoints.Endpoint{{Name: "ep", ASN: "24940", ASName: "hetzner"}}},
+		{Name: "Frankfurt", Endpoints: []endpoints.Endpoint{{Name: "ep", ASN: "24940", ASName: "hetzner"}}},
+		{Name: "London", Endpoints: []endpoints.Endpoint{{Name: "ep", ASN: "20473"}}},
+	}}
+	fra := &aspath.Geo{City: "Frankfurt", Lat: 50.1109, Lon: 8.6821}
+	results := []engine.LocationResult{
+		transitResult("London", "v4", 20, 100, "3320", "174", "20473"),
+		transitResult("London", "v6", 22, 300, "3320", "174", "20473"),
+		transitResult("Paris", "", 30, 200, "64512", "3320", "174"), // a CPE's AS first
+		transitResult("Tokyo", "", 300, 50, "3320", "2914"),
+		transitResult("Los Angeles", "", 300, 0, "3320", "3356"),
+		// The ISP peers with the endpoint's own network. Frankfurt is
+		// too close to say anything about inflation.
+		transitResult("Amsterdam", "", 8, 0, "3320", "24940"),
+		transitResult("Frankfurt", "", 2, 0, "3320", "24940"),
+		transitResult("Stockholm", "", 40, 80, "3320"), // ends in the ISP
+		transitResult("Chicago", "", 0, 0),             // not traced
+	}
+	results[len(results)-1].DownloadVia = ""
+
+	summary, skipped := groupTransit(reg, results, "3320", fra)
+
+	var order []string
+	for _, g := range summary {
+		order = append(order, g.ASN)
+	}
+	// Slowest throughput first, then the groups without any, most inflated
+	// first.
+	if want := []string{"2914", "174", "3356", "24940"}; !slices.Equal(order, want) {
+		t.Fatalf("upstreams %v, want %v", order, want)
+	}
+	if want := []string{"Stockholm"}; !slices.Equal(skipped, want) {
+		t.Errorf("skipped %v, want %v", skipped, want)
+	}
+
+	cogent := summary[1]
+	if want := []string{"London v4", "London v6", "Paris"}; !slices.Equal(cogent.Locations, want) {
+		t.Errorf("cogent's locations %v, want %v", cogent.Locations, want)
+	}
+	if cogent.Direct {
+		t.Error("cogent marked direct: London's endpoint is in another AS")
+	}
+	if cogent.LatencyMs != 22 || cogent.DownloadMbps != 200 || cogent.UploadMbps != 100 {
+		t.Errorf("cogent medians %.0fms, %.0f/%.0f Mbps; want the endpoint's 22ms, 200/100 Mbps",
+			cogent.LatencyMs, cogent.DownloadMbps, cogent.UploadMbps)
+	}
+
+	direct := summary[3]
+	if !direct.Direct || direct.Name != "hetzner" {
+		t.Errorf("AS24940 group: direct %v, name %q; want direct, named from the registry", direct.Direct, direct.Name)
+	}
+	if direct.LatencyMs != 5 {
+		t.Errorf("AS24940 median latency %.1fms, want 5", direct.LatencyMs)
+	}
+	ams := locationGeo("Amsterdam")
+	if want := 8 / aspath.MinRTTMs(fra, ams); direct.InflationX != want {
+		t.Errorf("AS24940 inflation ×%.2f, want Amsterdam's ×%.2f alone", direct.InflationX, want)
+	}
+
+	// Without knowing where we are there is no inflation, and groups
+	// without throughput keep their order.
+	summary, _ = groupTransit(reg, results, "3320", nil)
+	if summary[2].ASN != "3356" || summary[2].InflationX != 0 || summary[3].InflationX != 0 {
+		t.Errorf("without --from: %+v, %+v", *summary[2], *summary[3])
+	}
+
+	// An upstream is direct only if it is every location's endpoint's
+	// network: Berlin's endpoint isn't known to be hetzner's.
+	summary, _ = groupTransit(reg, []engine.LocationResult{
+		transitResult("Amsterdam", "", 8, 100, "3320", "24940"),
+		transitResult("Berlin", "", 10, 100, "3320", "24940"),
+	}, "3320", nil)
+	if len(summary) != 1 || summary[0].Direct {
+		t.Errorf("AS24940 direct for Amsterdam only: %+v", summary)
+	}
+}
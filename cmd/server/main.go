@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
+	"github.com/rotkonetworks/intspeed/pkg/iperf3"
+	"github.com/rotkonetworks/intspeed/pkg/reflector"
 	"github.com/rotkonetworks/intspeed/pkg/web"
 	"github.com/spf13/cobra"
 )
@@ -16,27 +19,38 @@ import (
 func main() {
 	var port int
 	var dir string
+	var probe bool
+	var iperfPort int
+	var reflectorPort int
 
 	var rootCmd = &cobra.Command{
 		Use:   "intspeed-server",
 		Short: "serves the intspeed browser frontend (tests run in the visitor's browser)",
 		Run: func(cmd *cobra.Command, args []string) {
-			runServer(port, dir)
+			runServer(port, dir, probe, iperfPort, reflectorPort)
 		},
 	}
 
 	rootCmd.Flags().IntVarP(&port, "port", "p", 8080, "Server port")
 	rootCmd.Flags().StringVarP(&dir, "dir", "d", "web", "Static assets directory")
+	rootCmd.Flags().IntVar(&iperfPort, "iperf3", 0, "Also run an iperf3-protocol server on this TCP+UDP port (0 = off)")
+	rootCmd.Flags().IntVar(&reflectorPort, "reflector", 0, "Also run a UDP echo reflector for loss/jitter tests on this port (0 = off)")
+	rootCmd.Flags().BoolVar(&probe, "probe", false, "Also serve the probe endpoint kind under /probe/ (ping, download, upload)")
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func runServer(port int, dir string) {
+func runServer(port int, dir string, probe bool, iperfPort, reflectorPort int) {
+	mux := http.NewServeMux()
+	mux.Handle("/", web.StaticHandler(dir))
+	if probe {
+		mux.Handle("/probe/", http.StripPrefix("/probe", web.ProbeHandler()))
+	}
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      web.StaticHandler(dir),
+		Handler:      mux,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 60 * time.Second,
 	}
@@ -48,6 +62,32 @@ func runServer(port int, dir string) {
 		}
 	}()
 
+	if iperfPort > 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", iperfPort))
+		if err != nil {
+			log.Fatalf("iperf3 listen: %v", err)
+		}
+		go func() {
+			fmt.Printf("📶 iperf3 server on :%d\n", iperfPort)
+			if err := iperf3.Serve(context.Background(), ln); err != nil {
+				log.Printf("iperf3 server: %v", err)
+			}
+		}()
+	}
+
+	if reflectorPort > 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: reflectorPort})
+		if err != nil {
+			log.Fatalf("reflector listen: %v", err)
+		}
+		go func() {
+			fmt.Printf("🪞 UDP reflector on :%d\n", reflectorPort)
+			if err := reflector.Serve(context.Background(), conn); err != nil {
+				log.Printf("reflector: %v", err)
+			}
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
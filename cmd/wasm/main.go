@@ -9,6 +9,7 @@ import (
 	"context"
 	"encoding/json"
 	"syscall/js"
+	"time"
 
 	"github.com/rotkonetworks/intspeed/pkg/endpoints"
 	"github.com/rotkonetworks/intspeed/pkg/engine"
@@ -51,6 +52,9 @@ func start(_ js.Value, args []js.Value) any {
 			UploadMB     int64    `json:"uploadMB"`
 			Pings        int      `json:"pings"`
 			MaxEndpoints int      `json:"maxEndpoints"`
+			Streams      int      `json:"streams"`
+			DurationSec  int      `json:"durationSec"`
+			Loaded       bool     `json:"loadedLatency"`
 			Locations    []string `json:"locations"`
 		}
 		if json.Unmarshal([]byte(args[1].String()), &o) == nil {
@@ -58,6 +62,9 @@ func start(_ js.Value, args []js.Value) any {
 			opts.UploadBytes = o.UploadMB * 1_000_000
 			opts.PingCount = o.Pings
 			opts.MaxEndpoints = o.MaxEndpoints
+			opts.Streams = o.Streams
+			opts.TestDuration = time.Duration(o.DurationSec) * time.Second
+			opts.LoadedLatency = o.Loaded
 			opts.Locations = o.Locations
 		}
 	}
@@ -1,179 +1,229 @@
 //go:build !js
 
-// Package aspath implements a thin mtr: ICMP-echo traceroute with per-hop
-// RTT, reverse DNS, and IP→ASN mapping (Team Cymru DNS), producing an
-// inspectable looking-glass style path. Raw ICMP needs CAP_NET_RAW.
+// Package aspath implements a thin mtr: ICMP-echo traceroute over IPv4 or
+// IPv6 with per-hop RTT, reverse DNS, and IP→ASN mapping (Team Cymru DNS
+// or an offline prefix table), producing an inspectable looking-glass
+// style path. Raw ICMP needs CAP_NET_RAW; without it, ICMP traces on
+// Linux fall back to unprivileged ICMP datagram sockets.
 package aspath
 
 import (
 	"context"
 	"fmt"
 	"net"
-	"os"
 	"strings"
+	"sync"
 	"time"
-
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
-type Hop struct {
-	TTL    int     `json:"ttl"`
-	IP     string  `json:"ip,omitempty"` // empty when the hop didn't answer
-	PTR    string  `json:"ptr,omitempty"`
-	RTTMs  float64 `json:"rtt_ms,omitempty"`
-	ASN    string  `json:"asn,omitempty"`
-	ASName string  `json:"as_name,omitempty"`
+// ErrNoPermission is returned when the raw ICMP socket cannot be opened,
+// nor for ICMP probes an ICMP datagram socket.
+var ErrNoPermission = fmt.Errorf("raw ICMP socket requires root or CAP_NET_RAW")
+
+// Options tunes a Trace; zero values pick the defaults.
+type Options struct {
+	MaxHops    int           // default 30
+	HopTimeout time.Duration // wait per probe, default 800ms
+	// Family is "v4" or "v6" to trace over that family only; empty means
+	// IPv4 when host has an A record, else IPv6.
+	Family string
+	// Proto is the probe protocol: "icmp" echo (default), "udp" to high
+	// ports, or "tcp" SYNs to Port, which follow the same path as the
+	// real traffic through per-flow load balancers and port filters.
+	Proto string
+	// Port is the TCP destination port (default 443), or the first UDP
+	// destination port (default 33434).
+	Port int
+	// Mode picks how probes treat per-flow (ECMP) load balancing:
+	//   - "classic" (default) lets the flow identifier change from probe
+	//     to probe, like traditional traceroute, so consecutive hops may
+	//     come from different load-balanced paths;
+	//   - "paris" holds it constant, so all hops lie on one real path;
+	//   - "mda" varies it on purpose to find every branch at each TTL
+	//     (Multipath Detection Algorithm), reported in Hop.Branches.
+	Mode string
+	// Resolver maps hop addresses to ASNs; nil means Cymru, live DNS
+	// queries that reveal every traced address to Team Cymru.
+	Resolver Resolver
+	// Cache, if set, remembers PTR names and network Resolver answers
+	// across traces and runs; the caller saves it.
+	Cache *Cache
+	// PeeringDB, if set, marks hops on exchange peering LANs (Hop.IXP).
+	PeeringDB *PeeringDB
+	// Rate caps probes sent per second, shared by all traces of one
+	// TraceMany; 0 = unlimited. Every TTL is probed at once, so without a
+	// cap a trace starts with a burst of MaxHops probes.
+	Rate int
+}
+
+func (o *Options) defaults() {
+	if o.MaxHops <= 0 {
+		o.MaxHops = 30
+	}
+	o.MaxHops = min(o.MaxHops, maxTTL)
+	if o.HopTimeout <= 0 {
+		o.HopTimeout = 800 * time.Millisecond
+	}
+	if o.Proto == "" {
+		o.Proto = "icmp"
+	}
+	if o.Port <= 0 {
+		switch o.Proto {
+		case "tcp":
+			o.Port = 443
+		case "udp":
+			o.Port = 33434
+		}
+	}
+	if o.Mode == "" {
+		o.Mode = "classic"
+	}
+	if o.Resolver == nil {
+		o.Resolver = Cymru
+	}
 }
 
-type AS struct {
-	ASN  string
-	Name string // short (<=10 chars), may be empty
+// Trace runs a traceroute toward host, by default with ICMP echo probes
+// (mtr-style: routers answer echo probes far more reliably than UDP). All
+// TTLs are probed at once, then silent ones once more; "mda" mode instead
+// sends as many flows per TTL as its stopping rule asks for. Every
+// protocol reads the routers' ICMP errors from a raw socket; without
+// CAP_NET_RAW, ICMP probes fall back to an ICMP datagram socket (Linux,
+// for groups in net.ipv4.ping_group_range). Hops are enriched with PTR +
+// ASN before returning.
+func Trace(ctx context.Context, host string, opts Options) ([]Hop, error) {
+	return trace(ctx, host, opts, newLimiter(opts.Rate))
 }
 
-// ErrNoPermission is returned when the raw ICMP socket cannot be opened.
-var ErrNoPermission = fmt.Errorf("raw ICMP socket requires root or CAP_NET_RAW")
+// TraceMany traces every host concurrently, sharing one Options.Rate
+// budget. hops[i] and errs[i] are Trace's results for hosts[i].
+func TraceMany(ctx context.Context, hosts []string, opts Options) (hops [][]Hop, errs []error) {
+	lim := newLimiter(opts.Rate)
+	hops, errs = make([][]Hop, len(hosts)), make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hops[i], errs[i] = trace(ctx, host, opts, lim)
+		}()
+	}
+	wg.Wait()
+	return hops, errs
+}
 
-// Trace runs an ICMP-echo traceroute toward host (mtr-style: routers answer
-// echo probes far more reliably than UDP). Two probes per TTL, then the hop
-// is marked unanswered. Hops are enriched with PTR + ASN before returning.
-func Trace(ctx context.Context, host string, maxHops int, hopTimeout time.Duration) ([]Hop, error) {
-	dst, err := resolve4(host)
+func trace(ctx context.Context, host string, opts Options, lim *limiter) ([]Hop, error) {
+	opts.defaults()
+	switch opts.Mode {
+	case "classic", "paris", "mda":
+	default:
+		return nil, fmt.Errorf("unknown trace mode %q (want classic, paris or mda)", opts.Mode)
+	}
+	dst, err := resolve(host, opts.Family)
 	if err != nil {
 		return nil, err
 	}
-
-	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	t, err := newTracer(opts, dst, lim)
 	if err != nil {
-		return nil, ErrNoPermission
+		return nil, err
 	}
-	defer conn.Close()
-	pc := conn.IPv4PacketConn()
+	defer t.close()
 
-	id := os.Getpid() & 0xffff
-	buf := make([]byte, 1500)
 	var hops []Hop
-
-	for ttl := 1; ttl <= maxHops; ttl++ {
-		if ctx.Err() != nil {
-			break
-		}
-		if err := pc.SetTTL(ttl); err != nil {
-			return nil, err
-		}
-
-		hop := Hop{TTL: ttl}
-		reached := false
-		for attempt := 0; attempt < 2 && hop.IP == ""; attempt++ {
-			seq := ttl<<2 | attempt
-			wm := icmp.Message{
-				Type: ipv4.ICMPTypeEcho,
-				Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("intspeed-aspath")},
-			}
-			wb, _ := wm.Marshal(nil)
-			start := time.Now()
-			if _, err := conn.WriteTo(wb, &net.IPAddr{IP: dst}); err != nil {
-				return nil, err
-			}
-
-			deadline := start.Add(hopTimeout)
-			for time.Now().Before(deadline) {
-				conn.SetReadDeadline(deadline)
-				n, peer, err := conn.ReadFrom(buf)
-				if err != nil {
-					break // timeout → next attempt
-				}
-				match, isReply := matchProbe(buf[:n], id, seq)
-				if !match {
-					continue
-				}
-				hop.IP = peer.String()
-				hop.RTTMs = float64(time.Since(start).Microseconds()) / 1000
-				reached = isReply && peer.String() == dst.String()
-				break
-			}
-		}
-		hops = append(hops, hop)
-		if reached {
-			break
-		}
+	if opts.Mode == "mda" {
+		hops, err = t.traceMDA(ctx)
+	} else {
+		hops, err = t.trace(ctx)
 	}
-
-	enrich(ctx, hops)
+	if err != nil {
+		return nil, err
+	}
+	enrich(ctx, hops, opts)
 	return hops, nil
 }
 
-// matchProbe reports whether an incoming ICMP packet answers our echo probe
-// (either an echo reply from the destination, or a time-exceeded /
-// dest-unreachable quoting our probe), and whether it was the final reply.
-func matchProbe(b []byte, id, seq int) (match, isReply bool) {
-	msg, err := icmp.ParseMessage(1, b)
-	if err != nil {
-		return false, false
-	}
-	switch body := msg.Body.(type) {
-	case *icmp.Echo:
-		return msg.Type == ipv4.ICMPTypeEchoReply && body.ID == id && body.Seq == seq, true
-	case *icmp.TimeExceeded:
-		return quotedEchoMatches(body.Data, id, seq), false
-	case *icmp.DstUnreach:
-		return quotedEchoMatches(body.Data, id, seq), false
-	}
-	return false, false
+// Enrich adds PTR names, ASNs and, with opts.PeeringDB, exchanges to hops
+// traced elsewhere, e.g. read by ReadAtlas, as Trace does to its own.
+func Enrich(ctx context.Context, hops []Hop, opts Options) {
+	opts.defaults()
+	enrich(ctx, hops, opts)
 }
 
-// quotedEchoMatches digs the original echo header out of an ICMP error
-// payload (IP header + first 8 bytes of the offending packet).
-func quotedEchoMatches(data []byte, id, seq int) bool {
-	if len(data) < 20 {
-		return false
+// enrich adds PTR names and ASN info from opts.Resolver to answered hops
+// and their branches, looking up several addresses at once.
+func enrich(ctx context.Context, hops []Hop, opts Options) {
+	res := opts.Resolver
+	if _, offline := res.(*Table); !offline && opts.Cache != nil {
+		res = cachedResolver{res, opts.Cache}
 	}
-	ihl := int(data[0]&0x0f) * 4
-	if len(data) < ihl+8 || data[ihl] != 8 { // type 8 = echo request
-		return false
+	type info struct {
+		ptr, asn, name string
+		ixp            *IXP
+		geo            *Geo
 	}
-	qid := int(data[ihl+4])<<8 | int(data[ihl+5])
-	qseq := int(data[ihl+6])<<8 | int(data[ihl+7])
-	return qid == id && qseq == seq
-}
-
-// enrich adds PTR names and ASN info to answered hops.
-func enrich(ctx context.Context, hops []Hop) {
-	nameCache := map[string]string{}
-	for i := range hops {
-		if hops[i].IP == "" {
-			continue
+	var (
+		mu    sync.Mutex
+		infos = map[string]*info{}
+		names = map[string]string{}
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, 16)
+	)
+	var collect func(h *Hop)
+	collect = func(h *Hop) {
+		for i := range h.Branches {
+			collect(&h.Branches[i])
 		}
-		hops[i].PTR = lookupPTR(ctx, hops[i].IP)
-		asn := lookupASN(hops[i].IP)
-		if asn == "" {
-			continue
+		if h.IP == "" || infos[h.IP] != nil {
+			return
 		}
-		hops[i].ASN = asn
-		name, ok := nameCache[asn]
-		if !ok {
-			name = lookupASName(asn)
-			nameCache[asn] = name
-		}
-		hops[i].ASName = name
+		in := &info{}
+		infos[h.IP] = in
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			in.ptr = opts.Cache.lookup(ctx, "ptr:"+ip, func() (string, error) { return lookupPTR(ctx, ip) })
+			in.geo = Locate(in.ptr)
+			p := net.ParseIP(ip)
+			if p == nil || isPrivate(p) {
+				return
+			}
+			if opts.PeeringDB != nil {
+				in.ixp = opts.PeeringDB.Lookup(p)
+			}
+			if in.asn = res.ASN(ctx, p); in.asn == "" {
+				return
+			}
+			mu.Lock()
+			name, ok := names[in.asn]
+			mu.Unlock()
+			if !ok {
+				name = res.ASName(ctx, in.asn)
+				mu.Lock()
+				names[in.asn] = name
+				mu.Unlock()
+			}
+			in.name = name
+		}(h.IP)
 	}
-}
+	for i := range hops {
+		collect(&hops[i])
+	}
+	wg.Wait()
 
-// ASPath collapses hops into the AS-level path: every AS traversed, in
-// order. Unanswered and unmapped hops (private ranges, IXP fabrics) are
-// skipped; consecutive same-AS hops merge.
-func ASPath(hops []Hop) []AS {
-	var path []AS
-	for _, h := range hops {
-		if h.ASN == "" {
-			continue
+	var apply func(h *Hop)
+	apply = func(h *Hop) {
+		for i := range h.Branches {
+			apply(&h.Branches[i])
 		}
-		if len(path) > 0 && path[len(path)-1].ASN == h.ASN {
-			continue
+		if in := infos[h.IP]; in != nil {
+			h.PTR, h.ASN, h.ASName, h.IXP, h.Geo = in.ptr, in.asn, in.name, in.ixp, in.geo
 		}
-		path = append(path, AS{ASN: h.ASN, Name: h.ASName})
 	}
-	return path
+	for i := range hops {
+		apply(&hops[i])
+	}
 }
 
 // PeeringDBURL returns the search URL for an ASN, e.g.
@@ -182,69 +232,42 @@ func PeeringDBURL(asn string) string {
 	return "https://www.peeringdb.com/search?q=as" + asn
 }
 
-func resolve4(host string) (net.IP, error) {
+// resolve picks host's address of the given family ("v4", "v6"), or with
+// no family IPv4 if it has one and IPv6 otherwise.
+func resolve(host, family string) (net.IP, error) {
 	ips, err := net.LookupIP(host)
 	if err != nil {
 		return nil, err
 	}
+	var v6 net.IP
 	for _, ip := range ips {
 		if v4 := ip.To4(); v4 != nil {
-			return v4, nil
+			if family != "v6" {
+				return v4, nil
+			}
+		} else if v6 == nil {
+			v6 = ip
 		}
 	}
+	if v6 != nil && family != "v4" {
+		return v6, nil
+	}
+	if family == "v6" {
+		return nil, fmt.Errorf("no IPv6 address for %s", host)
+	}
 	return nil, fmt.Errorf("no IPv4 address for %s", host)
 }
 
-func lookupPTR(ctx context.Context, ip string) string {
+// lookupPTR returns ip's first PTR name, "" if it has none, and an error
+// if the lookup failed (e.g. timed out) rather than found nothing.
+func lookupPTR(ctx context.Context, ip string) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
 	defer cancel()
 	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
 	if err != nil || len(names) == 0 {
-		return ""
-	}
-	return strings.TrimSuffix(names[0], ".")
-}
-
-func lookupASN(ip string) string {
-	p := net.ParseIP(ip).To4()
-	if p == nil || isPrivate(p) {
-		return ""
-	}
-	q := fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", p[3], p[2], p[1], p[0])
-	txts, err := net.LookupTXT(q)
-	if err != nil || len(txts) == 0 {
-		return ""
-	}
-	fields := strings.Fields(strings.Split(txts[0], "|")[0])
-	if len(fields) == 0 {
-		return ""
-	}
-	return fields[0]
-}
-
-// lookupASName returns a short lowercase name (<=10 chars) for an ASN.
-func lookupASName(asn string) string {
-	txts, err := net.LookupTXT("AS" + asn + ".asn.cymru.com")
-	if err != nil || len(txts) == 0 {
-		return ""
-	}
-	parts := strings.Split(txts[0], "|")
-	if len(parts) < 5 {
-		return ""
-	}
-	name := strings.TrimSpace(parts[4])
-	if i := strings.LastIndex(name, ","); i > 0 {
-		name = name[:i]
-	}
-	name = strings.ToLower(name)
-	if i := strings.Index(name, " "); i >= 3 {
-		name = name[:i]
-	}
-	name = strings.Trim(name, "-_.")
-	if len(name) > 10 {
-		name = name[:10]
+		return "", dnsAnswer(err)
 	}
-	return name
+	return strings.TrimSuffix(names[0], "."), nil
 }
 
 func isPrivate(ip net.IP) bool {
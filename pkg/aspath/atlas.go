@@ -0,0 +1,142 @@
+package aspath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// AtlasTrace is a RIPE Atlas traceroute result as hops, so it renders and
+// enriches like a local trace. A probe near the far end tracing back
+// toward us shows the return path, which Trace can't see.
+type AtlasTrace struct {
+	Measurement int       `json:"msm_id,omitempty"`
+	Probe       int       `json:"prb_id,omitempty"`
+	From        string    `json:"from,omitempty"` // the probe's address
+	Dst         string    `json:"dst,omitempty"`
+	AF          int       `json:"af,omitempty"` // 4 or 6; 0 if the result didn't say
+	Timestamp   time.Time `json:"timestamp"`
+	Hops        []Hop     `json:"hops"`
+}
+
+// atlasResult is the part of the Atlas traceroute result format we read
+// (https://atlas.ripe.net/docs/apis/result-format/).
+type atlasResult struct {
+	Type      string `json:"type"`
+	MsmID     int    `json:"msm_id"`
+	PrbID     int    `json:"prb_id"`
+	AF        int    `json:"af"`
+	From      string `json:"from"`
+	SrcAddr   string `json:"src_addr"`
+	DstAddr   string `json:"dst_addr"`
+	Timestamp int64  `json:"timestamp"`
+	Result    []struct {
+		Hop    int    `json:"hop"`
+		Error  string `json:"error"`
+		Result []struct {
+			From    string  `json:"from"` // empty for a timeout ("x": "*")
+			RTT     float64 `json:"rtt"`
+			ICMPExt *struct {
+				Obj []struct {
+					MPLS []struct {
+						Exp   int `json:"exp"`
+						Label int `json:"label"`
+						TTL   int `json:"ttl"`
+					} `json:"mpls"`
+				} `json:"obj"`
+			} `json:"icmpext"`
+		} `json:"result"`
+	} `json:"result"`
+}
+
+// ReadAtlas reads RIPE Atlas traceroute results the way the API and the
+// web UI export them: a JSON array, a single result, or one result per
+// line. Results of other measurement types are skipped. Each TTL's
+// replies collapse like an "mda" trace's: the first address to answer is
+// the hop, with its fastest RTT, and every address that answered goes in
+// Branches when there was more than one.
+func ReadAtlas(r io.Reader) ([]AtlasTrace, error) {
+	var raw []atlasResult
+	dec := json.NewDecoder(r)
+	for {
+		var res json.RawMessage
+		if err := dec.Decode(&res); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("atlas results: %w", err)
+		}
+		if res = bytes.TrimSpace(res); len(res) > 0 && res[0] == '[' {
+			var batch []atlasResult
+			if err := json.Unmarshal(res, &batch); err != nil {
+				return nil, fmt.Errorf("atlas results: %w", err)
+			}
+			raw = append(raw, batch...)
+			continue
+		}
+		var one atlasResult
+		if err := json.Unmarshal(res, &one); err != nil {
+			return nil, fmt.Errorf("atlas results: %w", err)
+		}
+		raw = append(raw, one)
+	}
+	var traces []AtlasTrace
+	for _, res := range raw {
+		if res.Type != "traceroute" && (res.Type != "" || len(res.Result) == 0) {
+			continue
+		}
+		traces = append(traces, res.trace())
+	}
+	return traces, nil
+}
+
+func (res atlasResult) trace() AtlasTrace {
+	t := AtlasTrace{
+		Measurement: res.MsmID, Probe: res.PrbID, From: res.From, Dst: res.DstAddr, AF: res.AF,
+		Timestamp: time.Unix(res.Timestamp, 0).UTC(),
+	}
+	if t.From == "" {
+		t.From = res.SrcAddr
+	}
+	for _, hr := range res.Result {
+		if hr.Error != "" {
+			// The probe failed to send at this TTL: the hop is unknown,
+			// not missing, or the TTLs around it would read as adjacent.
+			t.Hops = append(t.Hops, Hop{TTL: hr.Hop})
+			continue
+		}
+		var branches []Hop
+		for _, reply := range hr.Result {
+			if reply.From == "" {
+				continue
+			}
+			i := slices.IndexFunc(branches, func(b Hop) bool { return b.IP == reply.From })
+			if i < 0 {
+				b := Hop{TTL: hr.Hop, IP: reply.From}
+				if reply.ICMPExt != nil {
+					for _, obj := range reply.ICMPExt.Obj {
+						for _, l := range obj.MPLS {
+							b.MPLS = append(b.MPLS, Label{Label: l.Label, TC: l.Exp, TTL: l.TTL})
+						}
+					}
+				}
+				branches = append(branches, b)
+				i = len(branches) - 1
+			}
+			if reply.RTT > 0 && (branches[i].RTTMs == 0 || reply.RTT < branches[i].RTTMs) {
+				branches[i].RTTMs = reply.RTT
+			}
+		}
+		hop := Hop{TTL: hr.Hop}
+		if len(branches) > 0 {
+			hop = branches[0]
+		}
+		if len(branches) > 1 {
+			hop.Branches = branches
+		}
+		t.Hops = append(t.Hops, hop)
+	}
+	return t
+}
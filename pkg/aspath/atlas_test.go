@@ -0,0 +1,76 @@
+package aspath
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// atlasResultJSON is one traceroute in the Atlas result format: a timeout, a
+// TTL the probe failed to send, and load-balanced replies from inside an
+// MPLS tunnel.
+const atlasResultJSON = `{"type": "traceroute", "af": 4, "msm_id": 5001, "prb_id": 6001, "from": "198.51.100.7",
+ "src_addr": "10.0.0.2", "dst_addr": "192.0.2.1", "timestamp": 1760000000, "result": [
+  {"hop": 1, "result": [{"from": "10.0.0.1", "rtt": 1.2}, {"from": "10.0.0.1", "rtt": 0.9}, {"from": "10.0.0.1", "rtt": 1.0}]},
+  {"hop": 2, "result": [{"x": "*"}, {"x": "*"}, {"x": "*"}]},
+  {"hop": 3, "error": "sendto failed: Network is unreachable"},
+  {"hop": 4, "result": [
+    {"from": "203.0.113.1", "rtt": 12.5, "icmpext": {"version": 2, "rfc4884": 1, "obj": [{"class": 1, "type": 1,
+      "mpls": [{"exp": 0, "label": 24001, "s": 0, "ttl": 1}, {"exp": 2, "label": 16, "s": 1, "ttl": 1}]}]}},
+    {"x": "*"},
+    {"from": "203.0.113.9", "rtt": 11.0}]},
+  {"hop": 5, "result": [{"from": "192.0.2.1", "rtt": 20.3}, {"from": "192.0.2.1", "rtt": 20.1}]}
+ ]}`
+
+func TestReadAtlas(t *testing.T) {
+	tunnel := Hop{TTL: 4, IP: "203.0.113.1", RTTMs: 12.5, MPLS: []Label{{Label: 24001, TTL: 1}, {Label: 16, TC: 2, TTL: 1}}}
+	lb := tunnel
+	lb.Branches = []Hop{tunnel, {TTL: 4, IP: "203.0.113.9", RTTMs: 11.0}}
+	want := AtlasTrace{
+		Measurement: 5001, Probe: 6001, From: "198.51.100.7", Dst: "192.0.2.1", AF: 4,
+		Timestamp: time.Unix(1760000000, 0).UTC(),
+		Hops: []Hop{
+			{TTL: 1, IP: "10.0.0.1", RTTMs: 0.9},
+			{TTL: 2},
+			{TTL: 3},
+			lb,
+			{TTL: 5, IP: "192.0.2.1", RTTMs: 20.1},
+		},
+	}
+	// The same probe without "from" is known by its source address.
+	srcOnly := strings.Replace(atlasResultJSON, `"from": "198.51.100.7",`, "", 1)
+	wantSrc := want
+	wantSrc.From = "10.0.0.2"
+	ping := `{"type": "ping", "msm_id": 5002, "prb_id": 6001, "dst_addr": "192.0.2.1", "result": [{"rtt": 20.0}]}`
+
+	tests := []struct {
+		name, input string
+		want        []AtlasTrace
+	}{
+		{"array", "[" + atlasResultJSON + "," + ping + "]", []AtlasTrace{want}},
+		{"single", atlasResultJSON, []AtlasTrace{want}},
+		{"lines", strings.Join([]string{
+			strings.ReplaceAll(atlasResultJSON, "\n", ""), ping, strings.ReplaceAll(srcOnly, "\n", ""),
+		}, "\n") + "\n", []AtlasTrace{want, wantSrc}},
+		{"only other types", ping, nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadAtlas(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadAtlas =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, input := range []string{`{"type": "traceroute", "result": [`, `[{"result": "x"}]`, "not json"} {
+		if _, err := ReadAtlas(strings.NewReader(input)); err == nil {
+			t.Errorf("ReadAtlas(%q): want an error", input)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache remembers PTR names and ASN lookups across runs in a JSON file,
+// so repeated traces to the same destinations skip most DNS queries.
+// Entries expire after TTL (empty answers after a quarter of it) and the
+// oldest are dropped beyond MaxEntries when saving. Failed lookups are not
+// remembered, so one slow resolver doesn't blank later runs.
+type Cache struct {
+	path       string
+	ttl        time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	file  cacheFile
+	dirty bool
+}
+
+type cacheFile struct {
+	Hits    int                   `json:"hits"`
+	Misses  int                   `json:"misses"`
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+// Keys are "ptr:<ip>", "asn:<ip>" or "name:<asn>".
+type cacheEntry struct {
+	Value string    `json:"v,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// CacheStats summarizes a cache's contents and its lifetime hit rate.
+type CacheStats struct {
+	Path       string
+	Bytes      int64
+	Entries    map[string]int // by kind: ptr, asn, name
+	Expired    int
+	Hits       int
+	Misses     int
+	Oldest     time.Time
+	Newest     time.Time
+	TTL        time.Duration
+	MaxEntries int
+}
+
+// OpenCache loads the cache at path; a missing file is an empty cache.
+func OpenCache(path string, ttl time.Duration, maxEntries int) (*Cache, error) {
+	c := &Cache{path: path, ttl: ttl, maxEntries: maxEntries}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &c.file); err != nil {
+			return nil, err
+		}
+	}
+	if c.file.Entries == nil {
+		c.file.Entries = map[string]cacheEntry{}
+	}
+	return c, nil
+}
+
+func (c *Cache) expired(e cacheEntry, now time.Time) bool {
+	ttl := c.ttl
+	if e.Value == "" {
+		ttl /= 4
+	}
+	return now.Sub(e.At) > ttl
+}
+
+// lookup returns key's cached value, or calls fetch and remembers its
+// answer unless fetch failed or ctx ended meanwhile. A nil Cache just
+// calls fetch.
+func (c *Cache) lookup(ctx context.Context, key string, fetch func() (string, error)) string {
+	if c == nil {
+		v, _ := fetch()
+		return v
+	}
+	c.mu.Lock()
+	e, ok := c.file.Entries[key]
+	if ok && !c.expired(e, time.Now()) {
+		c.file.Hits++
+		c.dirty = true
+		c.mu.Unlock()
+		return e.Value
+	}
+	c.file.Misses++
+	c.dirty = true
+	c.mu.Unlock()
+
+	v, err := fetch()
+	if err != nil || ctx.Err() != nil {
+		return v
+	}
+	c.mu.Lock()
+	c.file.Entries[key] = cacheEntry{v, time.Now()}
+	c.dirty = true
+	c.mu.Unlock()
+	return v
+}
+
+// Save drops expired entries, then the oldest beyond MaxEntries, and
+// writes the cache back if anything changed, the hit and miss counts
+// included.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	now := time.Now()
+	for k, e := range c.file.Entries {
+		if c.expired(e, now) {
+			delete(c.file.Entries, k)
+		}
+	}
+	if over := len(c.file.Entries) - c.maxEntries; c.maxEntries > 0 && over > 0 {
+		keys := make([]string, 0, len(c.file.Entries))
+		for k := range c.file.Entries {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			return c.file.Entries[a].At.Compare(c.file.Entries[b].At)
+		})
+		for _, k := range keys[:over] {
+			delete(c.file.Entries, k)
+		}
+	}
+	data, err := json.Marshal(c.file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	// Write a file of our own then rename it, so a concurrent run never
+	// reads half a file nor writes into ours.
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Clear empties the cache and removes its file.
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file = cacheFile{Entries: map[string]cacheEntry{}}
+	c.dirty = false
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Path:       c.path,
+		Entries:    map[string]int{},
+		Hits:       c.file.Hits,
+		Misses:     c.file.Misses,
+		TTL:        c.ttl,
+		MaxEntries: c.maxEntries,
+	}
+	if fi, err := os.Stat(c.path); err == nil {
+		s.Bytes = fi.Size()
+	}
+	now := time.Now()
+	for k, e := range c.file.Entries {
+		kind, _, _ := strings.Cut(k, ":")
+		s.Entries[kind]++
+		if c.expired(e, now) {
+			s.Expired++
+		}
+		if s.Oldest.IsZero() || e.At.Before(s.Oldest) {
+			s.Oldest = e.At
+		}
+		if e.At.After(s.Newest) {
+			s.Newest = e.At
+		}
+	}
+	return s
+}
+
+// cachedResolver puts a network Resolver's answers in a Cache.
+type cachedResolver struct {
+	Resolver
+	c *Cache
+}
+
+func (r cachedResolver) ASN(ctx context.Context, ip net.IP) string {
+	return r.c.lookup(ctx, "asn:"+ip.String(), func() (string, error) {
+		if f, ok := r.Resolver.(failingResolver); ok {
+			return f.asn(ctx, ip)
+		}
+		return r.Resolver.ASN(ctx, ip), nil
+	})
+}
+
+func (r cachedResolver) ASName(ctx context.Context, asn string) string {
+	return r.c.lookup(ctx, "name:"+asn, func() (string, error) {
+		if f, ok := r.Resolver.(failingResolver); ok {
+			return f.asName(ctx, asn)
+		}
+		return r.Resolver.ASName(ctx, asn), nil
+	})
+}
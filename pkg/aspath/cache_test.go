@@ -0,0 +1,151 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCacheSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "intspeed", "lookups.json")
+	c, err := OpenCache(path, time.Hour, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for key, e := range map[string]cacheEntry{
+		"ptr:192.0.2.1":   {"a.example", now.Add(-time.Minute)},
+		"ptr:192.0.2.2":   {"b.example", now.Add(-2 * time.Minute)},
+		"asn:192.0.2.3":   {"64500", now.Add(-3 * time.Minute)},
+		"name:64500":      {"example", now.Add(-4 * time.Minute)}, // oldest survivor: trimmed
+		"ptr:192.0.2.5":   {"", now.Add(-30 * time.Second)},       // empty, within TTL/4
+		"ptr:192.0.2.6":   {"", now.Add(-20 * time.Minute)},       // empty, past TTL/4
+		"asn:192.0.2.7":   {"64501", now.Add(-2 * time.Hour)},     // past TTL
+		"name:4294967295": {"", now.Add(-2 * time.Hour)},
+	} {
+		c.file.Entries[key] = e
+	}
+	c.dirty = true
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = OpenCache(path, time.Hour, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Sorted(maps.Keys(c.file.Entries))
+	want := []string{"asn:192.0.2.3", "ptr:192.0.2.1", "ptr:192.0.2.2", "ptr:192.0.2.5"}
+	if !slices.Equal(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+	if e := c.file.Entries["ptr:192.0.2.1"]; e.Value != "a.example" {
+		t.Errorf("ptr:192.0.2.1 = %q after reload", e.Value)
+	}
+	if st := c.Stats(); st.Expired != 0 || st.Entries["ptr"] != 3 || st.Entries["asn"] != 1 {
+		t.Errorf("stats %+v: want 3 ptr and 1 asn entries, none expired", st)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(tmp) > 0 {
+		t.Errorf("left %v behind", tmp)
+	}
+}
+
+func TestCacheCountsHits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lookups.json")
+	c, err := OpenCache(path, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fetch := func() (string, error) { return "a.example", nil }
+	c.lookup(ctx, "ptr:192.0.2.1", fetch)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run answered entirely from the cache still counts its hits.
+	for range 2 {
+		c, err = OpenCache(path, time.Hour, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.lookup(ctx, "ptr:192.0.2.1", fetch)
+		c.lookup(ctx, "ptr:192.0.2.1", fetch)
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err = OpenCache(path, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Hits != 4 || st.Misses != 1 {
+		t.Errorf("%d hits, %d misses saved; want 4 and 1", st.Hits, st.Misses)
+	}
+}
+
+func TestCacheLookup(t *testing.T) {
+	c, err := OpenCache(filepath.Join(t.TempDir(), "lookups.json"), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	calls := 0
+	answer := func(v string, err error) func() (string, error) {
+		return func() (string, error) {
+			calls++
+			return v, err
+		}
+	}
+	timeout := &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+
+	// A failure is passed through but not remembered.
+	if v := c.lookup(ctx, "ptr:192.0.2.1", answer("", timeout)); v != "" {
+		t.Errorf("failed lookup = %q", v)
+	}
+	if v := c.lookup(ctx, "ptr:192.0.2.1", answer("a.example", nil)); v != "a.example" || calls != 2 {
+		t.Errorf("lookup after a failure = %q with %d fetches, want a.example fetched again", v, calls)
+	}
+	if v := c.lookup(ctx, "ptr:192.0.2.1", answer("", timeout)); v != "a.example" || calls != 2 {
+		t.Errorf("cached lookup = %q with %d fetches, want a.example from the cache", v, calls)
+	}
+
+	// No record is an answer, and is remembered.
+	c.lookup(ctx, "ptr:192.0.2.2", answer("", nil))
+	if _, ok := c.file.Entries["ptr:192.0.2.2"]; !ok {
+		t.Error("empty answer not cached")
+	}
+
+	// Nor is anything fetched after ctx ended.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	c.lookup(cancelled, "ptr:192.0.2.3", answer("c.example", nil))
+	if _, ok := c.file.Entries["ptr:192.0.2.3"]; ok {
+		t.Error("lookup cached after its context ended")
+	}
+}
+
+func TestDNSAnswer(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		answer bool
+	}{
+		{nil, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, true},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, false},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("other"), false},
+	} {
+		if got := dnsAnswer(tt.err) == nil; got != tt.answer {
+			t.Errorf("dnsAnswer(%v) is an answer: %v, want %v", tt.err, got, tt.answer)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package aspath
+
+import "slices"
+
+// PathChange is how an AS path differs from an earlier one to the same
+// destination.
+type PathChange struct {
+	Added   []AS `json:"added,omitempty"`   // on the new path only, e.g. a new transit
+	Removed []AS `json:"removed,omitempty"` // on the old path only
+	// OldLen and NewLen are the paths' lengths in ASes.
+	OldLen int `json:"old_len"`
+	NewLen int `json:"new_len"`
+}
+
+// ComparePaths reports how path differs from old, or nil if both cross
+// the same ASes in the same order. Exchanges are ignored: whether one is
+// spotted depends on the PeeringDB data at hand, not on the route.
+func ComparePaths(old, path []AS) *PathChange {
+	if slices.EqualFunc(old, path, func(a, b AS) bool { return a.ASN == b.ASN }) {
+		return nil
+	}
+	c := &PathChange{OldLen: len(old), NewLen: len(path)}
+	for _, as := range path {
+		if !slices.ContainsFunc(old, func(o AS) bool { return o.ASN == as.ASN }) {
+			c.Added = append(c.Added, as)
+		}
+	}
+	for _, as := range old {
+		if !slices.ContainsFunc(path, func(n AS) bool { return n.ASN == as.ASN }) {
+			c.Removed = append(c.Removed, as)
+		}
+	}
+	return c
+}
@@ -0,0 +1,41 @@
+package aspath
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComparePaths(t *testing.T) {
+	cogent, ntt, lumen, telia := AS{ASN: "174", Name: "cogent"}, AS{ASN: "2914", Name: "ntt"}, AS{ASN: "3356", Name: "lumen"}, AS{ASN: "1299", Name: "telia"}
+	tests := []struct {
+		name      string
+		old, path []AS
+		want      *PathChange
+	}{
+		{"same", []AS{cogent, ntt}, []AS{cogent, ntt}, nil},
+		{
+			// Names and exchanges don't make a different route.
+			name: "same, IXP spotted",
+			old:  []AS{cogent, ntt},
+			path: []AS{{ASN: "174"}, {ASN: "2914", Name: "ntt", IXP: "DE-CIX Frankfurt"}},
+		},
+		{"both empty", nil, []AS{}, nil},
+		{"reordered", []AS{cogent, ntt, lumen}, []AS{cogent, lumen, ntt}, &PathChange{OldLen: 3, NewLen: 3}},
+		{"added", []AS{cogent, ntt}, []AS{cogent, telia, ntt}, &PathChange{Added: []AS{telia}, OldLen: 2, NewLen: 3}},
+		{"removed", []AS{cogent, telia, ntt}, []AS{cogent, ntt}, &PathChange{Removed: []AS{telia}, OldLen: 3, NewLen: 2}},
+		{
+			name: "transit replaced",
+			old:  []AS{cogent, telia, ntt},
+			path: []AS{cogent, lumen, ntt},
+			want: &PathChange{Added: []AS{lumen}, Removed: []AS{telia}, OldLen: 3, NewLen: 3},
+		},
+		{"first seen", nil, []AS{cogent}, &PathChange{Added: []AS{cogent}, NewLen: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComparePaths(tt.old, tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ComparePaths = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+//go:build linux
+
+package aspath
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+)
+
+// Origins of a queued socket error (linux/errqueue.h).
+const (
+	eeOriginICMP  = 2
+	eeOriginICMP6 = 3
+)
+
+// listenDgram opens an ICMP datagram ("ping") socket, which Linux lets
+// unprivileged users in net.ipv4.ping_group_range open for ICMP and
+// ICMPv6 alike. The kernel only delivers echo replies on it; IP_RECVERR
+// puts the ICMP errors our probes provoke on the socket's error queue.
+func listenDgram(fam icmpFamily) (*icmp.PacketConn, syscall.RawConn, error) {
+	network, listen := "udp4", "0.0.0.0"
+	if fam.v6() {
+		network, listen = "udp6", "::"
+	}
+	conn, err := icmp.ListenPacket(network, listen)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sc syscall.Conn
+	var ok bool
+	if fam.v6() {
+		sc, ok = conn.IPv6PacketConn().PacketConn.(syscall.Conn)
+	} else {
+		sc, ok = conn.IPv4PacketConn().PacketConn.(syscall.Conn)
+	}
+	if !ok {
+		conn.Close()
+		return nil, nil, errors.New("ICMP datagram socket has no file descriptor")
+	}
+	rc, err := sc.SyscallConn()
+	if err == nil {
+		var serr error
+		err = rc.Control(func(fd uintptr) {
+			if fam.v6() {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+			} else {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+			}
+		})
+		if err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rc, nil
+}
+
+// readDgram reads the next message on a datagram socket into buf: an
+// echo reply as is, or a queued ICMP error rebuilt as a raw socket would
+// have read it (type, code, the quoted IP header, the quoted probe), so
+// probers match both kinds alike. It blocks until the read deadline.
+func readDgram(c syscall.RawConn, buf []byte, v6 bool) (n int, from net.IP, err error) {
+	oob := make([]byte, 512)
+	var rerr error
+	err = c.Read(func(fd uintptr) bool {
+		for {
+			for {
+				var queued bool
+				n, from, queued, rerr = readErrQueue(int(fd), buf, oob, v6)
+				if rerr != nil || n > 0 {
+					return true
+				}
+				if !queued {
+					break
+				}
+				// Not a router's error (a local one, say): skip it.
+			}
+			var sa syscall.Sockaddr
+			n, sa, rerr = syscall.Recvfrom(int(fd), buf, syscall.MSG_DONTWAIT)
+			if rerr == syscall.EAGAIN {
+				return false
+			}
+			// The pending socket error IP_RECVERR raises along with a queued
+			// one is cleared by reading it; then read on.
+			if !dgramPending(rerr) {
+				from = sockaddrIP(sa)
+				return true
+			}
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, from, rerr
+}
+
+// readErrQueue takes one error off fd's error queue and rebuilds it into
+// buf if it is an ICMP error. queued is false when the queue was empty.
+func readErrQueue(fd int, buf, oob []byte, v6 bool) (n int, from net.IP, queued bool, err error) {
+	// The queued packet is the quoted probe, from its ICMP header on.
+	payload, oobn, _, _, err := syscall.Recvmsg(fd, buf[quoteAt(v6):], oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+	if err == syscall.EAGAIN {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	n, from, err = rebuildICMPError(buf, payload, oob[:oobn], v6)
+	return n, from, true, err
+}
+
+// quoteAt is where an ICMP error's quoted probe starts: after its own
+// header and the quoted IP header.
+func quoteAt(v6 bool) int {
+	if v6 {
+		return 8 + 40
+	}
+	return 8 + 20
+}
+
+// rebuildICMPError turns the sock_extended_err in oob, the control data
+// of a queued error whose quoted probe (payload bytes) is already at
+// buf[quoteAt(v6):], into the ICMP error a raw socket would have read and
+// returns its length and the router that sent it. n is 0 when the error
+// is not a router's ICMP error.
+func rebuildICMPError(buf []byte, payload int, oob []byte, v6 bool) (n int, from net.IP, err error) {
+	level, typ := syscall.IPPROTO_IP, syscall.IP_RECVERR
+	if v6 {
+		level, typ = syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR
+	}
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, m := range cmsgs {
+		// struct sock_extended_err: errno u32; origin, type, code, pad u8;
+		// info, data u32. The offender's sockaddr follows it.
+		ee := m.Data
+		if m.Header.Level != int32(level) || m.Header.Type != int32(typ) || len(ee) < 16 {
+			continue
+		}
+		if ee[4] != eeOriginICMP && ee[4] != eeOriginICMP6 {
+			return 0, nil, nil
+		}
+		if from = offender(ee[16:], v6); from == nil {
+			return 0, nil, nil
+		}
+		hl := quoteAt(v6) - 8
+		clear(buf[:8+hl])
+		buf[0], buf[1] = ee[5], ee[6]
+		if v6 {
+			buf[8] = 6 << 4
+			buf[8+6] = byte(icmp6.proto)
+		} else {
+			buf[8] = 4<<4 | 5
+			buf[8+9] = byte(icmp4.proto)
+		}
+		return 8 + hl + payload, from, nil
+	}
+	return 0, nil, nil
+}
+
+// dgramPending reports whether a send or receive failed with an error an
+// ICMP reply raised on the socket rather than one about this call: any
+// errno the kernel maps an ICMP or ICMPv6 error to.
+func dgramPending(err error) bool {
+	for _, e := range []syscall.Errno{syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.ECONNREFUSED, syscall.ENOPROTOOPT, syscall.EOPNOTSUPP, syscall.EMSGSIZE, syscall.EPROTO, syscall.EACCES} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// offender reads the address in a sockaddr_in or sockaddr_in6.
+func offender(sa []byte, v6 bool) net.IP {
+	if v6 {
+		if len(sa) < 24 || binary.NativeEndian.Uint16(sa) != syscall.AF_INET6 {
+			return nil
+		}
+		return net.IP(slices.Clone(sa[8:24]))
+	}
+	if len(sa) < 8 || binary.NativeEndian.Uint16(sa) != syscall.AF_INET {
+		return nil
+	}
+	return net.IPv4(sa[4], sa[5], sa[6], sa[7])
+}
+
+func sockaddrIP(sa syscall.Sockaddr) net.IP {
+	switch a := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(a.Addr[:]).To16()
+	case *syscall.SockaddrInet6:
+		return net.IP(a.Addr[:])
+	}
+	return nil
+}
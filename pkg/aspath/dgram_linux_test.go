@@ -0,0 +1,96 @@
+//go:build linux
+
+package aspath
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/net/icmp"
+)
+
+// recvErr builds the control message IP_RECVERR (IPV6_RECVERR for v6)
+// delivers with a queued error: a sock_extended_err of origin, ICMP type
+// and code, then the offender's sockaddr.
+func recvErr(v6 bool, origin, typ, code byte, from net.IP) []byte {
+	ee := make([]byte, 16)
+	binary.NativeEndian.PutUint32(ee, uint32(syscall.EHOSTUNREACH))
+	ee[4], ee[5], ee[6] = origin, typ, code
+	level, ctype := syscall.IPPROTO_IP, syscall.IP_RECVERR
+	var sa []byte
+	if v6 {
+		level, ctype = syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR
+		sa = make([]byte, syscall.SizeofSockaddrInet6)
+		binary.NativeEndian.PutUint16(sa, syscall.AF_INET6)
+		copy(sa[8:], from.To16())
+	} else {
+		sa = make([]byte, syscall.SizeofSockaddrInet4)
+		binary.NativeEndian.PutUint16(sa, syscall.AF_INET)
+		copy(sa[4:], from.To4())
+	}
+	data := append(ee, sa...)
+
+	b := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = int32(level), int32(ctype)
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(b[syscall.CmsgLen(0):], data)
+	return b
+}
+
+func TestRebuildICMPError(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		fam    icmpFamily
+		origin byte
+		typ    byte // time exceeded
+		router string
+	}{
+		{"v4", icmp4, eeOriginICMP, 11, "192.0.2.7"},
+		{"v6", icmp6, eeOriginICMP6, 3, "2001:db8::7"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v6 := tt.fam.v6()
+			p := &icmpProber{t: &tracer{fam: tt.fam, opts: Options{Mode: "paris"}}, id: 0x4242}
+			id := probeID{9, 3, 1}
+			// The kernel queues the probe from its ICMP header on.
+			buf := make([]byte, 1500)
+			probe := p.packet(id)
+			copy(buf[quoteAt(v6):], probe)
+
+			n, from, err := rebuildICMPError(buf, len(probe), recvErr(v6, tt.origin, tt.typ, 0, net.ParseIP(tt.router)), v6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !from.Equal(net.ParseIP(tt.router)) {
+				t.Errorf("from %v, want %s", from, tt.router)
+			}
+			msg, err := icmp.ParseMessage(tt.fam.proto, buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := msg.Body.(*icmp.TimeExceeded); !ok {
+				t.Fatalf("rebuilt a %v, want time exceeded", msg.Type)
+			}
+			if got, ok := p.match(msg); !ok || got != id {
+				t.Errorf("rebuilt error matched %+v, %v; want %+v", got, ok, id)
+			}
+
+			// A local error (origin 1), or one without a usable offender,
+			// is no router's answer.
+			if n, _, err := rebuildICMPError(buf, len(probe), recvErr(v6, 1, tt.typ, 0, net.ParseIP(tt.router)), v6); n != 0 || err != nil {
+				t.Errorf("local error rebuilt into %d bytes, %v", n, err)
+			}
+			other := net.ParseIP("192.0.2.1")
+			if !v6 {
+				other = net.ParseIP("2001:db8::1")
+			}
+			if n, _, err := rebuildICMPError(buf, len(probe), recvErr(!v6, tt.origin, tt.typ, 0, other), v6); n != 0 || err != nil {
+				t.Errorf("other family's control message rebuilt into %d bytes, %v", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !js
+
+package aspath
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+)
+
+// Reading ICMP errors on a datagram socket needs Linux's IP_RECVERR.
+func listenDgram(icmpFamily) (*icmp.PacketConn, syscall.RawConn, error) {
+	return nil, nil, errors.New("unprivileged ICMP traces are not supported on this platform")
+}
+
+func readDgram(syscall.RawConn, []byte, bool) (int, net.IP, error) {
+	return 0, nil, errors.New("unprivileged ICMP traces are not supported on this platform")
+}
+
+func dgramPending(error) bool { return false }
@@ -0,0 +1,255 @@
+//go:build !js
+
+package aspath
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+type place struct {
+	city, country, continent string
+	lat, lon                 float64
+	codes                    []string
+}
+
+// places are the cities long-haul backbones are built around.
+var places = []place{
+	// Europe
+	{"Amsterdam", "NL", "EU", 52.37, 4.90, []string{"ams", "amsterdam", "amstnl"}},
+	{"Frankfurt", "DE", "EU", 50.11, 8.68, []string{"fra", "ffm", "frankfurt", "frnkge"}},
+	{"London", "GB", "EU", 51.51, -0.13, []string{"lon", "lhr", "ldn", "london", "londen", "lndngb"}},
+	{"Paris", "FR", "EU", 48.86, 2.35, []string{"par", "cdg", "prs", "paris", "parsfr"}},
+	{"Brussels", "BE", "EU", 50.85, 4.35, []string{"bru", "brussels"}},
+	{"Dusseldorf", "DE", "EU", 51.23, 6.78, []string{"dus", "dusseldorf"}},
+	{"Hamburg", "DE", "EU", 53.55, 9.99, []string{"ham", "hbg", "hamburg"}},
+	{"Berlin", "DE", "EU", 52.52, 13.40, []string{"ber", "berlin"}},
+	{"Munich", "DE", "EU", 48.14, 11.58, []string{"muc", "munich"}},
+	{"Zurich", "CH", "EU", 47.38, 8.54, []string{"zrh", "zurich"}},
+	{"Geneva", "CH", "EU", 46.20, 6.14, []string{"gva", "geneva"}},
+	{"Vienna", "AT", "EU", 48.21, 16.37, []string{"vie", "vienna"}},
+	{"Prague", "CZ", "EU", 50.08, 14.44, []string{"prg", "prague"}},
+	{"Warsaw", "PL", "EU", 52.23, 21.01, []string{"waw", "warsaw"}},
+	{"Budapest", "HU", "EU", 47.50, 19.04, []string{"bud", "budapest"}},
+	{"Bucharest", "RO", "EU", 44.43, 26.10, []string{"otp", "buh", "bucharest"}},
+	{"Sofia", "BG", "EU", 42.70, 23.32, []string{"sof", "sofia"}},
+	{"Copenhagen", "DK", "EU", 55.68, 12.57, []string{"cph", "kbn", "copenhagen"}},
+	{"Stockholm", "SE", "EU", 59.33, 18.07, []string{"sto", "arn", "sth", "stockholm"}},
+	{"Oslo", "NO", "EU", 59.91, 10.75, []string{"osl", "oslo"}},
+	{"Helsinki", "FI", "EU", 60.17, 24.94, []string{"hel", "helsinki"}},
+	{"Madrid", "ES", "EU", 40.42, -3.70, []string{"mad", "madrid"}},
+	{"Barcelona", "ES", "EU", 41.39, 2.17, []string{"bcn", "barcelona"}},
+	{"Lisbon", "PT", "EU", 38.72, -9.14, []string{"lis", "lisbon"}},
+	{"Milan", "IT", "EU", 45.46, 9.19, []string{"mil", "mxp", "milan"}},
+	{"Rome", "IT", "EU", 41.90, 12.50, []string{"rom", "fco", "rome"}},
+	{"Marseille", "FR", "EU", 43.30, 5.37, []string{"mrs", "marseille"}},
+	{"Dublin", "IE", "EU", 53.35, -6.26, []string{"dub", "dublin"}},
+	{"Manchester", "GB", "EU", 53.48, -2.24, []string{"mnc", "manchester"}},
+	{"Istanbul", "TR", "EU", 41.01, 28.98, []string{"ist", "istanbul"}},
+	{"Athens", "GR", "EU", 37.98, 23.73, []string{"ath", "athens"}},
+	{"Kyiv", "UA", "EU", 50.45, 30.52, []string{"kbp", "iev", "kiev", "kyiv"}},
+	{"Moscow", "RU", "EU", 55.76, 37.62, []string{"mow", "svo", "moscow"}},
+	// North America
+	{"New York", "US", "NA", 40.71, -74.01, []string{"nyc", "jfk", "ewr", "lga", "nyk", "newyork", "nycmny"}},
+	{"Ashburn", "US", "NA", 39.04, -77.49, []string{"iad", "ash", "was", "dca", "ashburn", "washington", "asbnva"}},
+	{"Chicago", "US", "NA", 41.88, -87.63, []string{"chi", "ord", "chicago", "chcgil"}},
+	{"Dallas", "US", "NA", 32.78, -96.80, []string{"dfw", "dal", "dallas", "dllstx"}},
+	{"Atlanta", "US", "NA", 33.75, -84.39, []string{"atl", "atlanta", "atlnga"}},
+	{"Miami", "US", "NA", 25.76, -80.19, []string{"mia", "miami", "miamfl"}},
+	{"Los Angeles", "US", "NA", 34.05, -118.24, []string{"lax", "losangeles", "lsanca"}},
+	{"San Jose", "US", "NA", 37.34, -121.89, []string{"sjc", "sjo", "sanjose", "snjsca"}},
+	{"San Francisco", "US", "NA", 37.77, -122.42, []string{"sfo", "sanfrancisco"}},
+	{"Seattle", "US", "NA", 47.61, -122.33, []string{"sea", "seattle", "sttlwa"}},
+	{"Denver", "US", "NA", 39.74, -104.99, []string{"den", "denver", "dnvrco"}},
+	{"Boston", "US", "NA", 42.36, -71.06, []string{"bos", "boston"}},
+	{"Toronto", "CA", "NA", 43.65, -79.38, []string{"yyz", "tor", "toronto"}},
+	{"Montreal", "CA", "NA", 45.50, -73.57, []string{"yul", "mtl", "montreal"}},
+	{"Vancouver", "CA", "NA", 49.28, -123.12, []string{"yvr", "vancouver"}},
+	{"Mexico City", "MX", "NA", 19.43, -99.13, []string{"mex", "qro"}},
+	// South America
+	{"Sao Paulo", "BR", "SA", -23.55, -46.63, []string{"gru", "sao", "spo", "saopaulo"}},
+	{"Rio de Janeiro", "BR", "SA", -22.91, -43.17, []string{"gig", "rio"}},
+	{"Buenos Aires", "AR", "SA", -34.60, -58.38, []string{"eze", "bue", "buenosaires"}},
+	{"Santiago", "CL", "SA", -33.45, -70.67, []string{"scl", "santiago"}},
+	{"Bogota", "CO", "SA", 4.71, -74.07, []string{"bog", "bogota"}},
+	{"Lima", "PE", "SA", -12.05, -77.04, []string{"lim"}},
+	// Asia, Middle East
+	{"Singapore", "SG", "AS", 1.35, 103.82, []string{"sin", "sgp", "singapore", "sngpsg"}},
+	{"Hong Kong", "HK", "AS", 22.32, 114.17, []string{"hkg", "hongkong", "hkghk"}},
+	{"Tokyo", "JP", "AS", 35.68, 139.69, []string{"tyo", "nrt", "hnd", "tokyo", "tokyjp"}},
+	{"Osaka", "JP", "AS", 34.69, 135.50, []string{"osa", "kix", "osaka"}},
+	{"Seoul", "KR", "AS", 37.57, 126.98, []string{"sel", "icn", "seoul"}},
+	{"Taipei", "TW", "AS", 25.03, 121.57, []string{"tpe", "taipei"}},
+	{"Manila", "PH", "AS", 14.60, 120.98, []string{"mnl", "manila"}},
+	{"Bangkok", "TH", "AS", 13.76, 100.50, []string{"bkk", "bangkok"}},
+	{"Kuala Lumpur", "MY", "AS", 3.14, 101.69, []string{"kul", "kualalumpur"}},
+	{"Jakarta", "ID", "AS", -6.21, 106.85, []string{"cgk", "jkt", "jakarta"}},
+	{"Ho Chi Minh City", "VN", "AS", 10.82, 106.63, []string{"sgn", "hcm"}},
+	{"Hanoi", "VN", "AS", 21.03, 105.85, []string{"han", "hanoi"}},
+	{"Mumbai", "IN", "AS", 19.08, 72.88, []string{"bom", "mumbai"}},
+	{"Chennai", "IN", "AS", 13.08, 80.27, []string{"maa", "chennai"}},
+	{"Delhi", "IN", "AS", 28.61, 77.21, []string{"del", "delhi"}},
+	{"Dubai", "AE", "AS", 25.20, 55.27, []string{"dxb", "dubai"}},
+	{"Fujairah", "AE", "AS", 25.13, 56.33, []string{"fjr", "fujairah"}},
+	{"Tel Aviv", "IL", "AS", 32.09, 34.78, []string{"tlv", "telaviv"}},
+	{"Beijing", "CN", "AS", 39.90, 116.41, []string{"pek", "bjs", "beijing"}},
+	{"Shanghai", "CN", "AS", 31.23, 121.47, []string{"pvg", "sha", "shanghai"}},
+	{"Guangzhou", "CN", "AS", 23.13, 113.26, []string{"can", "guangzhou"}},
+	// Oceania
+	{"Sydney", "AU", "OC", -33.87, 151.21, []string{"syd", "sydney"}},
+	{"Melbourne", "AU", "OC", -37.81, 144.96, []string{"mel", "melbourne"}},
+	{"Perth", "AU", "OC", -31.95, 115.86, []string{"per", "perth"}},
+	{"Auckland", "NZ", "OC", -36.85, 174.76, []string{"akl", "auckland"}},
+	// Africa
+	{"Johannesburg", "ZA", "AF", -26.20, 28.05, []string{"jnb", "johannesburg"}},
+	{"Cape Town", "ZA", "AF", -33.92, 18.42, []string{"cpt", "capetown"}},
+	{"Nairobi", "KE", "AF", -1.29, 36.82, []string{"nbo", "nairobi"}},
+	{"Lagos", "NG", "AF", 6.52, 3.38, []string{"lagos"}},
+	{"Cairo", "EG", "AF", 30.04, 31.24, []string{"cai", "cairo"}},
+}
+
+var placeByCode = func() map[string]*place {
+	m := map[string]*place{}
+	for i := range places {
+		for _, c := range places[i].codes {
+			m[c] = &places[i]
+		}
+	}
+	return m
+}()
+
+var continentNames = map[string]string{
+	"EU": "Europe", "NA": "North America", "SA": "South America",
+	"AS": "Asia", "OC": "Oceania", "AF": "Africa",
+}
+
+// Locate guesses where a router is from its PTR name, or returns nil. Only
+// the labels left of the operator's domain are searched, token by token
+// (split at dots and dashes, trailing digits dropped), so "fra03" and
+// "frankfurt1" match but the domain "sea.net" does not.
+func Locate(ptr string) *Geo {
+	labels := strings.Split(strings.ToLower(ptr), ".")
+	if len(labels) <= 2 {
+		return nil
+	}
+	for _, label := range labels[:len(labels)-2] {
+		for _, tok := range strings.FieldsFunc(label, func(r rune) bool { return r == '-' || r == '_' }) {
+			tok = strings.TrimRight(tok, "0123456789")
+			if g := Place(tok); g != nil {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// Place looks a city up by any of the codes Locate recognizes ("fra",
+// "frankfurt"), or returns nil.
+func Place(code string) *Geo {
+	code = strings.ToLower(strings.ReplaceAll(code, " ", ""))
+	p := placeByCode[code]
+	if p == nil {
+		return nil
+	}
+	return &Geo{Code: code, City: p.city, Country: p.country, Continent: p.continent, Lat: p.lat, Lon: p.lon}
+}
+
+// distanceKm is the great-circle distance between two places.
+func distanceKm(a, b *Geo) float64 {
+	const r = 6371.0
+	rad := math.Pi / 180
+	dLat, dLon := (b.Lat-a.Lat)*rad, (b.Lon-a.Lon)*rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * r * math.Asin(math.Sqrt(h))
+}
+
+// Light in fiber covers about 200 km per ms, so every 100 km of distance
+// costs at least 1 ms of round trip.
+const kmPerRTTMs = 100
+
+// MinRTTMs is the shortest round trip between a and b that light in fiber
+// allows.
+func MinRTTMs(a, b *Geo) float64 {
+	return distanceKm(a, b) / kmPerRTTMs
+}
+
+// An Anomaly is a point where a path's RTTs or locations don't add up.
+type Anomaly struct {
+	TTL int `json:"ttl"`
+	// Kind is "detour" (the path leaves the continents it runs between),
+	// "slow" (an RTT jump far beyond what the distance explains, e.g. an
+	// unseen detour inside a tunnel) or "impossible" (faster than light:
+	// a location hint is wrong).
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// CheckPath compares located hops (Hop.Geo) with each other and with
+// their RTTs. A hop's RTT is taken as the lowest of it and every later
+// hop's, since replies from the path beyond it can't be faster; this
+// hides routers that are merely slow to generate ICMP.
+func CheckPath(hops []Hop) []Anomaly {
+	type located struct {
+		ttl int
+		geo *Geo
+		rtt float64
+	}
+	var path []located
+	best := math.Inf(1)
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if h.IP == "" {
+			continue
+		}
+		best = min(best, h.RTTMs)
+		if h.Geo != nil {
+			path = append(path, located{h.TTL, h.Geo, best})
+		}
+	}
+	if len(path) < 2 {
+		return nil
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	var out []Anomaly
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		d := distanceKm(a.geo, b.geo)
+		need := d / kmPerRTTMs
+		switch delta := b.rtt - a.rtt; {
+		case b.rtt+a.rtt < need:
+			out = append(out, Anomaly{b.ttl, "impossible", fmt.Sprintf(
+				"%s → %s is %.0f km, ≥%.0fms round trip, but hops answered in %.0fms and %.0fms — a location hint is wrong",
+				a.geo.City, b.geo.City, d, need, a.rtt, b.rtt)})
+		case delta > 2*need+30:
+			out = append(out, Anomaly{b.ttl, "slow", fmt.Sprintf(
+				"%s → %s: +%.0fms for %.0f km (light needs %.0fms)", a.geo.City, b.geo.City, delta, d, need)})
+		}
+	}
+
+	// A hop on another continent than both ends, well off the direct
+	// line between them, is where the path detours.
+	first, last := path[0].geo, path[len(path)-1].geo
+	direct := distanceKm(first, last)
+	prev := ""
+	for _, p := range path[1 : len(path)-1] {
+		c := p.geo.Continent
+		if c == first.Continent || c == last.Continent {
+			prev = ""
+			continue
+		}
+		extra := distanceKm(first, p.geo) + distanceKm(p.geo, last) - direct
+		if extra < 3000 || c == prev {
+			continue
+		}
+		prev = c
+		out = append(out, Anomaly{p.ttl, "detour", fmt.Sprintf(
+			"%s → %s via %s (%s): ~%.0f km longer than direct", first.City, last.City, p.geo.City, continentNames[c], extra)})
+	}
+	slices.SortStableFunc(out, func(a, b Anomaly) int { return a.TTL - b.TTL })
+	return out
+}
@@ -0,0 +1,106 @@
+//go:build !js
+
+package aspath
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	for _, tt := range []struct {
+		ptr, code, city string
+	}{
+		{"fra03.atlas.cogentco.com", "fra", "Frankfurt"},
+		{"frankfurt1.level3.net", "frankfurt", "Frankfurt"},
+		{"frnkge13.de.bb.gin.ntt.net", "frnkge", "Frankfurt"},
+		{"ffm-bb1.ip.twelve99.net", "ffm", "Frankfurt"},
+		{"AE-2.R21.NYCMNY01.US.BB.GIN.NTT.NET", "nycmny", "New York"},
+		{"xe-0-1-0_lax.core1.example.com", "lax", "Los Angeles"},
+		{"core1.sea.net", "", ""},       // the domain, not a router label
+		{"be2.example.sea.net", "", ""}, // "be" isn't a code
+		{"sea.net", "", ""},
+		{"", "", ""},
+	} {
+		g := Locate(tt.ptr)
+		if tt.city == "" {
+			if g != nil {
+				t.Errorf("Locate(%q) = %s (%s), want nil", tt.ptr, g.City, g.Code)
+			}
+			continue
+		}
+		if g == nil || g.Code != tt.code || g.City != tt.city {
+			t.Errorf("Locate(%q) = %+v, want %s from %q", tt.ptr, g, tt.city, tt.code)
+		}
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	hop := func(ttl int, ptr string, rtt float64) Hop {
+		return Hop{TTL: ttl, IP: fmt.Sprintf("192.0.2.%d", ttl), PTR: ptr, RTTMs: rtt, Geo: Locate(ptr)}
+	}
+	const (
+		fra = "fra03.atlas.cogentco.com"
+		ams = "ams-ix.r1.example.net"
+		nyc = "nyc01.core.example.net"
+		lax = "lax01.core.example.net"
+		tyo = "tyo01.core.example.net"
+	)
+	tests := []struct {
+		name string
+		hops []Hop
+		want []string // "TTL kind"
+	}{
+		{
+			// ~360 km: ≥3.6ms round trip.
+			name: "plausible",
+			hops: []Hop{hop(1, "", 0.5), hop(2, fra, 2), hop(3, ams, 8)},
+		},
+		{
+			name: "one located hop",
+			hops: []Hop{hop(1, fra, 2), hop(2, "", 90)},
+		},
+		{
+			// ~6200 km needs ≥62ms between them.
+			name: "impossible",
+			hops: []Hop{hop(1, fra, 2), hop(2, nyc, 5)},
+			want: []string{"2 impossible"},
+		},
+		{
+			// +58ms for 3.6ms worth of distance.
+			name: "slow",
+			hops: []Hop{hop(1, fra, 2), hop(2, ams, 60)},
+			want: []string{"2 slow"},
+		},
+		{
+			// The Amsterdam router is slow to answer, but the hop after it
+			// (unlocated) shows the path there is fast. Unanswered hops
+			// don't count as 0ms.
+			name: "later hops bound the RTT",
+			hops: []Hop{hop(1, fra, 2), hop(2, ams, 60), hop(3, "", 9), {TTL: 4}},
+		},
+		{
+			// Frankfurt to Tokyo by way of North America: one detour, at
+			// the first hop there.
+			name: "EU to Asia via the US",
+			hops: []Hop{hop(1, fra, 1), hop(2, nyc, 80), hop(3, lax, 140), hop(4, tyo, 250)},
+			want: []string{"2 detour"},
+		},
+		{
+			name: "EU to the US",
+			hops: []Hop{hop(1, fra, 1), hop(2, ams, 6), hop(3, nyc, 80), hop(4, lax, 140)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range CheckPath(tt.hops) {
+				got = append(got, fmt.Sprintf("%d %s", a.TTL, a.Kind))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("CheckPath = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
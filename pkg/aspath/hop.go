@@ -0,0 +1,120 @@
+package aspath
+
+// The result types are free of the probing code, so packages that also
+// build for js/wasm can carry traces.
+
+type Hop struct {
+	TTL    int     `json:"ttl"`
+	IP     string  `json:"ip,omitempty"` // empty when the hop didn't answer
+	PTR    string  `json:"ptr,omitempty"`
+	RTTMs  float64 `json:"rtt_ms,omitempty"`
+	ASN    string  `json:"asn,omitempty"`
+	ASName string  `json:"as_name,omitempty"`
+	// Branches lists every interface that answered at this TTL in "mda"
+	// mode when there was more than one, i.e. load-balanced next hops;
+	// IP is then the first of them.
+	Branches []Hop `json:"branches,omitempty"`
+	// Flows are the flow identifiers that reached this branch (mda only).
+	Flows []int `json:"flows,omitempty"`
+	// MPLS is the label stack the probe carried when it expired, top
+	// first, if the router quoted it (RFC 4950). Hops inside an MPLS
+	// tunnel that don't decrement the IP TTL never show up at all.
+	MPLS []Label `json:"mpls,omitempty"`
+	// IXP is set when the address is on an exchange's peering LAN
+	// (Options.PeeringDB). Such hops usually have no ASN of their own.
+	IXP *IXP `json:"ixp,omitempty"`
+	// Geo is the location hinted at by the PTR name, if any.
+	Geo *Geo `json:"geo,omitempty"`
+}
+
+// Label is one MPLS label stack entry.
+type Label struct {
+	Label int `json:"label"`
+	TC    int `json:"tc,omitempty"` // traffic class
+	TTL   int `json:"ttl"`
+}
+
+type AS struct {
+	ASN  string `json:"asn"`
+	Name string `json:"name,omitempty"` // short (<=10 chars), may be empty
+	// IXP names the exchange the path crossed to enter this AS, if any.
+	IXP string `json:"ixp,omitempty"`
+}
+
+// IXP describes an exchange a hop's address belongs to: traffic crossed
+// that exchange's peering LAN there, into the member whose port answered.
+type IXP struct {
+	Name    string `json:"name"`
+	City    string `json:"city,omitempty"`
+	Country string `json:"country,omitempty"`
+	// MemberASN and MemberName identify the network the address is
+	// assigned to on the exchange; empty when only the LAN matched.
+	MemberASN  string `json:"member_asn,omitempty"`
+	MemberName string `json:"member_name,omitempty"`
+	// Facilities are the buildings where both the exchange and the member
+	// are present, i.e. where the hop physically can be.
+	Facilities []string `json:"facilities,omitempty"`
+}
+
+// Geo is where a hop's PTR name says it is: operators embed IATA airport
+// codes (fra03.atlas.cogentco.com), city names (frankfurt1.level3.net),
+// CLLI prefixes (frnkge13.de.bb.gin.ntt.net) or their own abbreviations
+// (ffm-bb1.ip.twelve99.net) in router names.
+type Geo struct {
+	Code      string  `json:"code"` // the name token that matched
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Continent string  `json:"continent"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+}
+
+// ASPath collapses hops into the AS-level path: every AS traversed, in
+// order. Unanswered and unmapped hops (private ranges) are skipped;
+// consecutive same-AS hops merge. A hop on an exchange's peering LAN
+// counts as the member network it belongs to, or as its own ASN when the
+// member is unknown, and the exchange is noted on the AS it led into.
+func ASPath(hops []Hop) []AS {
+	var path []AS
+	var ixp string
+	for _, h := range hops {
+		asn, name := h.ASN, h.ASName
+		if h.IXP != nil {
+			ixp = h.IXP.Name
+			if h.IXP.MemberASN != "" {
+				asn, name = h.IXP.MemberASN, h.IXP.MemberName
+			}
+		}
+		if asn == "" {
+			continue
+		}
+		if len(path) > 0 && path[len(path)-1].ASN == asn {
+			ixp = ""
+			continue
+		}
+		path = append(path, AS{ASN: asn, Name: name, IXP: ixp})
+		ixp = ""
+	}
+	return path
+}
+
+// PMTU is the outcome of path MTU discovery toward a destination. Sizes
+// are whole IP packets, headers included.
+type PMTU struct {
+	MTU int `json:"mtu"` // largest packet that reached the destination
+	Max int `json:"max"` // largest size tried; MTU == Max means no limit found
+	// Reason says why larger packets failed: "too-big" (a router sent
+	// fragmentation needed / packet too big), "black-hole" (they vanished
+	// without an ICMP error, typically inside a tunnel) or "local" (the
+	// outgoing interface's MTU). Empty when MTU == Max.
+	Reason string `json:"reason,omitempty"`
+	// LimitTTL and LimitIP locate where larger packets stop: the router
+	// that reported the limit or, for a black hole, the hop after the last
+	// one large probes still reached (LimitIP is then unknown). LimitTTL
+	// is 0 for the local interface or when the hop couldn't be found.
+	LimitTTL int    `json:"limit_ttl,omitempty"`
+	LimitIP  string `json:"limit_ip,omitempty"`
+	// ReportedMTU is the next-hop MTU the limiting router announced.
+	ReportedMTU int `json:"reported_mtu,omitempty"`
+	Probes      int `json:"probes"`
+}
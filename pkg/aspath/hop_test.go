@@ -0,0 +1,64 @@
+package aspath
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestASPath(t *testing.T) {
+	as := func(asn, name string) Hop { return Hop{IP: "192.0.2.1", ASN: asn, ASName: name} }
+	lan := func(ix, member, name string) Hop {
+		return Hop{IP: "198.51.100.1", IXP: &IXP{Name: ix, MemberASN: member, MemberName: name}}
+	}
+	// The LAN hop is the member's port, which overrides any ASN the
+	// exchange's own prefix maps to.
+	port := lan("DE-CIX", "2914", "ntt")
+	port.ASN = "6695"
+	// Without a known member, the hop is whatever its address maps to.
+	unknown := lan("DE-CIX", "", "")
+	unknown.ASN, unknown.ASName = "6939", "he"
+	tests := []struct {
+		name string
+		hops []Hop
+		want []AS
+	}{
+		{"empty", nil, nil},
+		{
+			name: "skips unanswered and unmapped",
+			hops: []Hop{{IP: "10.0.0.1"}, {}, as("174", "cogent"), {}, as("174", "cogent"), as("2914", "ntt")},
+			want: []AS{{ASN: "174", Name: "cogent"}, {ASN: "2914", Name: "ntt"}},
+		},
+		{
+			name: "keeps non-adjacent repeats",
+			hops: []Hop{as("174", "cogent"), as("2914", "ntt"), as("174", "cogent")},
+			want: []AS{{ASN: "174", Name: "cogent"}, {ASN: "2914", Name: "ntt"}, {ASN: "174", Name: "cogent"}},
+		},
+		{
+			name: "member port",
+			hops: []Hop{as("174", "cogent"), port, as("2914", "ntt")},
+			want: []AS{{ASN: "174", Name: "cogent"}, {ASN: "2914", Name: "ntt", IXP: "DE-CIX"}},
+		},
+		{
+			name: "LAN only: the exchange carries over to the next AS",
+			hops: []Hop{as("174", "cogent"), lan("DE-CIX", "", ""), {}, as("2914", "ntt")},
+			want: []AS{{ASN: "174", Name: "cogent"}, {ASN: "2914", Name: "ntt", IXP: "DE-CIX"}},
+		},
+		{
+			name: "LAN without a member",
+			hops: []Hop{as("174", "cogent"), unknown, as("2914", "ntt")},
+			want: []AS{{ASN: "174", Name: "cogent"}, {ASN: "6939", Name: "he", IXP: "DE-CIX"}, {ASN: "2914", Name: "ntt"}},
+		},
+		{
+			name: "LAN only, back into the same AS",
+			hops: []Hop{as("174", "cogent"), lan("DE-CIX", "", ""), as("174", "cogent"), as("2914", "ntt")},
+			want: []AS{{ASN: "174", Name: "cogent"}, {ASN: "2914", Name: "ntt"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ASPath(tt.hops); !slices.Equal(got, tt.want) {
+				t.Errorf("ASPath = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+//go:build !js
+
+package aspath
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+)
+
+// PeeringDB is an offline PeeringDB dataset mapping peering LAN addresses
+// to exchanges and their members.
+type PeeringDB struct {
+	ixs      map[int]pdbIX
+	nets     map[int]pdbNet
+	facs     map[int]string
+	ixFacs   map[int][]int
+	netFacs  map[int]map[int]bool
+	ports    map[netip.Addr]pdbPort
+	prefixes []pdbPrefix
+}
+
+type pdbIX struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+type pdbNet struct {
+	ID   int    `json:"id"`
+	ASN  int    `json:"asn"`
+	Name string `json:"name"`
+}
+
+type pdbPort struct {
+	IXID  int `json:"ix_id"`
+	NetID int `json:"net_id"`
+	ASN   int `json:"asn"`
+}
+
+type pdbPrefix struct {
+	prefix netip.Prefix
+	ix     int
+}
+
+// pdbDump is the layout of a full PeeringDB export (as served by CAIDA's
+// PeeringDB archive, or assembled from the /api/<object> endpoints): each
+// object type keyed by name with its rows under "data".
+type pdbDump struct {
+	IX struct {
+		Data []pdbIX `json:"data"`
+	} `json:"ix"`
+	IXLan struct {
+		Data []struct {
+			ID   int `json:"id"`
+			IXID int `json:"ix_id"`
+		} `json:"data"`
+	} `json:"ixlan"`
+	IXPfx struct {
+		Data []struct {
+			IXLanID int    `json:"ixlan_id"`
+			Prefix  string `json:"prefix"`
+		} `json:"data"`
+	} `json:"ixpfx"`
+	NetIXLan struct {
+		Data []struct {
+			pdbPort
+			IPAddr4 *string `json:"ipaddr4"`
+			IPAddr6 *string `json:"ipaddr6"`
+		} `json:"data"`
+	} `json:"netixlan"`
+	Net struct {
+		Data []pdbNet `json:"data"`
+	} `json:"net"`
+	Fac struct {
+		Data []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"data"`
+	} `json:"fac"`
+	IXFac struct {
+		Data []struct {
+			IXID  int `json:"ix_id"`
+			FacID int `json:"fac_id"`
+		} `json:"data"`
+	} `json:"ixfac"`
+	NetFac struct {
+		Data []struct {
+			NetID int `json:"net_id"`
+			FacID int `json:"fac_id"`
+		} `json:"data"`
+	} `json:"netfac"`
+}
+
+// LoadPeeringDB reads a full PeeringDB JSON export, optionally gzip- or
+// bzip2-compressed. It needs the ix, ixlan, ixpfx and netixlan objects;
+// net, fac, ixfac and netfac add member names and facilities.
+func LoadPeeringDB(path string) (*PeeringDB, error) {
+	f, r, err := openData(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var d pdbDump
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(d.IX.Data) == 0 || len(d.IXPfx.Data) == 0 {
+		return nil, fmt.Errorf("%s: no ix/ixpfx objects; want a full PeeringDB export", path)
+	}
+
+	db := &PeeringDB{
+		ixs:     map[int]pdbIX{},
+		nets:    map[int]pdbNet{},
+		facs:    map[int]string{},
+		ixFacs:  map[int][]int{},
+		netFacs: map[int]map[int]bool{},
+		ports:   map[netip.Addr]pdbPort{},
+	}
+	for _, ix := range d.IX.Data {
+		db.ixs[ix.ID] = ix
+	}
+	for _, n := range d.Net.Data {
+		db.nets[n.ID] = n
+	}
+	for _, fac := range d.Fac.Data {
+		db.facs[fac.ID] = fac.Name
+	}
+	for _, l := range d.IXFac.Data {
+		db.ixFacs[l.IXID] = append(db.ixFacs[l.IXID], l.FacID)
+	}
+	for _, l := range d.NetFac.Data {
+		if db.netFacs[l.NetID] == nil {
+			db.netFacs[l.NetID] = map[int]bool{}
+		}
+		db.netFacs[l.NetID][l.FacID] = true
+	}
+	lanIX := map[int]int{}
+	for _, l := range d.IXLan.Data {
+		lanIX[l.ID] = l.IXID
+	}
+	for _, p := range d.IXPfx.Data {
+		prefix, err := netip.ParsePrefix(p.Prefix)
+		if ix, ok := lanIX[p.IXLanID]; ok && err == nil {
+			db.prefixes = append(db.prefixes, pdbPrefix{prefix.Masked(), ix})
+		}
+	}
+	for _, p := range d.NetIXLan.Data {
+		for _, s := range []*string{p.IPAddr4, p.IPAddr6} {
+			if s == nil {
+				continue
+			}
+			if addr, err := netip.ParseAddr(*s); err == nil {
+				db.ports[addr] = p.pdbPort
+			}
+		}
+	}
+	return db, nil
+}
+
+// Lookup returns the exchange whose peering LAN holds ip, with the member
+// assigned that address if PeeringDB lists it; nil if ip isn't on an IXP.
+func (db *PeeringDB) Lookup(ip net.IP) *IXP {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil
+	}
+	addr = addr.Unmap()
+	if port, ok := db.ports[addr]; ok {
+		ixp := db.ixp(port.IXID)
+		ixp.MemberASN = strconv.Itoa(port.ASN)
+		if n, ok := db.nets[port.NetID]; ok {
+			ixp.MemberName = shortName(n.Name)
+		}
+		for _, fac := range db.ixFacs[port.IXID] {
+			if db.netFacs[port.NetID][fac] && db.facs[fac] != "" {
+				ixp.Facilities = append(ixp.Facilities, db.facs[fac])
+			}
+		}
+		slices.Sort(ixp.Facilities)
+		return ixp
+	}
+	for _, p := range db.prefixes {
+		if p.prefix.Contains(addr) {
+			return db.ixp(p.ix)
+		}
+	}
+	return nil
+}
+
+func (db *PeeringDB) ixp(id int) *IXP {
+	ix := db.ixs[id]
+	return &IXP{Name: ix.Name, City: ix.City, Country: ix.Country}
+}
+
+// Exchanges is the number of exchanges and member ports loaded.
+func (db *PeeringDB) Exchanges() (ixs, ports int) {
+	return len(db.ixs), len(db.ports)
+}
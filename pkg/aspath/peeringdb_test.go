@@ -0,0 +1,78 @@
+//go:build !js
+
+package aspath
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPeeringDBLookup(t *testing.T) {
+	db, err := LoadPeeringDB("testdata/peeringdb.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decix := func(member, name string, facs ...string) *IXP {
+		return &IXP{Name: "DE-CIX Frankfurt", City: "Frankfurt", Country: "DE",
+			MemberASN: member, MemberName: name, Facilities: facs}
+	}
+	ntt := decix("2914", "ntt", "Equinix FR5", "Interxion FRA1") // not Digital Realty: ntt isn't there
+	for _, tt := range []struct {
+		addr string
+		want *IXP
+	}{
+		{"80.81.192.157", ntt},
+		{"2001:7f8::b62:0:1", ntt},
+		{"::ffff:80.81.192.157", ntt},       // IPv4-mapped is IPv4
+		{"80.81.193.1", decix("64500", "")}, // member without a net object
+		{"80.81.195.9", decix("", "")},      // on the LAN, no port assigned
+		{"2001:7f8::ffff", decix("", "")},
+		{"80.249.209.150", &IXP{Name: "AMS-IX", City: "Amsterdam", Country: "NL",
+			MemberASN: "2914", MemberName: "ntt", Facilities: []string{"Equinix AM7"}}},
+		{"80.249.215.1", &IXP{Name: "AMS-IX", City: "Amsterdam", Country: "NL"}},
+		{"80.81.200.1", nil},
+		{"192.0.2.1", nil}, // prefix of an ixlan the dump doesn't have
+		{"2001:7f8:1::1", nil},
+	} {
+		if got := db.Lookup(net.ParseIP(tt.addr)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%s) = %+v, want %+v", tt.addr, got, tt.want)
+		}
+	}
+	if ixs, ports := db.Exchanges(); ixs != 2 || ports != 4 {
+		t.Errorf("loaded %d exchanges and %d ports, want 2 and 4", ixs, ports)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.json")
+	os.WriteFile(empty, []byte(`{"net": {"data": []}}`), 0o644)
+	if _, err := LoadPeeringDB(empty); err == nil {
+		t.Error("LoadPeeringDB of a dump without exchanges: want an error")
+	}
+}
+
+// A trace that crosses DE-CIX from Cogent into NTT: the hop on the LAN
+// answers from NTT's port and has no ASN of its own.
+func TestASPathPeeringDB(t *testing.T) {
+	db, err := LoadPeeringDB("testdata/peeringdb.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hops := []Hop{
+		{TTL: 1, IP: "192.168.1.1"},
+		{TTL: 2},
+		{TTL: 3, IP: "154.54.56.93", ASN: "174", ASName: "cogent"},
+		{TTL: 4, IP: "154.54.36.54", ASN: "174", ASName: "cogent"},
+		{TTL: 5, IP: "80.81.192.157"},
+		{TTL: 6, IP: "129.250.4.84", ASN: "2914", ASName: "ntt"},
+	}
+	for i := range hops {
+		hops[i].IXP = db.Lookup(net.ParseIP(hops[i].IP))
+	}
+	want := []AS{{ASN: "174", Name: "cogent"}, {ASN: "2914", Name: "ntt", IXP: "DE-CIX Frankfurt"}}
+	if got := ASPath(hops); !slices.Equal(got, want) {
+		t.Errorf("ASPath = %+v, want %+v", got, want)
+	}
+}
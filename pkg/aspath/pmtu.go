@@ -0,0 +1,312 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const pmtuMax = 1500
+
+// Common MTUs below Ethernet's: PPPoE, GRE, IPsec, MPLS and VPN
+// encapsulations, then the IPv6 and IPv4 minimums.
+var pmtuPlateaus = []int{1500, 1492, 1480, 1476, 1472, 1460, 1450, 1440, 1420, 1400, 1380, 1360, 1300, 1280, 1024, 576}
+
+// PathMTU finds the largest packet that reaches host unfragmented by
+// sending DF-flagged ICMP echoes of decreasing size (jumping straight to
+// any MTU a router reports, then bisecting), and locates the hop that
+// limits it with large TTL-limited probes. The destination must answer
+// ICMP echo. Options.Family, MaxHops and HopTimeout apply.
+func PathMTU(ctx context.Context, host string, opts Options) (*PMTU, error) {
+	opts.defaults()
+	dst, err := resolve(host, opts.Family)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newPMTUSocket(dst, opts.HopTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer s.conn.Close()
+
+	floor := 576
+	if s.fam.v6() {
+		floor = 1280
+	}
+	p := &pmtuProber{conn: s}
+	res := p.discover(ctx, floor, opts.MaxHops)
+	if res == nil {
+		return nil, fmt.Errorf("%s doesn't answer %d-byte ICMP echo, so its path MTU can't be measured", host, floor)
+	}
+	return res, nil
+}
+
+// discover is PathMTU's search, from floor (which must get through, else
+// it returns nil) up to pmtuMax.
+func (p *pmtuProber) discover(ctx context.Context, floor, maxHops int) *PMTU {
+	res := &PMTU{Max: pmtuMax}
+	if r := p.try(ctx, floor); r.kind != pmtuEcho {
+		return nil
+	}
+	lo, hi := floor, 0 // largest size that got through, smallest that didn't
+	var fail pmtuReply
+	failed := func(size int, r pmtuReply) {
+		if hi == 0 || size < hi {
+			hi, fail = size, r
+		}
+	}
+
+	// Walk down the plateaus, taking any MTU a router reports as the next
+	// size, until a probe gets through.
+	size := pmtuMax
+	for ctx.Err() == nil {
+		r := p.try(ctx, size)
+		if r.kind == pmtuEcho {
+			lo = size
+			break
+		}
+		failed(size, r)
+		next := 0
+		if r.kind == pmtuTooBig && r.mtu > lo && r.mtu < size {
+			next = r.mtu
+		} else {
+			for _, s := range pmtuPlateaus {
+				if s < size && s > lo {
+					next = s
+					break
+				}
+			}
+		}
+		if next == 0 {
+			break
+		}
+		size = next
+	}
+	// Bisect what the plateaus skipped, unless a router said exactly.
+	for hi-lo > 1 && ctx.Err() == nil && !(fail.kind == pmtuTooBig && fail.mtu == lo) {
+		mid := (lo + hi) / 2
+		if r := p.try(ctx, mid); r.kind == pmtuEcho {
+			lo = mid
+		} else {
+			failed(mid, r)
+		}
+	}
+	res.MTU = lo
+	if hi == 0 {
+		res.Probes = p.probes
+		return res
+	}
+
+	switch fail.kind {
+	case pmtuTooBig:
+		res.Reason, res.LimitIP, res.ReportedMTU = "too-big", fail.from.String(), fail.mtu
+	case pmtuLocal:
+		res.Reason = "local"
+	default:
+		res.Reason = "black-hole"
+	}
+	if fail.kind != pmtuLocal {
+		res.LimitTTL = p.locate(ctx, hi, maxHops, fail.from)
+	}
+	res.Probes = p.probes
+	return res
+}
+
+const (
+	pmtuTimeout = iota
+	pmtuEcho
+	pmtuTooBig
+	pmtuTimeExceeded
+	pmtuLocal
+)
+
+type pmtuReply struct {
+	kind int
+	from net.IP
+	mtu  int // next-hop MTU of a too-big reply
+}
+
+// pmtuProber runs the search over a pmtuConn, counting the probes sent.
+type pmtuProber struct {
+	conn   pmtuConn
+	probes int
+}
+
+// A pmtuConn sends DF echoes of a given size and TTL toward the
+// destination and collects the replies to them.
+type pmtuConn interface {
+	send(size, ttl int) (seq int, err error)
+	// collect reads replies to the probes in seqs (seq → key) until all
+	// have answered or the wait has passed, and returns them by key.
+	collect(ctx context.Context, seqs map[int]int) map[int]pmtuReply
+}
+
+// pmtuSocket is the pmtuConn of PathMTU: its own raw socket.
+type pmtuSocket struct {
+	fam  icmpFamily
+	dst  net.IP
+	conn *net.IPConn
+	id   int
+	seq  int
+	wait time.Duration
+	buf  []byte
+}
+
+func newPMTUSocket(dst net.IP, wait time.Duration) (*pmtuSocket, error) {
+	fam := icmp4
+	if dst.To4() == nil {
+		fam = icmp6
+	}
+	conn, err := net.ListenIP(fam.network, &net.IPAddr{IP: net.ParseIP(fam.listen)})
+	if err != nil {
+		return nil, ErrNoPermission
+	}
+	rc, err := conn.SyscallConn()
+	if err == nil {
+		err = setDontFragment(rc, fam.v6())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	id := (os.Getpid() + int(traceSeq.Add(1))) & 0xffff
+	return &pmtuSocket{fam: fam, dst: dst, conn: conn, id: id, wait: wait, buf: make([]byte, 65536)}, nil
+}
+
+// try sends one probe of size to the destination, once more if nothing
+// came back, and returns the outcome.
+func (p *pmtuProber) try(ctx context.Context, size int) pmtuReply {
+	var r pmtuReply
+	for attempt := 0; attempt < 2 && ctx.Err() == nil; attempt++ {
+		seq, err := p.send(size, maxTTL)
+		if tooBigLocally(err) {
+			return pmtuReply{kind: pmtuLocal}
+		}
+		if err != nil {
+			return r
+		}
+		if r = p.conn.collect(ctx, map[int]int{seq: 0})[0]; r.kind != pmtuTimeout {
+			return r
+		}
+	}
+	return r
+}
+
+// locate finds the TTL where probes of size stop: the first hop that
+// reports them too big (or is reporter), else the hop after the last one
+// whose time-exceeded reply came back for them. 0 if unknown.
+func (p *pmtuProber) locate(ctx context.Context, size, maxHops int, reporter net.IP) int {
+	seqs := map[int]int{}
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		seq, err := p.send(size, ttl)
+		if err != nil {
+			return 0
+		}
+		seqs[seq] = ttl
+	}
+	got := p.conn.collect(ctx, seqs)
+	lastPassed := 0
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		r, ok := got[ttl]
+		switch {
+		case !ok:
+		case r.kind == pmtuTooBig || reporter != nil && r.from.Equal(reporter):
+			return ttl
+		case r.kind == pmtuTimeExceeded:
+			lastPassed = ttl
+		}
+	}
+	if lastPassed == 0 || lastPassed == maxHops {
+		return 0
+	}
+	return lastPassed + 1
+}
+
+func (p *pmtuProber) send(size, ttl int) (seq int, err error) {
+	p.probes++
+	return p.conn.send(size, ttl)
+}
+
+func (p *pmtuSocket) send(size, ttl int) (seq int, err error) {
+	hdr := 20
+	if p.fam.v6() {
+		hdr = 40
+		err = ipv6.NewPacketConn(p.conn).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewPacketConn(p.conn).SetTTL(ttl)
+	}
+	if err != nil {
+		return 0, err
+	}
+	p.seq = (p.seq + 1) & 0xffff
+	wm := icmp.Message{
+		Type: p.fam.echo,
+		Body: &icmp.Echo{ID: p.id, Seq: p.seq, Data: make([]byte, max(size-hdr-8, 0))},
+	}
+	wb, _ := wm.Marshal(nil)
+	_, err = p.conn.WriteTo(wb, &net.IPAddr{IP: p.dst})
+	return p.seq, err
+}
+
+func (p *pmtuSocket) collect(ctx context.Context, seqs map[int]int) map[int]pmtuReply {
+	got := map[int]pmtuReply{}
+	deadline := time.Now().Add(p.wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	p.conn.SetReadDeadline(deadline)
+	for len(got) < len(seqs) {
+		n, peer, err := p.conn.ReadFrom(p.buf)
+		if err != nil {
+			break
+		}
+		msg, err := icmp.ParseMessage(p.fam.proto, p.buf[:n])
+		if err != nil {
+			continue
+		}
+		r := pmtuReply{from: peer.(*net.IPAddr).IP}
+		var ident, seq int
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if msg.Type != p.fam.echoReply {
+				continue
+			}
+			r.kind, ident, seq = pmtuEcho, body.ID, body.Seq
+		case *icmp.TimeExceeded:
+			r.kind = pmtuTimeExceeded
+		case *icmp.PacketTooBig:
+			r.kind, r.mtu = pmtuTooBig, body.MTU
+		case *icmp.DstUnreach:
+			// Code 4 is fragmentation needed; RFC 1191 puts the next-hop
+			// MTU in the header's otherwise unused low 16 bits.
+			if msg.Code != 4 || n < 8 {
+				continue
+			}
+			r.kind, r.mtu = pmtuTooBig, int(binary.BigEndian.Uint16(p.buf[6:8]))
+		default:
+			continue
+		}
+		if r.kind != pmtuEcho {
+			proto, _, l4 := quoted(msg)
+			if proto != p.fam.proto || len(l4) < 8 || l4[0] != byte(icmpType(p.fam.echo)) {
+				continue
+			}
+			ident, seq = int(binary.BigEndian.Uint16(l4[4:])), int(binary.BigEndian.Uint16(l4[6:]))
+		}
+		if key, ok := seqs[seq]; ok && ident == p.id {
+			if _, dup := got[key]; !dup {
+				got[key] = r
+			}
+		}
+	}
+	return got
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package aspath
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setDontFragment makes the raw socket send with DF set (IPv6 never
+// fragments in transit anyway) and ignore the kernel's cached path MTU,
+// so every probe leaves at the size asked for.
+func setDontFragment(c syscall.RawConn, v6 bool) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// tooBigLocally reports a send refused because it exceeds the outgoing
+// interface's MTU.
+func tooBigLocally(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !js
+
+package aspath
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Probing with DF set and the kernel's path MTU cache bypassed needs
+// Linux's IP_MTU_DISCOVER.
+func setDontFragment(syscall.RawConn, bool) error {
+	return errors.New("path MTU discovery is not supported on this platform")
+}
+
+func tooBigLocally(error) bool { return false }
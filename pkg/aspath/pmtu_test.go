@@ -0,0 +1,149 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// A fakeHop is one router on a simulated path: the MTU of its link onward
+// (0 = 1500), and whether it drops what doesn't fit silently rather than
+// reporting the MTU (report, which old routers give as 0).
+type fakeHop struct {
+	mtu       int
+	blackHole bool
+	report    int
+}
+
+// fakePath is a pmtuConn over a simulated path whose last hop is the
+// destination. Routers answer expired probes in order, as real ones do,
+// before checking the onward link.
+type fakePath struct {
+	hops    []fakeHop
+	seq     int
+	replies map[int]pmtuReply
+}
+
+func hopIP(ttl int) net.IP { return net.ParseIP(fmt.Sprintf("192.0.2.%d", ttl)) }
+
+func (f *fakePath) send(size, ttl int) (int, error) {
+	f.seq++
+	f.replies[f.seq] = f.reply(size, ttl)
+	return f.seq, nil
+}
+
+func (f *fakePath) reply(size, ttl int) pmtuReply {
+	for i, h := range f.hops {
+		at := i + 1
+		if at == len(f.hops) {
+			return pmtuReply{kind: pmtuEcho, from: hopIP(at)}
+		}
+		if ttl == at {
+			return pmtuReply{kind: pmtuTimeExceeded, from: hopIP(at)}
+		}
+		if h.mtu > 0 && size > h.mtu {
+			if h.blackHole {
+				return pmtuReply{}
+			}
+			return pmtuReply{kind: pmtuTooBig, from: hopIP(at), mtu: h.report}
+		}
+	}
+	return pmtuReply{}
+}
+
+func (f *fakePath) collect(_ context.Context, seqs map[int]int) map[int]pmtuReply {
+	got := map[int]pmtuReply{}
+	for seq, key := range seqs {
+		if r := f.replies[seq]; r.kind != pmtuTimeout {
+			got[key] = r
+		}
+	}
+	return got
+}
+
+func TestPathMTU(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []fakeHop
+		want PMTU
+	}{
+		{
+			name: "no limit",
+			hops: make([]fakeHop, 6),
+			want: PMTU{MTU: 1500, Max: 1500},
+		},
+		{
+			// A GRE tunnel whose head end says how big: straight there,
+			// no plateau walk or bisection.
+			name: "reported",
+			hops: []fakeHop{{}, {}, {mtu: 1476, report: 1476}, {}, {}, {}},
+			want: PMTU{MTU: 1476, Max: 1500, Reason: "too-big", LimitTTL: 3, LimitIP: "192.0.2.3", ReportedMTU: 1476},
+		},
+		{
+			// Off the plateaus and silent: the walk stops at 1450, the
+			// bisection finds 1454, and the limit is the hop after the
+			// last one that 1455-byte probes still reached.
+			name: "black hole",
+			hops: []fakeHop{{}, {}, {}, {mtu: 1454, blackHole: true}, {}, {}, {}},
+			want: PMTU{MTU: 1454, Max: 1500, Reason: "black-hole", LimitTTL: 5},
+		},
+		{
+			// A router reporting MTU 0: the plateaus and bisection find
+			// the size, and locate finds the router by its address.
+			name: "reported zero",
+			hops: []fakeHop{{}, {mtu: 1407}, {}, {}},
+			want: PMTU{MTU: 1407, Max: 1500, Reason: "too-big", LimitTTL: 2, LimitIP: "192.0.2.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakePath{hops: tt.hops, replies: map[int]pmtuReply{}}
+			p := &pmtuProber{conn: f}
+			got := p.discover(context.Background(), 576, 30)
+			if got == nil {
+				t.Fatal("floor-sized probe unanswered")
+			}
+			if got.Probes != f.seq || got.Probes == 0 {
+				t.Errorf("counted %d probes, sent %d", got.Probes, f.seq)
+			}
+			got.Probes = 0
+			if *got != tt.want {
+				t.Errorf("got %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathMTUFloorUnanswered(t *testing.T) {
+	// Nothing gets past the first hop: no path MTU to speak of.
+	f := &fakePath{hops: []fakeHop{{mtu: 500, blackHole: true}, {}}, replies: map[int]pmtuReply{}}
+	if got := (&pmtuProber{conn: f}).discover(context.Background(), 576, 30); got != nil {
+		t.Errorf("got %+v, want nil", *got)
+	}
+}
+
+func TestPMTULocate(t *testing.T) {
+	hops := []fakeHop{{}, {}, {}, {mtu: 1400, blackHole: true}, {}}
+	for _, tt := range []struct {
+		name     string
+		size     int
+		maxHops  int
+		reporter net.IP
+		want     int
+	}{
+		{"black hole", 1401, 30, nil, 5},
+		{"past max hops", 1401, 4, nil, 0},  // last reached hop is the last probed
+		{"reporter", 1401, 30, hopIP(2), 2}, // a router named by its reply
+		{"unknown reporter", 1401, 30, hopIP(9), 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pmtuProber{conn: &fakePath{hops: hops, replies: map[int]pmtuReply{}}}
+			if got := p.locate(context.Background(), tt.size, tt.maxHops, tt.reporter); got != tt.want {
+				t.Errorf("locate = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,748 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IANA protocol numbers of quoted probe headers.
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// Limits of the probe identifier encodings below.
+const (
+	maxTTL   = 64
+	maxFlows = 128
+)
+
+// mdaProbes[k] is how many flows MDA sends at one TTL after seeing k
+// distinct interfaces, to rule out a (k+1)th with 95% confidence
+// (Veitch et al., "Failure Control in Multipath Route Tracing"). Past the
+// table the hop is considered fully enumerated.
+var mdaProbes = []int{6, 6, 11, 16, 21, 27, 33, 38, 44, 51, 57, 63, 70, 76, 83, 90, 96}
+
+var traceSeq atomic.Uint32
+
+// A probeID names one probe: its TTL, its flow (constant in "paris" mode,
+// varied in "mda" mode) and which attempt at that TTL and flow it is.
+type probeID struct{ ttl, flow, attempt int }
+
+// A prober sends one protocol's probes and recognizes the ICMP messages
+// answering them. Each protocol has its own way of holding the flow
+// identifier (what ECMP routers hash on) constant while still telling
+// probes apart.
+type prober interface {
+	send(ctx context.Context, id probeID) error
+	// exclusive reports whether only one probe per flow can be in flight
+	// at a time (TCP with fixed source ports).
+	exclusive() bool
+	// match reports which probe msg, read from the tracer's socket,
+	// answers.
+	match(msg *icmp.Message) (probeID, bool)
+	close()
+}
+
+// An answer is a probe's reply: who sent it, when it arrived, and the
+// MPLS labels the reply quoted.
+type answer struct {
+	id   probeID
+	from net.IP
+	at   time.Time
+	mpls []Label
+}
+
+// tracer owns the ICMP socket every reply arrives on: a raw one, or for
+// unprivileged ICMP probes an ICMP datagram socket (see listenDgram).
+type tracer struct {
+	opts  Options
+	fam   icmpFamily
+	dst   net.IP
+	conn  *icmp.PacketConn
+	dgram syscall.RawConn // set for a datagram socket
+	p     prober
+	// oob carries answers that don't arrive as ICMP (TCP handshakes with
+	// the destination); senders wake the reader by expiring its deadline.
+	oob chan answer
+	lim *limiter
+	buf []byte
+}
+
+func newTracer(opts Options, dst net.IP, lim *limiter) (*tracer, error) {
+	fam := icmp4
+	if dst.To4() == nil {
+		fam = icmp6
+	}
+	conn, dgram, err := listenICMP(fam, opts.Proto)
+	if err != nil {
+		return nil, err
+	}
+	t := &tracer{opts: opts, fam: fam, dst: dst, conn: conn, dgram: dgram, oob: make(chan answer, 2*maxFlows), lim: lim, buf: make([]byte, 1500)}
+	if t.p, err = t.newProber(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tracer) close() {
+	t.p.close()
+	t.conn.Close()
+}
+
+// listenICMP opens the raw ICMP socket of fam or, failing that, an ICMP
+// datagram socket when proto is "icmp": UDP and TCP probes need the raw
+// socket to see the routers' replies.
+func listenICMP(fam icmpFamily, proto string) (*icmp.PacketConn, syscall.RawConn, error) {
+	if conn, err := icmp.ListenPacket(fam.network, fam.listen); err == nil {
+		return conn, nil, nil
+	}
+	if proto == "icmp" {
+		if conn, rc, err := listenDgram(fam); err == nil {
+			return conn, rc, nil
+		}
+	}
+	return nil, nil, ErrNoPermission
+}
+
+// SocketMode reports the socket traces with probe protocol proto ("" is
+// icmp) would read replies on: "raw", or "dgram" for an unprivileged ICMP
+// datagram socket. It returns ErrNoPermission when neither can be opened.
+func SocketMode(family, proto string) (string, error) {
+	fam := icmp4
+	if family == "v6" {
+		fam = icmp6
+	}
+	if proto == "" {
+		proto = "icmp"
+	}
+	conn, dgram, err := listenICMP(fam, proto)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	if dgram != nil {
+		return "dgram", nil
+	}
+	return "raw", nil
+}
+
+// read reads the next ICMP message into t.buf and returns its length and
+// sender.
+func (t *tracer) read() (int, net.IP, error) {
+	if t.dgram != nil {
+		return readDgram(t.dgram, t.buf, t.fam.v6())
+	}
+	n, peer, err := t.conn.ReadFrom(t.buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, peer.(*net.IPAddr).IP, nil
+}
+
+// write sends an ICMP message to the destination.
+func (t *tracer) write(b []byte) error {
+	if t.dgram == nil {
+		_, err := t.conn.WriteTo(b, &net.IPAddr{IP: t.dst})
+		return err
+	}
+	var err error
+	for try := 0; try < 8; try++ {
+		// A send fails with the error an earlier probe's ICMP reply left
+		// pending on the socket, which says nothing about this one; that
+		// clears it, so the next attempt goes out.
+		if _, err = t.conn.WriteTo(b, &net.UDPAddr{IP: t.dst}); !dgramPending(err) {
+			break
+		}
+	}
+	return err
+}
+
+// trace probes every TTL with a single flow at once, then the silent ones
+// short of the destination once more, and cuts the path at the first TTL
+// the destination answered.
+func (t *tracer) trace(ctx context.Context) ([]Hop, error) {
+	hops := make([]Hop, t.opts.MaxHops)
+	last := len(hops)
+	for attempt := 0; attempt < 2; attempt++ {
+		var ids []probeID
+		for i := range hops[:last] {
+			if hops[i].IP == "" {
+				ids = append(ids, probeID{i + 1, 0, attempt})
+			}
+		}
+		if len(ids) == 0 || ctx.Err() != nil {
+			break
+		}
+		got, err := t.probeAll(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if a, ok := got[id]; ok {
+				h := &hops[id.ttl-1]
+				h.IP, h.RTTMs, h.MPLS = a.ip.String(), a.rttMs, a.mpls
+				if a.ip.Equal(t.dst) {
+					last = min(last, id.ttl)
+				}
+			}
+		}
+	}
+	hops = hops[:last]
+	for i := range hops {
+		hops[i].TTL = i + 1
+	}
+	return hops, nil
+}
+
+// traceMDA probes all TTLs in rounds, each sending every TTL the fresh
+// flows its stopping rule still asks for, until no undiscovered branch is
+// likely left anywhere. The path ends at the first TTL where every flow
+// reached the destination.
+func (t *tracer) traceMDA(ctx context.Context) ([]Hop, error) {
+	branches := make([][]Hop, t.opts.MaxHops)
+	sent := make([]int, t.opts.MaxHops)
+	for ctx.Err() == nil {
+		var ids []probeID
+		for i, bs := range branches {
+			if len(bs) >= len(mdaProbes)-1 {
+				continue
+			}
+			want := mdaProbes[len(bs)]
+			for f := sent[i]; f < want; f++ {
+				ids = append(ids, probeID{i + 1, f, 0})
+			}
+			sent[i] = max(sent[i], want)
+		}
+		if len(ids) == 0 {
+			break
+		}
+		got, err := t.probeAll(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			a, ok := got[id]
+			if !ok {
+				continue
+			}
+			bs := branches[id.ttl-1]
+			j := slices.IndexFunc(bs, func(b Hop) bool { return b.IP == a.ip.String() })
+			if j < 0 {
+				bs = append(bs, Hop{TTL: id.ttl, IP: a.ip.String(), RTTMs: a.rttMs, MPLS: a.mpls})
+				j = len(bs) - 1
+			}
+			bs[j].Flows = append(bs[j].Flows, id.flow)
+			bs[j].RTTMs = min(bs[j].RTTMs, a.rttMs)
+			branches[id.ttl-1] = bs
+		}
+	}
+
+	var hops []Hop
+	for i, bs := range branches {
+		hop := Hop{TTL: i + 1}
+		if len(bs) > 0 {
+			slices.SortFunc(bs, func(a, b Hop) int { return a.Flows[0] - b.Flows[0] })
+			hop.IP, hop.RTTMs, hop.MPLS = bs[0].IP, bs[0].RTTMs, bs[0].MPLS
+		}
+		if len(bs) > 1 {
+			hop.Branches = bs
+		}
+		hops = append(hops, hop)
+		if len(bs) == 1 && bs[0].IP == t.dst.String() {
+			break
+		}
+	}
+	return hops, nil
+}
+
+type reply struct {
+	ip    net.IP
+	rttMs float64
+	mpls  []Label
+}
+
+// probe sends ids at once (as fast as the rate limit allows) and collects
+// replies until all have answered or HopTimeout has passed since the last
+// send. A single read loop demultiplexes replies by probe ID; it also runs
+// while the rate limit holds sends back, so replies are timed as they
+// arrive rather than when the last probe is out.
+func (t *tracer) probe(ctx context.Context, ids []probeID) (map[probeID]reply, error) {
+	pctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sent := make(map[probeID]time.Time, len(ids))
+	got := make(map[probeID]reply, len(ids))
+	record := func(a answer) {
+		if start, ok := sent[a.id]; ok {
+			if _, dup := got[a.id]; !dup {
+				got[a.id] = reply{a.from, float64(a.at.Sub(start).Microseconds()) / 1000, a.mpls}
+			}
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case a := <-t.oob:
+				record(a)
+			default:
+				return
+			}
+		}
+	}
+	// collect reads replies until deadline, or until every probe has
+	// been sent and answered. It fails only if the socket does.
+	collect := func(deadline time.Time) error {
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		for drain(); len(got) < len(ids) && time.Now().Before(deadline); drain() {
+			t.conn.SetReadDeadline(deadline)
+			n, peer, err := t.read()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue // timeout, or woken by an out-of-band answer
+			}
+			if err != nil {
+				return err
+			}
+			at := time.Now()
+			msg, err := icmp.ParseMessage(t.fam.proto, t.buf[:n])
+			if err != nil {
+				continue
+			}
+			if id, ok := t.p.match(msg); ok {
+				record(answer{id, peer, at, mplsLabels(msg)})
+			}
+		}
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := collect(t.lim.reserve()); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		sent[id] = time.Now()
+		if err := t.p.send(pctx, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := collect(time.Now().Add(t.opts.HopTimeout)); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
+
+// probeAll is probe for ids that may share flows: probers that can't have
+// two probes of a flow in flight get them one batch at a time. For TCP
+// outside classic mode that is one TTL per batch, each waiting out the
+// hop timeout of its silent hops: a flow is its source port, and the
+// kernel connects a 4-tuple once at a time, so there is no second probe
+// of it to tell apart by TTL or sequence number.
+func (t *tracer) probeAll(ctx context.Context, ids []probeID) (map[probeID]reply, error) {
+	if !t.p.exclusive() {
+		return t.probe(ctx, ids)
+	}
+	all := make(map[probeID]reply, len(ids))
+	for len(ids) > 0 && ctx.Err() == nil {
+		var batch, rest []probeID
+		flows := map[int]bool{}
+		for _, id := range ids {
+			if flows[id.flow] {
+				rest = append(rest, id)
+				continue
+			}
+			flows[id.flow] = true
+			batch = append(batch, id)
+		}
+		got, err := t.probe(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for id, r := range got {
+			all[id] = r
+		}
+		ids = rest
+	}
+	return all, nil
+}
+
+func (t *tracer) newProber() (prober, error) {
+	switch t.opts.Proto {
+	case "icmp":
+		if t.dgram != nil {
+			// The kernel sets the echo ID to the socket's port.
+			return &icmpProber{t: t, id: t.conn.LocalAddr().(*net.UDPAddr).Port}, nil
+		}
+		// Concurrent traces in one process need distinct echo IDs.
+		return &icmpProber{t: t, id: (os.Getpid() + int(traceSeq.Add(1))) & 0xffff}, nil
+	case "udp":
+		network := "udp4"
+		if t.fam.v6() {
+			network = "udp6"
+		}
+		uc, err := net.ListenPacket(network, "")
+		if err != nil {
+			return nil, err
+		}
+		return &udpProber{t: t, conn: uc}, nil
+	case "tcp":
+		if !tcpProbes {
+			return nil, fmt.Errorf("TCP probes are not supported on this platform")
+		}
+		// Fixed source ports per flow, from a random base so concurrent
+		// traces don't collide.
+		base := 0
+		if t.opts.Mode != "classic" {
+			base = 20000 + rand.Intn(30000)
+		}
+		return &tcpProber{t: t, base: base, sent: map[int]tcpProbe{}, inflight: map[int]chan struct{}{}}, nil
+	}
+	return nil, fmt.Errorf("unknown probe protocol %q (want icmp, udp or tcp)", t.opts.Proto)
+}
+
+// icmpProber sends echo requests on the tracer's socket itself. The sequence
+// number carries the probe ID; outside classic mode two payload bytes
+// compensate for it so the checksum, which ECMP routers hash ICMP flows
+// on, only depends on the flow.
+type icmpProber struct {
+	t  *tracer
+	id int
+}
+
+func (p *icmpProber) send(_ context.Context, id probeID) error {
+	var err error
+	if p.t.fam.v6() {
+		err = p.t.conn.IPv6PacketConn().SetHopLimit(id.ttl)
+	} else {
+		err = p.t.conn.IPv4PacketConn().SetTTL(id.ttl)
+	}
+	if err != nil {
+		return err
+	}
+	return p.t.write(p.packet(id))
+}
+
+// packet is the echo request for id. The kernel fills in the ICMPv6
+// checksum (it covers a pseudo-header we don't know the source of).
+func (p *icmpProber) packet(id probeID) []byte {
+	seq := id.ttl<<8 | id.flow<<1 | id.attempt
+	data := append([]byte{0, 0}, "intspeed-aspath"...)
+	if p.t.opts.Mode != "classic" {
+		// seq + comp ≡ flow key in ones' complement arithmetic.
+		c := uint32(0x4000+id.flow) + uint32(^uint16(seq))
+		binary.BigEndian.PutUint16(data, uint16(c&0xffff+c>>16))
+	}
+	wm := icmp.Message{
+		Type: p.t.fam.echo,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: data},
+	}
+	wb, _ := wm.Marshal(nil)
+	return wb
+}
+
+func (p *icmpProber) match(msg *icmp.Message) (probeID, bool) {
+	var ident, seq int
+	if e, ok := msg.Body.(*icmp.Echo); ok {
+		if msg.Type != p.t.fam.echoReply {
+			return probeID{}, false
+		}
+		ident, seq = e.ID, e.Seq
+	} else {
+		proto, _, l4 := quoted(msg)
+		if proto != p.t.fam.proto || len(l4) < 8 || l4[0] != byte(icmpType(p.t.fam.echo)) {
+			return probeID{}, false
+		}
+		ident, seq = int(binary.BigEndian.Uint16(l4[4:])), int(binary.BigEndian.Uint16(l4[6:]))
+	}
+	return probeID{seq >> 8, seq >> 1 & 0x7f, seq & 1}, ident == p.id
+}
+
+func (p *icmpProber) exclusive() bool { return false }
+
+func (p *icmpProber) close() {}
+
+// udpProber sends datagrams from one source port. Classic mode walks the
+// destination port per probe like traditional traceroute; otherwise the
+// destination port is Port+flow and the probe is told apart by the
+// datagram's length, which routers don't hash on. The destination answers
+// with port unreachable.
+type udpProber struct {
+	t    *tracer
+	conn net.PacketConn
+}
+
+func (p *udpProber) send(_ context.Context, id probeID) error {
+	var err error
+	if p.t.fam.v6() {
+		err = ipv6.NewPacketConn(p.conn).SetHopLimit(id.ttl)
+	} else {
+		err = ipv4.NewPacketConn(p.conn).SetTTL(id.ttl)
+	}
+	if err != nil {
+		return err
+	}
+	port, payload := p.encode(id)
+	_, err = p.conn.WriteTo(make([]byte, payload), &net.UDPAddr{IP: p.t.dst, Port: port})
+	return err
+}
+
+// encode returns the destination port and payload length that carry id.
+func (p *udpProber) encode(id probeID) (port, payload int) {
+	k := id.ttl<<1 | id.attempt
+	if p.t.opts.Mode == "classic" {
+		return p.t.opts.Port + k, 2
+	}
+	return p.t.opts.Port + id.flow, 2 + k
+}
+
+func (p *udpProber) match(msg *icmp.Message) (probeID, bool) {
+	proto, _, l4 := quoted(msg)
+	if proto != protoUDP || len(l4) < 6 {
+		return probeID{}, false
+	}
+	src := int(binary.BigEndian.Uint16(l4))
+	off := int(binary.BigEndian.Uint16(l4[2:])) - p.t.opts.Port
+	if src != p.conn.LocalAddr().(*net.UDPAddr).Port || off < 0 {
+		return probeID{}, false
+	}
+	if p.t.opts.Mode == "classic" {
+		return probeID{off >> 1, 0, off & 1}, true
+	}
+	k := int(binary.BigEndian.Uint16(l4[4:])) - 8 - 2
+	return probeID{k >> 1, off, k & 1}, k >= 0
+}
+
+func (p *udpProber) exclusive() bool { return false }
+
+func (p *udpProber) close() { p.conn.Close() }
+
+// tcpProber starts a real connection per probe, with the TTL set before
+// the SYN leaves. The flow is the source port: a fresh one per probe in
+// classic mode, base+flow otherwise, so at most one probe per flow is in
+// flight and the port alone tells them apart. Routers quote the SYN in
+// their time-exceeded messages; the destination answers with SYN-ACK or
+// RST, which surfaces as the dial's outcome.
+type tcpProber struct {
+	t    *tracer
+	base int
+	// sent maps a source port to the latest probe sent from it, until the
+	// probe is answered or its dial is over.
+	sent     map[int]tcpProbe
+	inflight map[int]chan struct{} // flow → closed when its last dial is over
+}
+
+type tcpProbe struct {
+	id   probeID
+	done chan struct{} // closed when the probe's dial is over
+}
+
+func (p *tcpProber) send(ctx context.Context, id probeID) error {
+	p.prune()
+	bind := 0
+	done := make(chan struct{})
+	if p.base > 0 {
+		bind = p.base + id.flow
+		// The flow's previous socket must be gone before its 4-tuple can
+		// connect again; its context is already over, so this is brief.
+		if prev, ok := p.inflight[id.flow]; ok {
+			<-prev
+		}
+		p.inflight[id.flow] = done
+	}
+	srcPort := make(chan int, 1)
+	d := net.Dialer{Control: tcpProbeControl(id.ttl, p.t.fam.v6(), bind, srcPort)}
+	network := "tcp4"
+	if p.t.fam.v6() {
+		network = "tcp6"
+	}
+	addr := net.JoinHostPort(p.t.dst.String(), fmt.Sprint(p.t.opts.Port))
+	go func() {
+		defer close(done)
+		c, err := d.DialContext(ctx, network, addr)
+		if err == nil {
+			// Reset rather than close, so the port has no TIME_WAIT
+			// left when the next probe of this flow binds it.
+			c.(*net.TCPConn).SetLinger(0)
+			c.Close()
+		}
+		if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+			select {
+			case p.t.oob <- answer{id: id, from: p.t.dst, at: time.Now()}:
+				p.t.conn.SetReadDeadline(time.Now())
+			default:
+			}
+		}
+		select {
+		case srcPort <- 0: // unblock send if Control never ran
+		default:
+		}
+	}()
+	if port := <-srcPort; port != 0 {
+		p.sent[port] = tcpProbe{id, done}
+	}
+	// Otherwise the dial failed before sending anything; the probe just
+	// goes unanswered.
+	return nil
+}
+
+// prune forgets the probes whose dials are over: nothing can answer them
+// any more, and their ports may be reused.
+func (p *tcpProber) prune() {
+	for port, s := range p.sent {
+		select {
+		case <-s.done:
+			delete(p.sent, port)
+		default:
+		}
+	}
+}
+
+func (p *tcpProber) match(msg *icmp.Message) (probeID, bool) {
+	proto, dst, l4 := quoted(msg)
+	if proto != protoTCP || !dst.Equal(p.t.dst) || len(l4) < 4 || int(binary.BigEndian.Uint16(l4[2:])) != p.t.opts.Port {
+		return probeID{}, false
+	}
+	port := int(binary.BigEndian.Uint16(l4))
+	s, ok := p.sent[port]
+	if ok {
+		delete(p.sent, port)
+	}
+	return s.id, ok
+}
+
+func (p *tcpProber) exclusive() bool { return p.base > 0 }
+
+func (p *tcpProber) close() {
+	for _, done := range p.inflight {
+		<-done
+	}
+}
+
+// quoted returns the transport protocol, destination and transport header
+// of the packet an ICMP error quotes (its IP header plus at least 8 bytes).
+// IPv6 quotes are assumed to carry no extension headers, which our probes
+// never have.
+func quoted(msg *icmp.Message) (proto int, dst net.IP, l4 []byte) {
+	var data []byte
+	switch body := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.DstUnreach:
+		data = body.Data
+	case *icmp.PacketTooBig:
+		data = body.Data
+	default:
+		return 0, nil, nil
+	}
+	if len(data) < 20 {
+		return 0, nil, nil
+	}
+	if data[0]>>4 == 6 {
+		if len(data) < 40 {
+			return 0, nil, nil
+		}
+		return int(data[6]), net.IP(data[24:40]), data[40:]
+	}
+	hl := int(data[0]&0x0f) * 4
+	if len(data) < hl {
+		return 0, nil, nil
+	}
+	return int(data[9]), net.IP(data[16:20]), data[hl:]
+}
+
+// mplsLabels returns the label stack in an ICMP error's RFC 4884
+// extension objects, which routers inside an MPLS tunnel append when a
+// labelled packet expires (RFC 4950).
+func mplsLabels(msg *icmp.Message) []Label {
+	var exts []icmp.Extension
+	switch body := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		exts = body.Extensions
+	case *icmp.DstUnreach:
+		exts = body.Extensions
+	}
+	var labels []Label
+	for _, ext := range exts {
+		if stack, ok := ext.(*icmp.MPLSLabelStack); ok {
+			for _, l := range stack.Labels {
+				labels = append(labels, Label{Label: l.Label, TC: l.TC, TTL: l.TTL})
+			}
+		}
+	}
+	return labels
+}
+
+func icmpType(t icmp.Type) int {
+	switch t := t.(type) {
+	case ipv4.ICMPType:
+		return int(t)
+	case ipv6.ICMPType:
+		return int(t)
+	}
+	return -1
+}
+
+// icmpFamily holds what differs between ICMPv4 and ICMPv6 probing.
+type icmpFamily struct {
+	network, listen string
+	proto           int // IANA protocol number, for icmp.ParseMessage
+	echo, echoReply icmp.Type
+}
+
+var (
+	icmp4 = icmpFamily{"ip4:icmp", "0.0.0.0", 1, ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply}
+	icmp6 = icmpFamily{"ip6:ipv6-icmp", "::", 58, ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply}
+)
+
+func (f icmpFamily) v6() bool { return f.proto == icmp6.proto }
+
+// A limiter spaces probe sends evenly, across every tracer sharing it. A
+// nil limiter doesn't limit.
+type limiter struct {
+	mu    sync.Mutex
+	every time.Duration
+	next  time.Time
+}
+
+func newLimiter(rate int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{every: time.Second / time.Duration(rate)}
+}
+
+// reserve books the next send slot and returns when it is; a nil
+// limiter's is now.
+func (l *limiter) reserve() time.Time {
+	at := time.Now()
+	if l == nil {
+		return at
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next.After(at) {
+		at = l.next
+	}
+	l.next = at.Add(l.every)
+	return at
+}
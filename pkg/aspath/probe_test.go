@@ -0,0 +1,300 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// timeExceeded synthesizes a router's ICMPv4 time-exceeded message quoting
+// an IPv4 packet of proto to dst with transport header l4.
+func timeExceeded(proto int, dst net.IP, l4 []byte) *icmp.Message {
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	ip[8] = 1
+	ip[9] = byte(proto)
+	copy(ip[12:], net.IPv4(198, 51, 100, 1).To4())
+	copy(ip[16:], dst.To4())
+	return &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: append(ip, l4...)}}
+}
+
+// udpHeader is the header of a UDP datagram from src to dst carrying
+// payload bytes.
+func udpHeader(src, dst, payload int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b, uint16(src))
+	binary.BigEndian.PutUint16(b[2:], uint16(dst))
+	binary.BigEndian.PutUint16(b[4:], uint16(8+payload))
+	return b
+}
+
+// tcpHeader is the first 8 bytes of a TCP header from src to dst.
+func tcpHeader(src, dst int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b, uint16(src))
+	binary.BigEndian.PutUint16(b[2:], uint16(dst))
+	return b
+}
+
+func TestTCPProberMatch(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	done := make(chan struct{})
+	p := &tcpProber{
+		t:    &tracer{dst: dst, fam: icmp4, opts: Options{Port: 443}},
+		sent: map[int]tcpProbe{40001: {probeID{ttl: 5, flow: 1}, done}},
+	}
+
+	// Another trace's SYN from the same port, or to another port.
+	if _, ok := p.match(timeExceeded(protoTCP, net.ParseIP("192.0.2.2"), tcpHeader(40001, 443))); ok {
+		t.Error("matched a quote to another destination")
+	}
+	if _, ok := p.match(timeExceeded(protoTCP, dst, tcpHeader(40001, 80))); ok {
+		t.Error("matched a quote to another port")
+	}
+	if _, ok := p.match(timeExceeded(protoUDP, dst, tcpHeader(40001, 443))); ok {
+		t.Error("matched a UDP quote")
+	}
+
+	id, ok := p.match(timeExceeded(protoTCP, dst, tcpHeader(40001, 443)))
+	if !ok || id != (probeID{ttl: 5, flow: 1}) {
+		t.Fatalf("match = %v, %v; want ttl 5 flow 1", id, ok)
+	}
+	if _, ok := p.match(timeExceeded(protoTCP, dst, tcpHeader(40001, 443))); ok {
+		t.Error("a port still matched after its probe was answered")
+	}
+
+	// A probe whose dial is over is forgotten.
+	p.sent[40002] = tcpProbe{probeID{ttl: 6}, done}
+	close(done)
+	p.prune()
+	if len(p.sent) != 0 {
+		t.Errorf("sent = %v after every dial ended", p.sent)
+	}
+}
+
+func TestICMPProber(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	for _, mode := range []string{"classic", "paris", "mda"} {
+		t.Run(mode, func(t *testing.T) {
+			p := &icmpProber{t: &tracer{dst: dst, fam: icmp4, opts: Options{Mode: mode}}, id: 0x1234}
+			other := &icmpProber{t: p.t, id: 0x4321}
+			sums := map[uint16]int{} // checksum → flow
+			for flow := range maxFlows {
+				var sum uint16
+				for ttl := 1; ttl <= maxTTL; ttl++ {
+					for attempt := range 2 {
+						id := probeID{ttl, flow, attempt}
+						wb := p.packet(id)
+						if mode != "classic" {
+							// ECMP routers hash the checksum: it must not
+							// change with the TTL or attempt.
+							s := binary.BigEndian.Uint16(wb[2:])
+							if ttl == 1 && attempt == 0 {
+								sum = s
+								if f, dup := sums[s]; dup {
+									t.Fatalf("flows %d and %d share checksum %#04x", f, flow, s)
+								}
+								sums[s] = flow
+							} else if s != sum {
+								t.Fatalf("%+v: checksum %#04x, want the flow's %#04x", id, s, sum)
+							}
+						}
+
+						// A router quotes the IP header and the echo
+						// header; the destination echoes it back.
+						if got, ok := p.match(timeExceeded(icmp4.proto, dst, wb[:8])); !ok || got != id {
+							t.Fatalf("%+v: time exceeded matched %+v, %v", id, got, ok)
+						}
+						msg, err := icmp.ParseMessage(icmp4.proto, wb)
+						if err != nil {
+							t.Fatal(err)
+						}
+						reply := &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: msg.Body}
+						if got, ok := p.match(reply); !ok || got != id {
+							t.Fatalf("%+v: echo reply matched %+v, %v", id, got, ok)
+						}
+						if _, ok := other.match(reply); ok {
+							t.Fatalf("%+v: matched another trace's echo reply", id)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUDPProber(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	src := conn.LocalAddr().(*net.UDPAddr).Port
+
+	for _, mode := range []string{"classic", "paris", "mda"} {
+		t.Run(mode, func(t *testing.T) {
+			p := &udpProber{t: &tracer{dst: dst, fam: icmp4, opts: Options{Mode: mode, Port: 33434}}, conn: conn}
+			for _, id := range []probeID{
+				{1, 0, 0},
+				{1, 0, 1},
+				{maxTTL, 0, 1},
+				{7, 5, 0},
+				{maxTTL, maxFlows - 1, 1},
+			} {
+				if mode == "classic" {
+					id.flow = 0 // classic probes have only the one flow
+				}
+				port, payload := p.encode(id)
+				if mode != "classic" && port != 33434+id.flow {
+					t.Errorf("%+v: sent to port %d, want one per flow", id, port)
+				}
+				got, ok := p.match(timeExceeded(protoUDP, dst, udpHeader(src, port, payload)))
+				if !ok || got != id {
+					t.Errorf("%+v: matched %+v, %v", id, got, ok)
+				}
+				if _, ok := p.match(timeExceeded(protoUDP, dst, udpHeader(src+1, port, payload))); ok {
+					t.Errorf("%+v: matched a datagram from another source port", id)
+				}
+			}
+			if _, ok := p.match(timeExceeded(protoUDP, dst, udpHeader(src, 33433, 2))); ok {
+				t.Error("matched a datagram below the base port")
+			}
+		})
+	}
+}
+
+func TestMPLSLabels(t *testing.T) {
+	// A time exceeded from inside an MPLS tunnel, as on the wire: the
+	// 128-byte quote RFC 4884 pads it to, then an extension structure with
+	// one MPLS label stack object (RFC 4950) of two entries, top first.
+	quote := make([]byte, 128)
+	copy(quote, timeExceeded(protoUDP, net.ParseIP("192.0.2.1"), udpHeader(40000, 33434, 2)).Body.(*icmp.TimeExceeded).Data)
+	entry := func(label, tc int, bottom bool, ttl int) []byte {
+		v := uint32(label)<<12 | uint32(tc)<<9 | uint32(ttl)
+		if bottom {
+			v |= 1 << 8
+		}
+		return binary.BigEndian.AppendUint32(nil, v)
+	}
+	b := []byte{11, 0, 0, 0, 0, byte(len(quote) / 4), 0, 0}
+	b = append(b, quote...)
+	b = append(b, 0x20, 0, 0, 0) // extension header: version 2
+	b = append(b, 0, 12, 1, 1)   // object: 12 bytes, class 1 (MPLS), c-type 1
+	b = append(b, entry(24001, 0, false, 1)...)
+	b = append(b, entry(1048575, 5, true, 255)...)
+
+	msg, err := icmp.ParseMessage(icmp4.proto, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := mplsLabels(msg)
+	want := []Label{{Label: 24001, TC: 0, TTL: 1}, {Label: 1048575, TC: 5, TTL: 255}}
+	if !slices.Equal(got, want) {
+		t.Errorf("labels %+v, want %+v", got, want)
+	}
+
+	// The quote must still be matched past the extension.
+	if proto, _, l4 := quoted(msg); proto != protoUDP || binary.BigEndian.Uint16(l4[2:]) != 33434 {
+		t.Errorf("quoted protocol %d, header %x", proto, l4[:8])
+	}
+	if got := mplsLabels(timeExceeded(protoUDP, net.ParseIP("192.0.2.1"), udpHeader(40000, 33434, 2))); got != nil {
+		t.Errorf("labels %+v from a message without extensions", got)
+	}
+}
+
+func TestProbeReadError(t *testing.T) {
+	conn, dgram, err := listenICMP(icmp4, "icmp")
+	if err != nil {
+		t.Skip("no ICMP socket:", err)
+	}
+	udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tr := &tracer{
+		opts:  Options{Mode: "paris", Port: 33434, HopTimeout: 200 * time.Millisecond},
+		fam:   icmp4,
+		dst:   net.ParseIP("127.0.0.1"),
+		conn:  conn,
+		dgram: dgram,
+		oob:   make(chan answer, 1),
+		buf:   make([]byte, 1500),
+	}
+	tr.p = &udpProber{t: tr, conn: udp}
+	ids := []probeID{{1, 0, 0}, {2, 0, 0}}
+
+	// Timeouts only end the wait: whatever answered is the result.
+	if _, err := tr.probe(context.Background(), ids); err != nil {
+		t.Fatalf("probe with nothing to fail: %v", err)
+	}
+	// A socket that fails fails the probe, at once.
+	conn.Close()
+	start := time.Now()
+	if _, err := tr.probe(context.Background(), ids); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("probe on a closed socket: %v, want %v", err, net.ErrClosed)
+	}
+	if d := time.Since(start); d >= tr.opts.HopTimeout {
+		t.Errorf("probe on a closed socket took %v, waited out the timeout", d)
+	}
+}
+
+// answeringProber answers every probe at once, out of band, and records
+// the order they were sent in.
+type answeringProber struct {
+	t    *tracer
+	excl bool
+	sent []probeID
+}
+
+func (p *answeringProber) send(_ context.Context, id probeID) error {
+	p.sent = append(p.sent, id)
+	p.t.oob <- answer{id: id, from: p.t.dst, at: time.Now()}
+	return nil
+}
+
+func (p *answeringProber) match(*icmp.Message) (probeID, bool) { return probeID{}, false }
+func (p *answeringProber) exclusive() bool                     { return p.excl }
+func (p *answeringProber) close()                              {}
+
+func TestProbeAllExclusive(t *testing.T) {
+	// Two flows, three TTLs each, listed flow by flow.
+	var ids []probeID
+	for flow := range 2 {
+		for ttl := 1; ttl <= 3; ttl++ {
+			ids = append(ids, probeID{ttl, flow, 0})
+		}
+	}
+	for _, tt := range []struct {
+		exclusive bool
+		want      []probeID
+	}{
+		{false, ids},
+		// One probe per flow in flight: a batch per TTL.
+		{true, []probeID{{1, 0, 0}, {1, 1, 0}, {2, 0, 0}, {2, 1, 0}, {3, 0, 0}, {3, 1, 0}}},
+	} {
+		tr := &tracer{dst: net.ParseIP("192.0.2.1"), oob: make(chan answer, len(ids)), opts: Options{HopTimeout: time.Second}}
+		p := &answeringProber{t: tr, excl: tt.exclusive}
+		tr.p = p
+		got, err := tr.probeAll(context.Background(), ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(ids) {
+			t.Errorf("exclusive %v: %d of %d probes answered", tt.exclusive, len(got), len(ids))
+		}
+		if !slices.Equal(p.sent, tt.want) {
+			t.Errorf("exclusive %v: sent %v, want %v", tt.exclusive, p.sent, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// A Resolver maps public addresses to their origin ASN and ASNs to short
+// names. Both return "" when they don't know.
+type Resolver interface {
+	ASN(ctx context.Context, ip net.IP) string
+	ASName(ctx context.Context, asn string) string
+}
+
+// Cymru resolves through Team Cymru's IP-to-ASN DNS zones: one TXT query
+// per address and per ASN.
+var Cymru Resolver = cymru{}
+
+// A failingResolver is a Resolver that can also tell a failed lookup (a
+// timeout, SERVFAIL) apart from a definite "don't know", so a Cache only
+// remembers the latter.
+type failingResolver interface {
+	asn(ctx context.Context, ip net.IP) (string, error)
+	asName(ctx context.Context, asn string) (string, error)
+}
+
+// dnsAnswer turns a lookup error into a result: nil when the name or
+// record just doesn't exist, which is an answer too.
+func dnsAnswer(err error) error {
+	var de *net.DNSError
+	if errors.As(err, &de) && de.IsNotFound {
+		return nil
+	}
+	return err
+}
+
+type cymru struct{}
+
+func (c cymru) ASN(ctx context.Context, ip net.IP) string {
+	asn, _ := c.asn(ctx, ip)
+	return asn
+}
+
+func (c cymru) ASName(ctx context.Context, asn string) string {
+	name, _ := c.asName(ctx, asn)
+	return name
+}
+
+func (cymru) asn(ctx context.Context, ip net.IP) (string, error) {
+	txts, err := net.DefaultResolver.LookupTXT(ctx, originQuery(ip))
+	if err != nil || len(txts) == 0 {
+		return "", dnsAnswer(err)
+	}
+	fields := strings.Fields(strings.Split(txts[0], "|")[0])
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// originQuery is the name asn looks up for ip: its octets reversed under
+// origin.asn.cymru.com for IPv4, its nibbles reversed under
+// origin6.asn.cymru.com for IPv6.
+func originQuery(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	}
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", ip[i]&0x0f, ip[i]>>4)
+	}
+	return b.String() + "origin6.asn.cymru.com"
+}
+
+func (cymru) asName(ctx context.Context, asn string) (string, error) {
+	txts, err := net.DefaultResolver.LookupTXT(ctx, "AS"+asn+".asn.cymru.com")
+	if err != nil || len(txts) == 0 {
+		return "", dnsAnswer(err)
+	}
+	parts := strings.Split(txts[0], "|")
+	if len(parts) < 5 {
+		return "", nil
+	}
+	return shortName(parts[4]), nil
+}
+
+// shortName turns a registry AS description such as "COGENT-174, US" into
+// a short lowercase name (<=10 chars): "cogent-174".
+func shortName(desc string) string {
+	name := strings.TrimSpace(desc)
+	if i := strings.LastIndex(name, ","); i > 0 {
+		name = name[:i]
+	}
+	name = strings.ToLower(name)
+	if i := strings.Index(name, " "); i >= 3 {
+		name = name[:i]
+	}
+	name = strings.Trim(name, "-_.")
+	if len(name) > 10 {
+		name = name[:10]
+	}
+	return name
+}
@@ -0,0 +1,21 @@
+//go:build !js
+
+package aspath
+
+import (
+	"net"
+	"testing"
+)
+
+func TestOriginQuery(t *testing.T) {
+	for _, tt := range []struct{ ip, want string }{
+		{"192.0.2.1", "1.2.0.192.origin.asn.cymru.com"},
+		{"::ffff:198.51.100.7", "7.100.51.198.origin.asn.cymru.com"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com"},
+		{"2a00:1450:4001:82b::200e", "e.0.0.2.0.0.0.0.0.0.0.0.0.0.0.0.b.2.8.0.1.0.0.4.0.5.4.1.0.0.a.2.origin6.asn.cymru.com"},
+	} {
+		if got := originQuery(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("originQuery(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
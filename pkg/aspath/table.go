@@ -0,0 +1,386 @@
+//go:build !js
+
+package aspath
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Table is an offline Resolver: a longest-prefix-match trie per address
+// family, loaded from an iptoasn.com TSV (ip2asn-combined.tsv and the
+// per-family variants) or an MRT TABLE_DUMP_V2 RIB dump (RouteViews, RIPE
+// RIS). Lookups never leave the process. MRT dumps carry no AS names.
+type Table struct {
+	v4, v6 trie
+	names  map[uint32]string
+}
+
+// LoadTable reads path as an ip2asn TSV or an MRT RIB dump, either of
+// them optionally gzip- or bzip2-compressed; the format is sniffed.
+func LoadTable(path string) (*Table, error) {
+	f, br, err := openData(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var t *Table
+	// An MRT record header is a timestamp then type 13 (TABLE_DUMP_V2);
+	// a TSV starts with an address.
+	if head, _ := br.Peek(6); len(head) == 6 && head[4] == 0 && head[5] == mrtTableDumpV2 {
+		t, err = ReadMRT(br)
+	} else {
+		t, err = ReadIP2ASN(br)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// openData opens a dataset file, transparently decompressing gzip and
+// bzip2. The caller closes f when done with r.
+func openData(path string) (f *os.File, r *bufio.Reader, err error) {
+	if f, err = os.Open(path); err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReaderSize(f, 1<<16)
+	var dr io.Reader = br
+	if magic, _ := br.Peek(3); len(magic) == 3 {
+		switch {
+		case magic[0] == 0x1f && magic[1] == 0x8b:
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				f.Close()
+				return nil, nil, fmt.Errorf("%s: %w", path, err)
+			}
+			dr = zr
+		case string(magic) == "BZh":
+			dr = bzip2.NewReader(br)
+		}
+	}
+	return f, bufio.NewReaderSize(dr, 1<<16), nil
+}
+
+// ReadIP2ASN parses iptoasn.com's tab-separated "range_start range_end
+// AS_number country_code AS_description" lines. Addresses may be dotted,
+// IPv6 or (ip2asn-v4-u32) plain integers; AS 0 marks unrouted ranges.
+func ReadIP2ASN(r io.Reader) (*Table, error) {
+	t := newTable()
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		f := strings.Split(text, "\t")
+		if len(f) < 3 {
+			return nil, fmt.Errorf("line %d: want at least 3 tab-separated fields", line)
+		}
+		start, w1, ok1 := parseAddr(f[0])
+		end, w2, ok2 := parseAddr(f[1])
+		asn, err := strconv.ParseUint(f[2], 10, 32)
+		if !ok1 || !ok2 || w1 != w2 || err != nil || end.less(start) {
+			return nil, fmt.Errorf("line %d: bad range %q", line, text)
+		}
+		if asn == 0 {
+			continue
+		}
+		if len(f) >= 5 && t.names[uint32(asn)] == "" {
+			t.names[uint32(asn)] = shortName(f[4])
+		}
+		tr := &t.v4
+		if w1 == 128 {
+			tr = &t.v6
+		}
+		// Split the range into the CIDR blocks that tile it.
+		for {
+			k := min(start.trailingZeros(), w1)
+			for k > 0 && end.less(start.orOnes(k)) {
+				k--
+			}
+			tr.insert(start, w1, w1-k, uint32(asn))
+			last := start.orOnes(k)
+			if last == end {
+				break
+			}
+			start = last.inc()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+const (
+	mrtTableDumpV2  = 13
+	mrtRIBIPv4      = 2
+	mrtRIBIPv6      = 4
+	bgpAttrASPath   = 2
+	bgpAttrExtended = 0x10
+	// maxMRTRecord bounds a record's declared length, far above any real
+	// RIB record, so a corrupt header can't make us allocate gigabytes.
+	maxMRTRecord = 1 << 20
+)
+
+// ReadMRT parses an MRT TABLE_DUMP_V2 RIB dump (RFC 6396), taking each
+// prefix's origin from the AS_PATH of its first RIB entry. Other record
+// types, including the peer index table, are skipped.
+func ReadMRT(r io.Reader) (*Table, error) {
+	t := newTable()
+	var hdr [12]byte
+	var body []byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+			return t, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("mrt: %w", err)
+		}
+		typ, sub := binary.BigEndian.Uint16(hdr[4:]), binary.BigEndian.Uint16(hdr[6:])
+		n := binary.BigEndian.Uint32(hdr[8:])
+		if n > maxMRTRecord {
+			return nil, fmt.Errorf("mrt: record too large (%d bytes)", n)
+		}
+		if cap(body) < int(n) {
+			body = make([]byte, n)
+		}
+		body = body[:n]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("mrt: %w", err)
+		}
+		if typ != mrtTableDumpV2 || (sub != mrtRIBIPv4 && sub != mrtRIBIPv6) {
+			continue
+		}
+		width, tr := 32, &t.v4
+		if sub == mrtRIBIPv6 {
+			width, tr = 128, &t.v6
+		}
+		prefix, bitLen, asn, ok := parseRIB(body, width)
+		if !ok {
+			return nil, fmt.Errorf("mrt: malformed RIB record")
+		}
+		if asn != 0 {
+			tr.insert(prefix, width, bitLen, asn)
+		}
+	}
+}
+
+// parseRIB decodes a RIB_IPV4/IPV6_UNICAST body: sequence number, prefix,
+// then entries whose attributes hold the AS_PATH (4-byte ASNs in
+// TABLE_DUMP_V2). The origin is the path's last ASN.
+func parseRIB(b []byte, width int) (prefix u128, bitLen int, asn uint32, ok bool) {
+	if len(b) < 5 {
+		return
+	}
+	bitLen = int(b[4])
+	n := (bitLen + 7) / 8
+	if bitLen > width || len(b) < 5+n+2 {
+		return
+	}
+	var full [16]byte
+	copy(full[:], b[5:5+n])
+	prefix = u128FromBytes(full[:width/8])
+	b = b[5+n:]
+	if binary.BigEndian.Uint16(b) == 0 {
+		return prefix, bitLen, 0, true
+	}
+	b = b[2:]
+	if len(b) < 8 {
+		return
+	}
+	attrs := b[8:]
+	if al := int(binary.BigEndian.Uint16(b[6:])); al <= len(attrs) {
+		attrs = attrs[:al]
+	} else {
+		return
+	}
+	for len(attrs) >= 3 {
+		flags, typ := attrs[0], attrs[1]
+		l, off := int(attrs[2]), 3
+		if flags&bgpAttrExtended != 0 {
+			if len(attrs) < 4 {
+				return
+			}
+			l, off = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		}
+		if len(attrs) < off+l {
+			return
+		}
+		if typ == bgpAttrASPath {
+			for seg := attrs[off : off+l]; len(seg) >= 2; {
+				count := int(seg[1])
+				if len(seg) < 2+4*count {
+					return
+				}
+				if count > 0 {
+					asn = binary.BigEndian.Uint32(seg[2+4*(count-1):])
+				}
+				seg = seg[2+4*count:]
+			}
+			return prefix, bitLen, asn, true
+		}
+		attrs = attrs[off+l:]
+	}
+	return prefix, bitLen, 0, true
+}
+
+func newTable() *Table {
+	return &Table{names: map[uint32]string{}}
+}
+
+// ASN returns the origin of the longest prefix covering ip.
+func (t *Table) ASN(_ context.Context, ip net.IP) string {
+	var asn uint32
+	if v4 := ip.To4(); v4 != nil {
+		asn = t.v4.lookup(u128FromBytes(v4), 32)
+	} else if len(ip) == net.IPv6len {
+		asn = t.v6.lookup(u128FromBytes(ip), 128)
+	}
+	if asn == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(asn), 10)
+}
+
+func (t *Table) ASName(_ context.Context, asn string) string {
+	n, err := strconv.ParseUint(asn, 10, 32)
+	if err != nil {
+		return ""
+	}
+	return t.names[uint32(n)]
+}
+
+// Prefixes is the number of prefixes loaded (IPv4, IPv6).
+func (t *Table) Prefixes() (v4, v6 int) {
+	return t.v4.prefixes, t.v6.prefixes
+}
+
+// trie is a binary trie over address bits, stored as a node slice with
+// index links to keep a full routing table's worth of nodes compact.
+type trie struct {
+	nodes    []trieNode
+	prefixes int
+}
+
+type trieNode struct {
+	child [2]int32 // 0 = none; the root is never a child
+	asn   uint32   // origin of the prefix ending here, 0 = none
+}
+
+func (tr *trie) insert(addr u128, width, bitLen int, asn uint32) {
+	if tr.nodes == nil {
+		tr.nodes = make([]trieNode, 1, 1024)
+	}
+	n := int32(0)
+	for i := 0; i < bitLen; i++ {
+		b := addr.bit(width, i)
+		if tr.nodes[n].child[b] == 0 {
+			tr.nodes = append(tr.nodes, trieNode{})
+			tr.nodes[n].child[b] = int32(len(tr.nodes) - 1)
+		}
+		n = tr.nodes[n].child[b]
+	}
+	if tr.nodes[n].asn == 0 {
+		tr.prefixes++
+		tr.nodes[n].asn = asn
+	}
+}
+
+func (tr *trie) lookup(addr u128, width int) uint32 {
+	if tr.nodes == nil {
+		return 0
+	}
+	var best uint32
+	n := int32(0)
+	for i := 0; ; i++ {
+		if a := tr.nodes[n].asn; a != 0 {
+			best = a
+		}
+		if i == width {
+			return best
+		}
+		if n = tr.nodes[n].child[addr.bit(width, i)]; n == 0 {
+			return best
+		}
+	}
+}
+
+// u128 holds an IPv4 (in lo's low 32 bits) or IPv6 address as an integer.
+type u128 struct{ hi, lo uint64 }
+
+func u128FromBytes(b []byte) u128 {
+	if len(b) == 4 {
+		return u128{lo: uint64(binary.BigEndian.Uint32(b))}
+	}
+	return u128{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}
+}
+
+// parseAddr reads a dotted IPv4, IPv6 or integer IPv4 address and its
+// width in bits.
+func parseAddr(s string) (u128, int, bool) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return u128{lo: n}, 32, true
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return u128{}, 0, false
+	}
+	if v4 := ip.To4(); v4 != nil && !strings.Contains(s, ":") {
+		return u128FromBytes(v4), 32, true
+	}
+	return u128FromBytes(ip), 128, true
+}
+
+// bit is the i-th most significant of width bits.
+func (a u128) bit(width, i int) int {
+	pos := width - 1 - i
+	if pos >= 64 {
+		return int(a.hi>>(pos-64)) & 1
+	}
+	return int(a.lo>>pos) & 1
+}
+
+func (a u128) less(b u128) bool {
+	return a.hi < b.hi || a.hi == b.hi && a.lo < b.lo
+}
+
+func (a u128) trailingZeros() int {
+	if a.lo != 0 {
+		return bits.TrailingZeros64(a.lo)
+	}
+	return 64 + bits.TrailingZeros64(a.hi)
+}
+
+// orOnes sets the low k bits.
+func (a u128) orOnes(k int) u128 {
+	switch {
+	case k == 0:
+	case k < 64:
+		a.lo |= 1<<k - 1
+	default:
+		a.lo = ^uint64(0)
+		if k > 64 {
+			a.hi |= 1<<(k-64) - 1
+		}
+	}
+	return a
+}
+
+func (a u128) inc() u128 {
+	a.lo++
+	if a.lo == 0 {
+		a.hi++
+	}
+	return a
+}
@@ -0,0 +1,170 @@
+//go:build !js
+
+package aspath
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		v4, v6 int
+		// ASN each address maps to; "" for unrouted.
+		lookups map[string]string
+		names   map[string]string
+	}{
+		{
+			name: "ip2asn",
+			path: "testdata/ip2asn.tsv",
+			// 1.0.0.0/24, 1.0.4.0/23 + 1.0.6.0/24, 10.0.0.3/32 + 10.0.0.4/30
+			// + 10.0.0.8/31, 10.0.1.0/24; 2001:db8::/47 + 2001:db8:2::/48.
+			v4: 7, v6: 2,
+			lookups: map[string]string{
+				"0.1.2.3":   "", // AS 0 rows are unrouted
+				"1.0.0.1":   "13335",
+				"1.0.2.1":   "",
+				"1.0.4.0":   "38803", // 1.0.4.0-1.0.6.255 isn't one CIDR block
+				"1.0.5.255": "38803",
+				"1.0.6.128": "38803",
+				"1.0.7.0":   "",
+				"10.0.0.2":  "",
+				"10.0.0.3":  "64496",
+				"10.0.0.6":  "64496",
+				"10.0.0.9":  "64496",
+				"10.0.0.10": "",
+				"10.0.1.77": "64499", // integer addresses (ip2asn-v4-u32)
+
+				"2001:db8::1":                             "64497",
+				"2001:db8:1:abcd::1":                      "64497",
+				"2001:db8:2:ffff:ffff:ffff:ffff:ffff":     "64497",
+				"2001:db8:3::1":                           "",
+				"::ffff:1.0.0.1":                          "13335", // IPv4-mapped is IPv4
+				"2001:db9::1":                             "",
+				"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff": "",
+			},
+			names: map[string]string{
+				"13335": "cloudflare",
+				"38803": "wpl-as-ap",
+				"64497": "v6-test",
+				"0":     "",
+			},
+		},
+		{
+			name: "mrt",
+			path: "testdata/rib.mrt",
+			// The /24 without RIB entries has no origin and isn't loaded.
+			v4: 4, v6: 2,
+			lookups: map[string]string{
+				"10.200.0.1":   "64501", // origin is the AS_PATH's last ASN
+				"10.1.200.1":   "64502", // /16 over /8; extended-length AS_PATH
+				"10.1.2.3":     "64502", // falls back past the entry-less /24
+				"192.0.2.200":  "64503",
+				"192.0.2.1":    "",
+				"198.51.100.7": "64504", // last ASN of a trailing AS_SET
+				"11.0.0.1":     "",
+
+				"2001:db8:ffff::1": "64510",
+				"2001:db8:1:2::1":  "64511",
+				"2001:db9::1":      "",
+			},
+			names: map[string]string{"64501": ""}, // MRT carries no names
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := LoadTable(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTable(t, table, tt.v4, tt.v6, tt.lookups, tt.names)
+
+			// The same data gzipped is sniffed and read alike.
+			gz := filepath.Join(t.TempDir(), filepath.Base(tt.path)+".gz")
+			data, err := os.ReadFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Create(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := gzip.NewWriter(f)
+			zw.Write(data)
+			zw.Close()
+			f.Close()
+			if table, err = LoadTable(gz); err != nil {
+				t.Fatal(err)
+			}
+			checkTable(t, table, tt.v4, tt.v6, tt.lookups, tt.names)
+		})
+	}
+}
+
+func checkTable(t *testing.T, table *Table, v4, v6 int, lookups, names map[string]string) {
+	t.Helper()
+	if got4, got6 := table.Prefixes(); got4 != v4 || got6 != v6 {
+		t.Errorf("loaded %d IPv4 + %d IPv6 prefixes, want %d + %d", got4, got6, v4, v6)
+	}
+	ctx := context.Background()
+	for addr, want := range lookups {
+		if got := table.ASN(ctx, net.ParseIP(addr)); got != want {
+			t.Errorf("ASN(%s) = %q, want %q", addr, got, want)
+		}
+	}
+	for asn, want := range names {
+		if got := table.ASName(ctx, asn); got != want {
+			t.Errorf("ASName(%s) = %q, want %q", asn, got, want)
+		}
+	}
+}
+
+func TestReadIP2ASNErrors(t *testing.T) {
+	for _, text := range []string{
+		"1.0.0.0\t1.0.0.255\n",                 // no ASN
+		"1.0.0.255\t1.0.0.0\t13335\n",          // end before start
+		"1.0.0.0\t2001:db8::\t13335\n",         // mixed families
+		"1.0.0.0\tnot-an-address\t13335\n",     // bad address
+		"1.0.0.0\t1.0.0.255\tAS13335\tUS\tX\n", // ASN with prefix
+	} {
+		if _, err := ReadIP2ASN(strings.NewReader(text)); err == nil {
+			t.Errorf("ReadIP2ASN(%q): want an error", text)
+		}
+	}
+}
+
+func TestReadMRTErrors(t *testing.T) {
+	// Common header: timestamp, type, subtype, length.
+	header := func(typ, sub uint16, n uint32) []byte {
+		h := make([]byte, 12)
+		binary.BigEndian.PutUint16(h[4:], typ)
+		binary.BigEndian.PutUint16(h[6:], sub)
+		binary.BigEndian.PutUint32(h[8:], n)
+		return h
+	}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"too large", header(mrtTableDumpV2, mrtRIBIPv4, maxMRTRecord+1), "mrt: record too large"},
+		{"huge", header(mrtTableDumpV2, mrtRIBIPv4, 0xffffffff), "mrt: record too large"},
+		{"truncated header", []byte{0, 0, 0, 0, 0, 13}, "mrt: unexpected EOF"},
+		{"truncated body", append(header(mrtTableDumpV2, mrtRIBIPv4, 100), 1, 2, 3), "mrt: unexpected EOF"},
+		{"malformed RIB", append(header(mrtTableDumpV2, mrtRIBIPv4, 5), 0, 0, 0, 1, 33), "mrt: malformed RIB record"},
+	} {
+		_, err := ReadMRT(bytes.NewReader(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
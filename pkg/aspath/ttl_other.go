@@ -0,0 +1,17 @@
+//go:build !unix && !js
+
+package aspath
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Setting the TTL before connect needs unix socket options.
+const tcpProbes = false
+
+func tcpProbeControl(int, bool, int, chan<- int) func(string, string, syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("TCP probes are not supported on this platform")
+	}
+}
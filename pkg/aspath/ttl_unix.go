@@ -0,0 +1,55 @@
+//go:build unix
+
+package aspath
+
+import (
+	"syscall"
+)
+
+const tcpProbes = true
+
+// tcpProbeControl returns a net.Dialer Control hook that gives the SYN the
+// probe's TTL (hop limit) and binds the socket to port (0 = any) before
+// connect, so the local port, which identifies the probe in quoted
+// headers, is known up front. The bound port goes to srcPort.
+func tcpProbeControl(ttl int, v6 bool, port int, srcPort chan<- int) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			var sa syscall.Sockaddr = &syscall.SockaddrInet4{Port: port}
+			if v6 {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+				sa = &syscall.SockaddrInet6{Port: port}
+			} else {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+			}
+			if serr != nil {
+				return
+			}
+			if port != 0 {
+				// Reuse a flow's port while its previous probe's socket
+				// is still being torn down.
+				if serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); serr != nil {
+					return
+				}
+			}
+			if serr = syscall.Bind(int(fd), sa); serr != nil {
+				return
+			}
+			var name syscall.Sockaddr
+			if name, serr = syscall.Getsockname(int(fd)); serr != nil {
+				return
+			}
+			switch a := name.(type) {
+			case *syscall.SockaddrInet4:
+				srcPort <- a.Port
+			case *syscall.SockaddrInet6:
+				srcPort <- a.Port
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
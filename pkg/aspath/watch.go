@@ -0,0 +1,169 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// HopStats is one TTL's mtr-style record over repeated probe cycles. The
+// embedded Hop is the interface that answered last, enriched; its RTTMs
+// is unused.
+type HopStats struct {
+	Hop
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	LossPercent float64 `json:"loss_percent"`
+	LastMs      float64 `json:"last_ms"`
+	AvgMs       float64 `json:"avg_ms"`
+	BestMs      float64 `json:"best_ms"`
+	WorstMs     float64 `json:"worst_ms"`
+	StdDevMs    float64 `json:"stddev_ms"`
+
+	m2 float64 // running sum of squared deviations (Welford)
+}
+
+func (s *HopStats) add(rtt float64) {
+	s.Received++
+	s.LastMs = rtt
+	if s.Received == 1 || rtt < s.BestMs {
+		s.BestMs = rtt
+	}
+	s.WorstMs = max(s.WorstMs, rtt)
+	d := rtt - s.AvgMs
+	s.AvgMs += d / float64(s.Received)
+	s.m2 += d * (rtt - s.AvgMs)
+	if s.Received > 1 {
+		s.StdDevMs = math.Sqrt(s.m2 / float64(s.Received-1))
+	}
+}
+
+// Watch probes every hop toward host once per interval, like mtr, for the
+// given number of cycles (0 = until ctx is done), calling onCycle (if
+// non-nil) with the statistics so far after each cycle. The slice passed
+// to onCycle is reused; copy what must outlive the call. Hops past the
+// destination are dropped once it answers. ctx ending is not an error:
+// the statistics gathered until then are returned.
+func Watch(ctx context.Context, host string, opts Options, cycles int, interval time.Duration, onCycle func([]HopStats)) ([]HopStats, error) {
+	opts.defaults()
+	switch opts.Mode {
+	case "classic", "paris":
+	default:
+		return nil, fmt.Errorf("trace mode %q can't be watched (want classic or paris)", opts.Mode)
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	dst, err := resolve(host, opts.Family)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTracer(opts, dst, newLimiter(opts.Rate))
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+	return watch(ctx, dst, opts, cycles, interval, onCycle, t.probeAll)
+}
+
+// watch is Watch's cycle loop, sending each cycle's probes through probe.
+func watch(ctx context.Context, dst net.IP, opts Options, cycles int, interval time.Duration, onCycle func([]HopStats), probe func(context.Context, []probeID) (map[probeID]reply, error)) ([]HopStats, error) {
+	stats := make([]HopStats, opts.MaxHops)
+	for i := range stats {
+		stats[i].TTL = i + 1
+	}
+
+	// Each address is enriched once, in the background so slow lookups
+	// don't stretch a cycle; hops show its names from the cycle after the
+	// lookup finishes.
+	var (
+		mu    sync.Mutex
+		known = map[string]*Hop{} // nil while the lookup runs
+		wg    sync.WaitGroup
+	)
+	defer wg.Wait()
+	lookup := func(ttl int, ip string) Hop {
+		mu.Lock()
+		defer mu.Unlock()
+		h, ok := known[ip]
+		if !ok {
+			known[ip] = nil
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hops := []Hop{{IP: ip}}
+				enrich(ctx, hops, opts)
+				mu.Lock()
+				known[ip] = &hops[0]
+				mu.Unlock()
+			}()
+		}
+		if h == nil {
+			return Hop{TTL: ttl, IP: ip}
+		}
+		hop := *h
+		hop.TTL = ttl
+		return hop
+	}
+	for cycle := 0; cycles == 0 || cycle < cycles; cycle++ {
+		if ctx.Err() != nil {
+			break
+		}
+		start := time.Now()
+		// Alternate the attempt bit so stragglers from the previous cycle
+		// don't count toward this one.
+		ids := make([]probeID, len(stats))
+		for i := range stats {
+			ids[i] = probeID{i + 1, 0, cycle & 1}
+		}
+		got, err := probe(ctx, ids)
+		if ctx.Err() != nil {
+			break // a cut-short cycle would show as loss
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		last := len(stats)
+		for i := range stats {
+			s := &stats[i]
+			s.Sent++
+			a, ok := got[ids[i]]
+			if ok {
+				s.add(a.rttMs)
+				s.Hop = lookup(s.TTL, a.ip.String())
+				s.MPLS = a.mpls
+				if a.ip.Equal(dst) {
+					last = min(last, i+1)
+				}
+			}
+			s.LossPercent = float64(s.Sent-s.Received) / float64(s.Sent) * 100
+		}
+		stats = stats[:last]
+		if onCycle != nil {
+			onCycle(stats)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(start.Add(interval))):
+		}
+	}
+
+	// Lookups still running finish (or give up once ctx is done) and name
+	// their hops in the result.
+	wg.Wait()
+	for i := range stats {
+		if s := &stats[i]; s.IP != "" {
+			mpls := s.MPLS
+			s.Hop = lookup(s.TTL, s.IP)
+			s.MPLS = mpls
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,150 @@
+//go:build !js
+
+package aspath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchOptions resolves hop names from a warm cache and ASNs from an empty
+// offline table, so watches don't touch the network.
+func watchOptions(t *testing.T, ips ...string) Options {
+	t.Helper()
+	c, err := OpenCache(filepath.Join(t.TempDir(), "lookups.json"), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range ips {
+		c.file.Entries["ptr:"+ip] = cacheEntry{"host-" + ip + ".example", time.Now()}
+	}
+	opts := Options{MaxHops: 5, Resolver: newTable(), Cache: c}
+	opts.defaults()
+	return opts
+}
+
+// answerAll answers every probe up to the destination at ttl dst, hop i
+// from 192.0.2.i after i ms.
+func answerAll(dst int) map[probeID]reply {
+	got := map[probeID]reply{}
+	for ttl := 1; ttl <= dst; ttl++ {
+		for attempt := range 2 {
+			got[probeID{ttl, 0, attempt}] = reply{ip: net.ParseIP(fmt.Sprintf("192.0.2.%d", ttl)), rttMs: float64(ttl)}
+		}
+	}
+	return got
+}
+
+func TestWatchCancelled(t *testing.T) {
+	// ctx ends while the third cycle's probes go out: the two complete
+	// cycles are the result, not an error.
+	opts := watchOptions(t, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cycles := 0
+	stats, err := watch(ctx, net.ParseIP("192.0.2.3"), opts, 0, time.Millisecond, nil, func(ctx context.Context, ids []probeID) (map[probeID]reply, error) {
+		if cycles++; cycles == 3 {
+			cancel()
+			return nil, ctx.Err()
+		}
+		return answerAll(3), nil
+	})
+	if err != nil {
+		t.Fatalf("watch cut short by its context failed: %v", err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("%d hops, want the 3 up to the destination", len(stats))
+	}
+	for i, s := range stats {
+		if s.Sent != 2 || s.Received != 2 || s.LossPercent != 0 {
+			t.Errorf("hop %d: sent %d, received %d, %.0f%% loss; want 2, 2, 0", i+1, s.Sent, s.Received, s.LossPercent)
+		}
+		if want := float64(i + 1); s.AvgMs != want {
+			t.Errorf("hop %d: avg %.1fms, want %.1f", i+1, s.AvgMs, want)
+		}
+	}
+}
+
+func TestWatchProbeError(t *testing.T) {
+	opts := watchOptions(t)
+	boom := errors.New("boom")
+	_, err := watch(context.Background(), net.ParseIP("192.0.2.3"), opts, 3, time.Millisecond, nil, func(context.Context, []probeID) (map[probeID]reply, error) {
+		return nil, boom
+	})
+	if err != boom {
+		t.Errorf("err = %v, want the probe's", err)
+	}
+}
+
+// slowResolver maps every address to AS64500 once release is closed,
+// counting lookups per address.
+type slowResolver struct {
+	release chan struct{}
+	mu      sync.Mutex
+	lookups map[string]int
+}
+
+func (r *slowResolver) ASN(ctx context.Context, ip net.IP) string {
+	r.mu.Lock()
+	r.lookups[ip.String()]++
+	r.mu.Unlock()
+	select {
+	case <-r.release:
+		return "64500"
+	case <-ctx.Done():
+		return ""
+	}
+}
+
+func (r *slowResolver) ASName(context.Context, string) string { return "EXAMPLE" }
+
+func TestWatchEnrichesInBackground(t *testing.T) {
+	// Lookups only finish after the second cycle: the cycles must not wait
+	// for them, and the result must still carry their answers.
+	opts := watchOptions(t, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	res := &slowResolver{release: make(chan struct{}), lookups: map[string]int{}}
+	opts.Resolver = res
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mpls := []Label{{Label: 24001, TTL: 1}}
+	cycles := 0
+	stats, err := watch(ctx, net.ParseIP("192.0.2.3"), opts, 4, time.Millisecond, func(stats []HopStats) {
+		if cycles++; cycles == 2 {
+			for _, s := range stats {
+				if s.ASN != "" {
+					t.Errorf("hop %d named before its lookup finished", s.TTL)
+				}
+			}
+			close(res.release)
+		}
+	}, func(_ context.Context, ids []probeID) (map[probeID]reply, error) {
+		got := answerAll(3)
+		r := got[ids[1]]
+		r.mpls = mpls
+		got[ids[1]] = r
+		return got, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycles != 4 || ctx.Err() != nil {
+		t.Fatalf("%d cycles before the deadline, want 4", cycles)
+	}
+	for _, s := range stats {
+		if s.ASN != "64500" || s.ASName != "EXAMPLE" || s.PTR != "host-"+s.IP+".example" {
+			t.Errorf("hop %d: %+v, want it enriched", s.TTL, s.Hop)
+		}
+		if n := res.lookups[s.IP]; n != 1 {
+			t.Errorf("%s looked up %d times, want once", s.IP, n)
+		}
+	}
+	if len(stats[1].MPLS) != 1 || stats[1].MPLS[0] != mpls[0] {
+		t.Errorf("hop 2 MPLS = %v, want %v", stats[1].MPLS, mpls)
+	}
+}
@@ -14,9 +14,9 @@ var rawEndpoints []byte
 
 type Endpoint struct {
 	Name string `json:"name"`
-	Kind string `json:"kind"` // ookla | file | librespeed | probe
+	Kind string `json:"kind"` // ookla | file | librespeed | probe | iperf3 | reflector, or any kind registered with engine.Register
 	ID   string `json:"id,omitempty"`
-	Host string `json:"host,omitempty"` // ookla host:port
+	Host string `json:"host,omitempty"` // ookla host:port, iperf3/reflector host[:port]
 	URL  string `json:"url,omitempty"`  // file/librespeed/probe base URL
 	// Browser means the server sends permissive CORS headers, so a
 	// cross-origin web page can read (and therefore time) its responses.
@@ -26,6 +26,10 @@ type Endpoint struct {
 	ASN               string `json:"asn,omitempty"`
 	ASName            string `json:"as_name,omitempty"` // short (<=10 chars)
 	Note              string `json:"note,omitempty"`
+	// Added marks endpoints the user supplied (Registry.Add) rather than
+	// the embedded registry; they count as asked for even where their
+	// kind is otherwise opt-in.
+	Added bool `json:"added,omitempty"`
 }
 
 // Raw returns the embedded registry JSON verbatim (for serving to the
@@ -60,6 +64,17 @@ func (r *Registry) ForLocation(name string) *LocationEndpoints {
 	return nil
 }
 
+// Add appends ep, marked Added, to the named location, creating the
+// location if the registry doesn't have it.
+func (r *Registry) Add(location string, ep Endpoint) {
+	ep.Added = true
+	if l := r.ForLocation(location); l != nil {
+		l.Endpoints = append(l.Endpoints, ep)
+		return
+	}
+	r.Locations = append(r.Locations, LocationEndpoints{Name: location, Endpoints: []Endpoint{ep}})
+}
+
 // Browser returns only the endpoints usable directly from a web page.
 func (l *LocationEndpoints) Browser() []Endpoint {
 	var out []Endpoint
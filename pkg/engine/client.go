@@ -0,0 +1,54 @@
+//go:build !js
+
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// One client per address family: the transport pools idle connections by
+// host only, so a shared one would hand a v4 connection to a v6 test.
+var clients = map[string]*http.Client{
+	FamilyAny: {Transport: newTransport(FamilyAny)},
+	FamilyV4:  {Transport: newTransport(FamilyV4)},
+	FamilyV6:  {Transport: newTransport(FamilyV6)},
+}
+
+// pingClients pool the connections of loaded-latency pings apart from the
+// transfers', so neither takes the other's warm connection.
+var pingClients = map[string]*http.Client{
+	FamilyAny: {Transport: newTransport(FamilyAny)},
+	FamilyV4:  {Transport: newTransport(FamilyV4)},
+	FamilyV6:  {Transport: newTransport(FamilyV6)},
+}
+
+func clientFor(ctx context.Context) *http.Client {
+	pool := clients
+	if isPing(ctx) {
+		pool = pingClients
+	}
+	if c, ok := pool[familyOf(ctx)]; ok {
+		return c
+	}
+	return pool[FamilyAny]
+}
+
+// newTransport disables HTTP/2 so each concurrent stream gets its own TCP
+// connection; multiplexed streams would share one congestion window and
+// defeat the point of measuring with several flows. Its dialer only uses
+// addresses of the given family.
+func newTransport(family string) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	t.MaxIdleConnsPerHost = 16
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, familyNetwork(family, network), addr)
+	}
+	return t
+}
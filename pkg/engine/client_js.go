@@ -0,0 +1,15 @@
+//go:build js
+
+package engine
+
+import (
+	"context"
+	"net/http"
+)
+
+// client goes through fetch; the browser decides connection reuse and HTTP
+// version, so parallel streams are best-effort there. It also picks the
+// address family, which is why Options.Family is ignored in the browser.
+var client = &http.Client{}
+
+func clientFor(context.Context) *http.Client { return client }
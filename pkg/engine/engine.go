@@ -6,16 +6,19 @@ package engine
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"math"
 	"math/rand"
-	"net/http"
 	"runtime"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/rotkonetworks/intspeed/pkg/aspath"
 	"github.com/rotkonetworks/intspeed/pkg/endpoints"
 )
 
@@ -31,6 +34,31 @@ type Options struct {
 	OpTimeout     time.Duration // timeout per single measurement
 	Locations     []string      // subset filter; empty = all
 	MaxEndpoints  int           // cap endpoints latency-tested per city; 0 = all
+	Streams       int           // parallel connections per throughput test
+	// TestDuration switches throughput tests from a fixed byte count to
+	// streaming until the deadline; the leading RampUp window (slow start,
+	// TLS) is excluded from the reported rate. 0 = fixed-size mode.
+	TestDuration time.Duration
+	RampUp       time.Duration // default TestDuration/4
+	// LoadedLatency keeps pinging the transfer's endpoint while download
+	// and upload run, to expose queueing delay (bufferbloat) on the path.
+	LoadedLatency bool
+	// UDPRate makes session kinds that support it (iperf3) test over UDP
+	// at this total rate in bits/s, reporting loss and jitter. 0 = TCP.
+	UDPRate int64
+	// LossProbes sends this many paced UDP probes to the location's
+	// nearest endpoint that supports it (reflectors) after the throughput
+	// tests, for loss, reordering and one-way jitter. 0 = off.
+	LossProbes int
+	// Kinds restricts testing to endpoints of these kinds. Session kinds
+	// (iperf3), whose servers take one client at a time and whose latency
+	// is a bare handshake, are opt-in: only tested when listed here or on
+	// endpoints the user added. Empty = every other kind.
+	Kinds []string
+	// Family restricts every connection to one address family (FamilyV4,
+	// FamilyV6), or with FamilyBoth tests each location over both so their
+	// routes can be compared. Ignored in the browser, which picks itself.
+	Family string
 }
 
 func (o *Options) defaults() {
@@ -46,15 +74,25 @@ func (o *Options) defaults() {
 	if o.OpTimeout == 0 {
 		o.OpTimeout = 45 * time.Second
 	}
+	if o.Streams <= 0 {
+		o.Streams = 1
+	}
+	if o.TestDuration > 0 && o.RampUp == 0 {
+		o.RampUp = o.TestDuration / 4
+	}
+	if browserMode {
+		o.Family = FamilyAny
+	}
 }
 
 type Progress struct {
-	Type     string  `json:"type"` // location_start | latency | download | upload | location_done | sweep_done
+	Type     string  `json:"type"` // location_start | latency | download_sample | download | upload_sample | upload | loss | location_done | sweep_done
 	Location string  `json:"location"`
 	Endpoint string  `json:"endpoint,omitempty"`
-	Value    float64 `json:"value,omitempty"` // ms or Mbps depending on Type
+	Value    float64 `json:"value,omitempty"` // ms, Mbps or loss % depending on Type
 	Index    int     `json:"index"`
 	Total    int     `json:"total"`
+	Family   string  `json:"family,omitempty"` // v4 | v6 when Options.Family is set
 	Error    string  `json:"error,omitempty"`
 }
 
@@ -62,8 +100,10 @@ type EndpointResult struct {
 	Name      string  `json:"name"`
 	Kind      string  `json:"kind"`
 	LatencyMs float64 `json:"latency_ms"`
+	MedianMs  float64 `json:"median_ms,omitempty"`
 	JitterMs  float64 `json:"jitter_ms"`
-	Error     string  `json:"error,omitempty"`
+	Phases
+	Error string `json:"error,omitempty"`
 }
 
 type LocationResult struct {
@@ -77,9 +117,63 @@ type LocationResult struct {
 	UploadVia    string           `json:"upload_via,omitempty"`
 	Endpoints    []EndpointResult `json:"endpoints"`
 	Error        string           `json:"error,omitempty"`
-}
 
-var client = &http.Client{}
+	// Per-connection rates, set when Streams > 1. Every stream far below
+	// the aggregate means single flows are window-limited on this path
+	// rather than the path itself being throttled.
+	DownloadStreamsMbps []float64 `json:"download_streams_mbps,omitempty"`
+	UploadStreamsMbps   []float64 `json:"upload_streams_mbps,omitempty"`
+
+	// Aggregate bytes moved per SeriesIntervalMs over each whole transfer,
+	// ramp-up included: shaping shows up here as e.g. an initial burst
+	// followed by a flat policer cap. The last entry covers only the
+	// *SeriesTailMs the transfer ran past the last full interval, if set.
+	SeriesIntervalMs     int     `json:"series_interval_ms,omitempty"`
+	DownloadSeries       []int64 `json:"download_series,omitempty"`
+	DownloadSeriesTailMs float64 `json:"download_series_tail_ms,omitempty"`
+	UploadSeries         []int64 `json:"upload_series,omitempty"`
+	UploadSeriesTailMs   float64 `json:"upload_series_tail_ms,omitempty"`
+
+	// Latency under load (LoadedLatency): median ping to the transfer's own
+	// endpoint while it runs, and the median idle ping to that same
+	// endpoint to set it against (LatencyMs is a minimum, and often another
+	// endpoint's). RPM is round trips per minute over all loaded samples;
+	// higher is better.
+	IdleDownloadMs    float64 `json:"idle_download_latency_ms,omitempty"`
+	LoadedDownloadMs  float64 `json:"loaded_download_latency_ms,omitempty"`
+	IdleUploadMs      float64 `json:"idle_upload_latency_ms,omitempty"`
+	LoadedUploadMs    float64 `json:"loaded_upload_latency_ms,omitempty"`
+	ResponsivenessRPM float64 `json:"responsiveness_rpm,omitempty"`
+
+	// Receiver-side loss and jitter of UDP throughput sessions (UDPRate).
+	DownloadUDP *UDPStats `json:"download_udp,omitempty"`
+	UploadUDP   *UDPStats `json:"upload_udp,omitempty"`
+
+	// Paced-probe packet loss (LossProbes) and the endpoint it ran against.
+	Loss    *LossStats `json:"loss,omitempty"`
+	LossVia string     `json:"loss_via,omitempty"`
+
+	// Route to the DownloadVia endpoint's TraceHost, traced after the
+	// measurements by callers that can (the CLI's sweep), and the AS path
+	// it collapses to, to tell throughput changes caused by rerouting apart.
+	TraceHost  string       `json:"trace_host,omitempty"`
+	Hops       []aspath.Hop `json:"hops,omitempty"`
+	ASPath     []aspath.AS  `json:"as_path,omitempty"`
+	TraceError string       `json:"trace_error,omitempty"`
+
+	// Path MTU toward the DownloadVia endpoint and the hop limiting it,
+	// discovered after the measurements by callers that can (the CLI's
+	// sweep): a black hole below the endpoint's packet size stalls TCP
+	// transfers.
+	PMTU      *aspath.PMTU `json:"pmtu,omitempty"`
+	PMTUError string       `json:"pmtu_error,omitempty"`
+
+	// Family is the address family the result was measured over (v4, v6;
+	// empty if unrestricted). With FamilyBoth the result itself is IPv4
+	// and IPv6 holds the same location measured over IPv6.
+	Family string          `json:"family,omitempty"`
+	IPv6   *LocationResult `json:"ipv6,omitempty"`
+}
 
 // Sweep tests every registry location in order, invoking cb (if non-nil)
 // as measurements complete.
@@ -105,7 +199,16 @@ func Sweep(ctx context.Context, reg *endpoints.Registry, opts Options, cb func(P
 			break
 		}
 		emit(Progress{Type: "location_start", Location: loc.Name, Index: i + 1, Total: len(locs)})
-		res := testLocation(ctx, loc, opts, emit)
+		var res LocationResult
+		if opts.Family == FamilyBoth {
+			res = testFamily(ctx, loc, opts, FamilyV4, emit)
+			if ctx.Err() == nil {
+				v6 := testFamily(ctx, loc, opts, FamilyV6, emit)
+				res.IPv6 = &v6
+			}
+		} else {
+			res = testFamily(ctx, loc, opts, opts.Family, emit)
+		}
 		results = append(results, res)
 		emit(Progress{Type: "location_done", Location: loc.Name, Index: i + 1, Total: len(locs), Error: res.Error})
 	}
@@ -113,6 +216,20 @@ func Sweep(ctx context.Context, reg *endpoints.Registry, opts Options, cb func(P
 	return results
 }
 
+// testFamily runs testLocation with every connection restricted to family,
+// tagging the result and its progress events.
+func testFamily(ctx context.Context, loc endpoints.LocationEndpoints, opts Options, family string, emit func(Progress)) LocationResult {
+	if family == FamilyAny {
+		return testLocation(ctx, loc, opts, emit)
+	}
+	res := testLocation(withFamily(ctx, family), loc, opts, func(p Progress) {
+		p.Family = family
+		emit(p)
+	})
+	res.Family = family
+	return res
+}
+
 func testLocation(ctx context.Context, loc endpoints.LocationEndpoints, opts Options, emit func(Progress)) LocationResult {
 	res := LocationResult{Location: loc.Name}
 
@@ -120,6 +237,7 @@ func testLocation(ctx context.Context, loc endpoints.LocationEndpoints, opts Opt
 	if opts.BrowserOnly {
 		eps = loc.Browser()
 	}
+	eps = wanted(eps, opts)
 	if len(eps) == 0 {
 		res.Error = "no usable endpoints"
 		return res
@@ -147,88 +265,222 @@ func testLocation(ctx context.Context, loc endpoints.LocationEndpoints, opts Opt
 	}
 
 	// Latency on every endpoint; the fastest ones win the throughput tests.
-	type measured struct {
-		ep   endpoints.Endpoint
-		base string
-		lat  float64
-	}
 	var ok []measured
 	for _, ep := range eps {
-		lat, jit, base, err := measureLatency(ctx, ep, opts)
-		er := EndpointResult{Name: ep.Name, Kind: ep.Kind, LatencyMs: lat, JitterMs: jit}
+		m, found := measurerFor(ep.Kind)
+		if !found {
+			res.Endpoints = append(res.Endpoints, EndpointResult{Name: ep.Name, Kind: ep.Kind, Error: "unsupported kind " + ep.Kind})
+			continue
+		}
+		lat, med, jit, base, ph, err := measureLatency(ctx, m, ep, opts)
+		er := EndpointResult{Name: ep.Name, Kind: ep.Kind, LatencyMs: lat, MedianMs: med, JitterMs: jit, Phases: ph}
 		if err != nil {
 			er.Error = err.Error()
 			res.Endpoints = append(res.Endpoints, er)
 			continue
 		}
 		res.Endpoints = append(res.Endpoints, er)
-		ok = append(ok, measured{ep, base, lat})
+		ok = append(ok, measured{ep, m, base, lat, med, jit})
 		emit(Progress{Type: "latency", Location: loc.Name, Endpoint: ep.Name, Value: lat})
-		if lat < res.LatencyMs || res.LatencyMs == 0 {
-			res.LatencyMs, res.JitterMs, res.PingVia = lat, jit, ep.Name
-		}
 	}
 	if len(ok) == 0 {
 		res.Error = "all endpoints unreachable"
 		return res
 	}
-
-	// Download from the lowest-latency endpoint.
-	bestDL := ok[0]
-	for _, m := range ok[1:] {
-		if m.lat < bestDL.lat {
-			bestDL = m
+	// A session kind's latency is a bare handshake, not a round trip: it
+	// only stands in for the location's when nothing else answered.
+	var via *measured
+	for i := range ok {
+		c := &ok[i]
+		if via == nil || isSession(via.m) && !isSession(c.m) || isSession(via.m) == isSession(c.m) && c.lat < via.lat {
+			via = c
 		}
 	}
-	if mbps, err := measureDownload(ctx, bestDL.ep, bestDL.base, opts); err == nil {
-		res.DownloadMbps, res.DownloadVia = mbps, bestDL.ep.Name
-		emit(Progress{Type: "download", Location: loc.Name, Endpoint: bestDL.ep.Name, Value: mbps})
-	} else {
+	res.LatencyMs, res.JitterMs, res.PingVia = via.lat, via.jit, via.ep.Name
+
+	// Download from the best download-capable endpoint, moving on while
+	// single-client servers are busy.
+	var (
+		tp     throughput
+		pings  []float64
+		loaded []float64
+	)
+	dl, err := tryEach(ranked(ok, func(c *measured) bool { return canDownload(c.m) }), func(c *measured) (err error) {
+		stopPings := startLoadedPings(ctx, c.m, c.ep, c.base, opts)
+		tp, err = measureDownload(ctx, c.m, c.ep, c.base, opts, sampleEmitter(emit, "download_sample", loc.Name, c.ep.Name))
+		pings = stopPings()
+		return err
+	})
+	if dl != nil && len(pings) > 0 {
+		res.IdleDownloadMs, res.LoadedDownloadMs = dl.med, Median(pings)
+		loaded = append(loaded, pings...)
+	}
+	if dl != nil && err == nil {
+		res.DownloadMbps, res.DownloadVia = tp.mbps, dl.ep.Name
+		res.DownloadSeries, res.DownloadSeriesTailMs = tp.series, float64(tp.tail.Nanoseconds())/1e6
+		res.DownloadUDP = tp.udp
+		res.SeriesIntervalMs = int(sampleInterval / time.Millisecond)
+		if opts.Streams > 1 {
+			res.DownloadStreamsMbps = tp.streams
+		}
+		emit(Progress{Type: "download", Location: loc.Name, Endpoint: dl.ep.Name, Value: tp.mbps})
+	} else if err != nil {
 		res.Error = fmt.Sprintf("download: %v", err)
 	}
 
-	// Upload to the lowest-latency upload-capable endpoint.
-	var bestUL *measured
-	for i := range ok {
-		if ok[i].ep.Upload && (bestUL == nil || ok[i].lat < bestUL.lat) {
-			bestUL = &ok[i]
+	// Upload likewise, to endpoints whose entry allows it.
+	ul, err := tryEach(ranked(ok, func(c *measured) bool { return canUpload(c.m) && c.ep.Upload }), func(c *measured) (err error) {
+		stopPings := startLoadedPings(ctx, c.m, c.ep, c.base, opts)
+		tp, err = measureUpload(ctx, c.m, c.ep, c.base, opts, sampleEmitter(emit, "upload_sample", loc.Name, c.ep.Name))
+		pings = stopPings()
+		return err
+	})
+	if ul != nil && len(pings) > 0 {
+		res.IdleUploadMs, res.LoadedUploadMs = ul.med, Median(pings)
+		loaded = append(loaded, pings...)
+	}
+	if ul != nil && err == nil {
+		res.UploadMbps, res.UploadVia = tp.mbps, ul.ep.Name
+		res.UploadSeries, res.UploadSeriesTailMs = tp.series, float64(tp.tail.Nanoseconds())/1e6
+		res.UploadUDP = tp.udp
+		res.SeriesIntervalMs = int(sampleInterval / time.Millisecond)
+		if opts.Streams > 1 {
+			res.UploadStreamsMbps = tp.streams
 		}
+		emit(Progress{Type: "upload", Location: loc.Name, Endpoint: ul.ep.Name, Value: tp.mbps})
+	} else if err != nil && res.Error == "" {
+		res.Error = fmt.Sprintf("upload: %v", err)
+	}
+	if len(loaded) > 0 {
+		res.ResponsivenessRPM = 60_000 / Median(loaded)
 	}
-	if bestUL != nil {
-		if mbps, err := measureUpload(ctx, bestUL.ep, bestUL.base, opts); err == nil {
-			res.UploadMbps, res.UploadVia = mbps, bestUL.ep.Name
-			emit(Progress{Type: "upload", Location: loc.Name, Endpoint: bestUL.ep.Name, Value: mbps})
+
+	// Packet loss against the lowest-latency endpoint that can measure it.
+	var bestLoss *measured
+	if opts.LossProbes > 0 {
+		for i := range ok {
+			if _, can := ok[i].m.(LossMeasurer); can && (bestLoss == nil || ok[i].lat < bestLoss.lat) {
+				bestLoss = &ok[i]
+			}
+		}
+	}
+	if bestLoss != nil {
+		st, err := measureLoss(ctx, bestLoss.m.(LossMeasurer), bestLoss.ep, bestLoss.base, opts)
+		if err == nil {
+			res.Loss, res.LossVia = st, bestLoss.ep.Name
+			emit(Progress{Type: "loss", Location: loc.Name, Endpoint: bestLoss.ep.Name, Value: st.LossPercent})
 		} else if res.Error == "" {
-			res.Error = fmt.Sprintf("upload: %v", err)
+			res.Error = fmt.Sprintf("loss: %v", err)
 		}
 	}
 	return res
 }
 
+// wanted drops endpoints of kinds outside opts.Kinds and, unless asked for,
+// those of session kinds.
+func wanted(eps []endpoints.Endpoint, opts Options) []endpoints.Endpoint {
+	var out []endpoints.Endpoint
+	for _, ep := range eps {
+		if len(opts.Kinds) > 0 && !containsFold(opts.Kinds, ep.Kind) {
+			continue
+		}
+		if m, found := measurerFor(ep.Kind); found && isSession(m) && len(opts.Kinds) == 0 && !ep.Added {
+			continue
+		}
+		out = append(out, ep)
+	}
+	return out
+}
+
+// measured is an endpoint that answered its latency run.
+type measured struct {
+	ep   endpoints.Endpoint
+	m    Measurer
+	base string
+	lat  float64
+	med  float64
+	jit  float64
+}
+
+// ranked returns the endpoints in ok that pass can, in the order throughput
+// tests try them: session kinds first, as they are only there when asked
+// for, then lowest latency first.
+func ranked(ok []measured, can func(*measured) bool) []*measured {
+	var out []*measured
+	for i := range ok {
+		if can(&ok[i]) {
+			out = append(out, &ok[i])
+		}
+	}
+	slices.SortStableFunc(out, func(a, b *measured) int {
+		if sa, sb := isSession(a.m), isSession(b.m); sa != sb {
+			if sa {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.lat, b.lat)
+	})
+	return out
+}
+
+// tryEach runs f on each candidate in turn until one isn't busy, returning
+// that candidate and f's error; nil if there were none or all were busy.
+func tryEach(cands []*measured, f func(*measured) error) (*measured, error) {
+	var err error
+	for _, c := range cands {
+		if err = f(c); !errors.Is(err, ErrBusy) {
+			return c, err
+		}
+	}
+	return nil, err
+}
+
+// sampleEmitter turns per-interval byte counts into progress events carrying
+// the interval's rate in Mbps.
+func sampleEmitter(emit func(Progress), typ, location, endpoint string) func(int64, time.Duration) {
+	return func(b int64, d time.Duration) {
+		emit(Progress{Type: typ, Location: location, Endpoint: endpoint, Value: mbps(b, d)})
+	}
+}
+
 // measureLatency warms up the connection, then times PingCount small
-// requests. Returns the minimum (closest to pure RTT), jitter as the mean
-// absolute difference of consecutive samples, and the resolved base URL for
-// librespeed endpoints (whose backend path prefix varies).
-func measureLatency(ctx context.Context, ep endpoints.Endpoint, opts Options) (lat, jit float64, base string, err error) {
+// requests. Returns the minimum (closest to pure RTT), the median (what
+// latency under load compares against), jitter as the mean absolute
+// difference of consecutive samples, the resolved base URL for kinds with
+// several candidates (e.g. librespeed backend paths), and the phase
+// breakdown: connection setup from the first ping that opened a connection
+// (normally the warm-up), minimum TTFB from the rest.
+func measureLatency(ctx context.Context, m Measurer, ep endpoints.Endpoint, opts Options) (lat, med, jit float64, base string, ph Phases, err error) {
 	var samples []float64
 	found := false
-	for _, b := range latencyURLs(ep) {
+	for _, b := range m.Bases(ep) {
 		var s []float64
+		p := Phases{Reused: true}
 		fail := false
 		for i := 0; i <= opts.PingCount; i++ { // one extra: warm-up
+			tctx, pt := withPhaseTrace(ctx)
 			start := time.Now()
-			if err = doSmallGet(ctx, ep, pingURL(ep, b), opts.OpTimeout); err != nil {
+			if err = ping(tctx, m, ep, b, opts.OpTimeout); err != nil {
 				fail = true
 				break
 			}
+			elapsed := float64(time.Since(start).Nanoseconds()) / 1e6
+			got, fresh := pt.result()
+			if fresh && p.Reused {
+				p.DNSMs, p.ConnectMs, p.TLSMs, p.Reused = got.DNSMs, got.ConnectMs, got.TLSMs, false
+			}
 			if i > 0 {
-				s = append(s, float64(time.Since(start).Nanoseconds())/1e6)
+				s = append(s, elapsed)
+				if got.TTFBMs > 0 && (p.TTFBMs == 0 || got.TTFBMs < p.TTFBMs) {
+					p.TTFBMs = got.TTFBMs
+				}
 			}
 		}
 		// Accept a base only on a fully clean run; partial samples from a
 		// flaky base must not leak into the result.
 		if !fail {
-			base, samples, found = b, s, true
+			base, samples, ph, found = b, s, p, true
 			break
 		}
 	}
@@ -236,7 +488,7 @@ func measureLatency(ctx context.Context, ep endpoints.Endpoint, opts Options) (l
 		if err == nil {
 			err = fmt.Errorf("no latency samples")
 		}
-		return 0, 0, "", err
+		return 0, 0, 0, "", Phases{}, err
 	}
 	lat = math.MaxFloat64
 	for _, s := range samples {
@@ -248,137 +500,70 @@ func measureLatency(ctx context.Context, ep endpoints.Endpoint, opts Options) (l
 	if len(samples) > 1 {
 		jit /= float64(len(samples) - 1)
 	}
-	return lat, jit, base, nil
+	return lat, Median(samples), jit, base, ph, nil
 }
 
-// latencyURLs returns candidate base URLs. LibreSpeed installs differ on
-// whether the backend lives at / or /backend, so both are tried.
-func latencyURLs(ep endpoints.Endpoint) []string {
-	switch ep.Kind {
-	case "librespeed":
-		b := strings.TrimSuffix(ep.URL, "/")
-		if strings.HasSuffix(b, "/backend") {
-			return []string{b, strings.TrimSuffix(b, "/backend")}
-		}
-		return []string{b, b + "/backend"}
-	default:
-		return []string{""}
-	}
+// ping makes one round trip through m, bounded by the per-op timeout.
+func ping(ctx context.Context, m Measurer, ep endpoints.Endpoint, base string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return m.Ping(ctx, ep, base)
 }
 
-func pingURL(ep endpoints.Endpoint, base string) string {
-	switch ep.Kind {
-	case "ookla":
-		return withParam("https://"+ep.Host+"/hi", "nocache")
-	case "librespeed":
-		return withParam(base+"/empty.php", "nocache")
-	default: // file
-		return withParam(ep.URL, "nocache")
-	}
-}
+// lossInterval paces loss probes at 50 per second: enough to catch short
+// bursts of loss without being a load test of its own.
+const lossInterval = 20 * time.Millisecond
 
-func doSmallGet(ctx context.Context, ep endpoints.Endpoint, url string, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+func measureLoss(ctx context.Context, l LossMeasurer, ep endpoints.Endpoint, base string, opts Options) (*LossStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout+time.Duration(opts.LossProbes)*lossInterval)
 	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return err
-	}
-	if ep.Kind == "file" && !browserMode {
-		// Range is not a CORS-safelisted header; in the browser we instead
-		// read a few bytes of the full stream and abort via context cancel.
-		req.Header.Set("Range", "bytes=0-0")
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("status %d", resp.StatusCode)
-	}
-	_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
-	return err
+	return l.Loss(ctx, ep, base, opts.LossProbes, lossInterval)
 }
 
-func measureDownload(ctx context.Context, ep endpoints.Endpoint, base string, opts Options) (float64, error) {
-	ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout)
+// streamShare splits a byte budget across streams, rounding up so the total
+// never falls below the requested size.
+func streamShare(total int64, streams int) int64 {
+	return (total + int64(streams) - 1) / int64(streams)
+}
+
+func measureDownload(ctx context.Context, m Measurer, ep endpoints.Endpoint, base string, opts Options, onSample func(int64, time.Duration)) (throughput, error) {
+	if t, ok := m.(Transferer); ok {
+		ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout+max(opts.TestDuration, sessionDuration))
+		defer cancel()
+		return runSession(ctx, t, ep, base, opts, false, onSample)
+	}
+	d := m.(Downloader)
+	ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout+opts.TestDuration)
 	defer cancel()
 
-	var url string
-	switch ep.Kind {
-	case "ookla":
-		url = withParam(fmt.Sprintf("https://%s/download?size=%d", ep.Host, opts.DownloadBytes), "nocache")
-	case "librespeed":
-		mb := (opts.DownloadBytes + 999_999) / 1_000_000
-		url = withParam(fmt.Sprintf("%s/garbage.php?ckSize=%d", base, mb), "nocache")
-	default:
-		url = withParam(ep.URL, "nocache")
-	}
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return 0, err
-	}
-	if ep.Kind == "file" && !browserMode {
-		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", opts.DownloadBytes-1))
-	}
-
-	start := time.Now()
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 400 {
-		return 0, fmt.Errorf("status %d", resp.StatusCode)
-	}
-	// Cap the read in case the server ignores Range and streams the full file.
-	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, opts.DownloadBytes))
-	secs := time.Since(start).Seconds()
-	if n == 0 {
-		if err == nil {
+	size := streamShare(opts.DownloadBytes, opts.Streams)
+	return runStreams(ctx, opts, onSample, func(ctx context.Context, count *atomic.Int64) error {
+		before := count.Load()
+		err := d.Download(ctx, ep, base, size, countingWriter{count})
+		if err == nil && count.Load() == before {
 			err = fmt.Errorf("empty body")
 		}
-		return 0, err
-	}
-	return float64(n) * 8 / secs / 1e6, nil
+		return err
+	})
 }
 
-func measureUpload(ctx context.Context, ep endpoints.Endpoint, base string, opts Options) (float64, error) {
-	ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout)
+func measureUpload(ctx context.Context, m Measurer, ep endpoints.Endpoint, base string, opts Options, onSample func(int64, time.Duration)) (throughput, error) {
+	if t, ok := m.(Transferer); ok {
+		ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout+max(opts.TestDuration, sessionDuration))
+		defer cancel()
+		return runSession(ctx, t, ep, base, opts, true, onSample)
+	}
+	u := m.(Uploader)
+	ctx, cancel := context.WithTimeout(ctx, opts.OpTimeout+opts.TestDuration)
 	defer cancel()
 
-	var url string
-	switch ep.Kind {
-	case "ookla":
-		url = withParam("https://"+ep.Host+"/upload", "nocache")
-	case "librespeed":
-		url = withParam(base+"/empty.php", "nocache")
-	default:
-		return 0, fmt.Errorf("endpoint has no upload")
-	}
-
-	payload := make([]byte, opts.UploadBytes)
+	// One random payload, shared read-only by every stream.
+	payload := make([]byte, streamShare(opts.UploadBytes, opts.Streams))
 	rand.Read(payload)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
-	if err != nil {
-		return 0, err
-	}
-	// text/plain keeps this a CORS "simple request" (no OPTIONS preflight),
-	// which most test servers don't implement.
-	req.Header.Set("Content-Type", "text/plain")
 
-	start := time.Now()
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
-	if resp.StatusCode >= 400 {
-		return 0, fmt.Errorf("status %d", resp.StatusCode)
-	}
-	return float64(opts.UploadBytes) * 8 / time.Since(start).Seconds() / 1e6, nil
+	return runStreams(ctx, opts, onSample, func(ctx context.Context, count *atomic.Int64) error {
+		return u.Upload(ctx, ep, base, countingReader{bytes.NewReader(payload), count}, int64(len(payload)))
+	})
 }
 
 func withParam(url, key string) string {
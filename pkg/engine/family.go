@@ -0,0 +1,40 @@
+package engine
+
+import "context"
+
+// Address families for Options.Family.
+const (
+	FamilyAny  = ""     // whatever the resolver and dialer prefer
+	FamilyV4   = "v4"   // IPv4 only
+	FamilyV6   = "v6"   // IPv6 only
+	FamilyBoth = "both" // each location twice, IPv4 then IPv6
+)
+
+type familyKey struct{}
+
+func withFamily(ctx context.Context, family string) context.Context {
+	return context.WithValue(ctx, familyKey{}, family)
+}
+
+func familyOf(ctx context.Context) string {
+	f, _ := ctx.Value(familyKey{}).(string)
+	return f
+}
+
+// Network narrows a Go network name ("tcp", "udp") to the address family
+// the engine is measuring over in ctx, e.g. "tcp6" for FamilyV6. Measurers
+// that dial their own sockets pass their network through it so that
+// Options.Family applies to them too.
+func Network(ctx context.Context, network string) string {
+	return familyNetwork(familyOf(ctx), network)
+}
+
+func familyNetwork(family, network string) string {
+	switch family {
+	case FamilyV4:
+		return network + "4"
+	case FamilyV6:
+		return network + "6"
+	}
+	return network
+}
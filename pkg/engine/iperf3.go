@@ -0,0 +1,61 @@
+//go:build !js
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/iperf3"
+)
+
+// The iperf3 kind needs raw TCP/UDP sockets, so it only exists in native
+// builds; the browser build never sees such endpoints (Browser is false).
+func init() {
+	Register("iperf3", iperf3Kind{})
+}
+
+// iperf3Kind tests against public iperf3 servers (Host is host[:port]).
+// Download runs the session in reverse mode (server sends).
+type iperf3Kind struct{}
+
+func (iperf3Kind) Bases(endpoints.Endpoint) []string { return []string{""} }
+
+// Ping times a TCP handshake with the control port. The server sees a
+// client that hangs up before sending its cookie and just moves on.
+func (iperf3Kind) Ping(ctx context.Context, ep endpoints.Endpoint, _ string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, Network(ctx, "tcp"), iperf3.HostPort(ep.Host))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (iperf3Kind) Transfer(ctx context.Context, ep endpoints.Endpoint, _ string, t Transfer) (*UDPStats, error) {
+	res, err := iperf3.Run(ctx, iperf3.HostPort(ep.Host), iperf3.Config{
+		Streams:  len(t.Counts),
+		Duration: t.Duration,
+		Reverse:  !t.Upload,
+		UDPRate:  t.UDPRate,
+		Network:  Network(ctx, "tcp"),
+		Counters: t.Counts,
+	})
+	if errors.Is(err, iperf3.ErrBusy) {
+		return nil, ErrBusy
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.UDPRate == 0 {
+		return nil, nil
+	}
+	return &UDPStats{
+		JitterMs:    res.JitterMs,
+		Packets:     res.Packets,
+		Lost:        res.Lost,
+		LossPercent: res.LossPercent(),
+	}, nil
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+)
+
+func init() {
+	Register("ookla", ookla{})
+	Register("librespeed", libreSpeed{})
+	Register("file", file{})
+	Register("probe", probe{})
+}
+
+// ookla speaks the HTTP side of Ookla servers (host:port, /hi, /download,
+// /upload).
+type ookla struct{}
+
+func (ookla) Bases(endpoints.Endpoint) []string { return []string{""} }
+
+func (ookla) Ping(ctx context.Context, ep endpoints.Endpoint, _ string) error {
+	return httpGet(ctx, withParam("https://"+ep.Host+"/hi", "nocache"), 4096, io.Discard)
+}
+
+func (ookla) Download(ctx context.Context, ep endpoints.Endpoint, _ string, size int64, w io.Writer) error {
+	return httpGet(ctx, withParam(fmt.Sprintf("https://%s/download?size=%d", ep.Host, size), "nocache"), size, w)
+}
+
+func (ookla) Upload(ctx context.Context, ep endpoints.Endpoint, _ string, body io.Reader, size int64) error {
+	return httpPost(ctx, withParam("https://"+ep.Host+"/upload", "nocache"), body, size)
+}
+
+// libreSpeed speaks the LibreSpeed backend (empty.php, garbage.php).
+type libreSpeed struct{}
+
+// Bases: LibreSpeed installs differ on whether the backend lives at / or
+// /backend, so both are tried.
+func (libreSpeed) Bases(ep endpoints.Endpoint) []string {
+	b := strings.TrimSuffix(ep.URL, "/")
+	if strings.HasSuffix(b, "/backend") {
+		return []string{b, strings.TrimSuffix(b, "/backend")}
+	}
+	return []string{b, b + "/backend"}
+}
+
+func (libreSpeed) Ping(ctx context.Context, _ endpoints.Endpoint, base string) error {
+	return httpGet(ctx, withParam(base+"/empty.php", "nocache"), 4096, io.Discard)
+}
+
+func (libreSpeed) Download(ctx context.Context, _ endpoints.Endpoint, base string, size int64, w io.Writer) error {
+	mb := (size + 999_999) / 1_000_000
+	return httpGet(ctx, withParam(fmt.Sprintf("%s/garbage.php?ckSize=%d", base, mb), "nocache"), size, w)
+}
+
+func (libreSpeed) Upload(ctx context.Context, _ endpoints.Endpoint, base string, body io.Reader, size int64) error {
+	return httpPost(ctx, withParam(base+"/empty.php", "nocache"), body, size)
+}
+
+// file downloads a large static file; it has no upload.
+type file struct{}
+
+func (file) Bases(endpoints.Endpoint) []string { return []string{""} }
+
+func (file) Ping(ctx context.Context, ep endpoints.Endpoint, _ string) error {
+	return httpRange(ctx, withParam(ep.URL, "nocache"), 1, 4096, io.Discard)
+}
+
+func (file) Download(ctx context.Context, ep endpoints.Endpoint, _ string, size int64, w io.Writer) error {
+	return httpRange(ctx, withParam(ep.URL, "nocache"), size, size, w)
+}
+
+// probe speaks the intspeed probe protocol served by intspeed-server under
+// /probe (see web.ProbeHandler): GET {url}/ping, GET {url}/download?bytes=N
+// and POST {url}/upload.
+type probe struct{}
+
+func (probe) Bases(endpoints.Endpoint) []string { return []string{""} }
+
+func (probe) Ping(ctx context.Context, ep endpoints.Endpoint, _ string) error {
+	return httpGet(ctx, withParam(probeURL(ep, "/ping"), "nocache"), 4096, io.Discard)
+}
+
+func (probe) Download(ctx context.Context, ep endpoints.Endpoint, _ string, size int64, w io.Writer) error {
+	return httpGet(ctx, withParam(fmt.Sprintf("%s?bytes=%d", probeURL(ep, "/download"), size), "nocache"), size, w)
+}
+
+func (probe) Upload(ctx context.Context, ep endpoints.Endpoint, _ string, body io.Reader, size int64) error {
+	return httpPost(ctx, withParam(probeURL(ep, "/upload"), "nocache"), body, size)
+}
+
+func probeURL(ep endpoints.Endpoint, path string) string {
+	return strings.TrimSuffix(ep.URL, "/") + path
+}
+
+// httpGet copies at most limit bytes of url's body to w.
+func httpGet(ctx context.Context, url string, limit int64, w io.Writer) error {
+	return httpRange(ctx, url, 0, limit, w)
+}
+
+// httpRange is httpGet asking for only the first want bytes (0 = all).
+// Range is not a CORS-safelisted header, so in the browser the full body is
+// requested and the read is cut off at limit instead.
+func httpRange(ctx context.Context, url string, want, limit int64, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if want > 0 && !browserMode {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", want-1))
+	}
+	resp, err := clientFor(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	// Cap the read in case the server ignores Range and streams the full file.
+	_, err = io.Copy(w, io.LimitReader(resp.Body, limit))
+	return err
+}
+
+func httpPost(ctx context.Context, url string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	// text/plain keeps this a CORS "simple request" (no OPTIONS preflight),
+	// which most test servers don't implement.
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := clientFor(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
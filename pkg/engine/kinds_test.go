@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/web"
+)
+
+// startProbeServer serves web.ProbeHandler under /probe like
+// intspeed-server --probe, adding every uploaded byte to up. configure, if
+// non-nil, adjusts the server before it starts.
+func startProbeServer(t *testing.T, up *atomic.Int64, configure func(*http.Server)) *httptest.Server {
+	t.Helper()
+	probe := http.StripPrefix("/probe", web.ProbeHandler())
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = io.NopCloser(countingReader{r.Body, up})
+		probe.ServeHTTP(w, r)
+	}))
+	if configure != nil {
+		configure(srv.Config)
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// slowWriter counts what it is given, pausing on every write.
+type slowWriter struct{ n *atomic.Int64 }
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	w.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// slowReader yields size zero bytes a few KB at a time, pausing on every
+// read.
+type slowReader struct{ left int }
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(time.Millisecond)
+	n := min(len(p), r.left, 8<<10)
+	clear(p[:n])
+	r.left -= n
+	return n, nil
+}
+
+func TestProbeKind(t *testing.T) {
+	var up atomic.Int64
+	srv := startProbeServer(t, &up, nil)
+	m, ok := measurerFor("probe")
+	if !ok {
+		t.Fatal("probe kind not registered")
+	}
+	ep := endpoints.Endpoint{Name: "probe", Kind: "probe", URL: srv.URL + "/probe/", Upload: true}
+	ctx := context.Background()
+
+	lat, med, _, base, _, err := measureLatency(ctx, m, ep, Options{PingCount: 3, OpTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat <= 0 || med < lat {
+		t.Errorf("latency %.3fms, median %.3fms", lat, med)
+	}
+
+	var down atomic.Int64
+	if err := m.(Downloader).Download(ctx, ep, base, 3_000_000, countingWriter{&down}); err != nil {
+		t.Fatal(err)
+	}
+	if down.Load() != 3_000_000 {
+		t.Errorf("downloaded %d bytes, want 3000000", down.Load())
+	}
+	if err := m.(Uploader).Upload(ctx, ep, base, bytes.NewReader(make([]byte, 2_000_000)), 2_000_000); err != nil {
+		t.Fatal(err)
+	}
+	if up.Load() != 2_000_000 {
+		t.Errorf("server read %d uploaded bytes, want 2000000", up.Load())
+	}
+}
+
+func TestProbeHandlerRequests(t *testing.T) {
+	srv := startProbeServer(t, new(atomic.Int64), nil)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/probe/ping", http.StatusOK},
+		{"GET", "/probe/download?bytes=1", http.StatusOK},
+		{"GET", fmt.Sprintf("/probe/download?bytes=%d", 1<<30), http.StatusOK},
+		{"GET", fmt.Sprintf("/probe/download?bytes=%d", 1<<30+1), http.StatusBadRequest},
+		{"GET", "/probe/download?bytes=0", http.StatusBadRequest},
+		{"GET", "/probe/download?bytes=-5", http.StatusBadRequest},
+		{"GET", "/probe/download?bytes=lots", http.StatusBadRequest},
+		{"GET", "/probe/download", http.StatusBadRequest},
+		{"GET", "/probe/upload", http.StatusMethodNotAllowed},
+		{"POST", "/probe/upload", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close() // a 1 GiB body is not read, only announced
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+		if resp.Header.Get("Access-Control-Allow-Origin") != "*" || resp.Header.Get("Timing-Allow-Origin") != "*" {
+			t.Errorf("%s %s: not CORS-open", tt.method, tt.path)
+		}
+	}
+}
+
+func TestProbeTransfersOutlastServerTimeouts(t *testing.T) {
+	// Transfers that take longer than the server's read and write
+	// timeouts, as on a slow link, must still complete.
+	var up atomic.Int64
+	srv := startProbeServer(t, &up, func(s *http.Server) {
+		s.ReadTimeout, s.WriteTimeout = 100*time.Millisecond, 100*time.Millisecond
+	})
+	m, _ := measurerFor("probe")
+	ep := endpoints.Endpoint{Name: "probe", Kind: "probe", URL: srv.URL + "/probe"}
+	ctx := context.Background()
+
+	var down atomic.Int64
+	start := time.Now()
+	if err := m.(Downloader).Download(ctx, ep, "", 8_000_000, slowWriter{&down}); err != nil || down.Load() != 8_000_000 {
+		t.Errorf("slow download: %d bytes, %v", down.Load(), err)
+	}
+	if err := m.(Uploader).Upload(ctx, ep, "", &slowReader{1_000_000}, 1_000_000); err != nil || up.Load() != 1_000_000 {
+		t.Errorf("slow upload: %d bytes, %v", up.Load(), err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Errorf("transfers took %v, too fast to outlast the timeouts", time.Since(start))
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+)
+
+// loadedPingGap spaces loaded-latency probes so they sample the queue
+// without adding meaningful load of their own.
+const loadedPingGap = 100 * time.Millisecond
+
+type pingKey struct{}
+
+// withPing marks ctx's HTTP requests as loaded-latency pings, which go
+// through a connection pool of their own.
+func withPing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pingKey{}, true)
+}
+
+func isPing(ctx context.Context) bool {
+	p, _ := ctx.Value(pingKey{}).(bool)
+	return p
+}
+
+// startLoadedPings pings ep back-to-back (spaced by loadedPingGap) until the
+// returned stop func is called, which yields the samples in ms. It is a
+// no-op unless opts.LoadedLatency is set.
+//
+// Pings keep their own connection, pooled apart from the transfer streams'
+// and opened by an unmeasured ping before this returns, i.e. before the
+// transfer starts. So a probe waits in the bottleneck queue, not behind our
+// bytes in a shared TCP send buffer, and no sample pays for a handshake.
+func startLoadedPings(ctx context.Context, m Measurer, ep endpoints.Endpoint, base string, opts Options) func() []float64 {
+	if !opts.LoadedLatency {
+		return func() []float64 { return nil }
+	}
+	ctx, cancel := context.WithCancel(withPing(ctx))
+	ping(ctx, m, ep, base, opts.OpTimeout)
+	done := make(chan []float64, 1)
+	go func() {
+		var samples []float64
+		for ctx.Err() == nil {
+			start := time.Now()
+			// A probe cut off by stop (or that failed) carries no sample.
+			if err := ping(ctx, m, ep, base, opts.OpTimeout); err == nil {
+				samples = append(samples, float64(time.Since(start).Nanoseconds())/1e6)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(loadedPingGap):
+			}
+		}
+		done <- samples
+	}()
+	return func() []float64 {
+		cancel()
+		return <-done
+	}
+}
+
+// Median is the median of xs, or 0 for none.
+func Median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+)
+
+// queueDelay is what a ping waits at the loadedServer while a transfer
+// runs, as it would behind a bloated bottleneck queue.
+const queueDelay = 30 * time.Millisecond
+
+// loadedServer speaks the probe kind, moving bytes slowly enough that a
+// transfer outlasts several loaded pings, and notes which connections
+// carried transfers and which carried pings during one.
+type loadedServer struct {
+	busy  atomic.Int32
+	pings atomic.Int32
+
+	mu          sync.Mutex
+	transfers   map[string]bool // remote addresses
+	loadedPings map[string]bool
+}
+
+func (s *loadedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/ping":
+		s.pings.Add(1)
+		if s.busy.Load() > 0 {
+			s.note(s.loadedPings, r)
+			time.Sleep(queueDelay)
+		}
+	case "/download":
+		s.busy.Add(1)
+		defer s.busy.Add(-1)
+		s.note(s.transfers, r)
+		n, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		w.Header().Set("Content-Length", strconv.Itoa(n))
+		chunk := make([]byte, 16<<10)
+		for n > 0 {
+			k := min(n, len(chunk))
+			if _, err := w.Write(chunk[:k]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			n -= k
+			time.Sleep(5 * time.Millisecond)
+		}
+	case "/upload":
+		s.busy.Add(1)
+		defer s.busy.Add(-1)
+		s.note(s.transfers, r)
+		chunk := make([]byte, 16<<10)
+		for {
+			if _, err := io.ReadFull(r.Body, chunk); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func (s *loadedServer) note(conns map[string]bool, r *http.Request) {
+	s.mu.Lock()
+	conns[r.RemoteAddr] = true
+	s.mu.Unlock()
+}
+
+func newLoadedServer(t *testing.T) (*loadedServer, endpoints.Endpoint) {
+	s := &loadedServer{transfers: map[string]bool{}, loadedPings: map[string]bool{}}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, endpoints.Endpoint{Name: "loaded", Kind: "probe", URL: srv.URL, Upload: true}
+}
+
+func TestLoadedPingsOff(t *testing.T) {
+	s, ep := newLoadedServer(t)
+	m, _ := measurerFor("probe")
+	stop := startLoadedPings(context.Background(), m, ep, "", Options{OpTimeout: time.Second})
+	time.Sleep(2 * loadedPingGap)
+	if got := stop(); got != nil || s.pings.Load() != 0 {
+		t.Errorf("without LoadedLatency: samples %v, %d pings sent", got, s.pings.Load())
+	}
+}
+
+func TestLoadedPings(t *testing.T) {
+	s, ep := newLoadedServer(t)
+	m, _ := measurerFor("probe")
+	s.busy.Store(1) // as if a transfer were running
+
+	stop := startLoadedPings(context.Background(), m, ep, "", Options{LoadedLatency: true, OpTimeout: time.Second})
+	// The connection-opening ping is done, and unmeasured, before the
+	// transfer would start.
+	if s.pings.Load() != 1 {
+		t.Errorf("%d pings before the transfer, want 1", s.pings.Load())
+	}
+	time.Sleep(3*loadedPingGap + loadedPingGap/2)
+	samples := stop()
+	if len(samples) < 2 || len(samples) > 4 {
+		t.Errorf("%d samples over 3.5 ping gaps: %v", len(samples), samples)
+	}
+	for _, ms := range samples {
+		if ms < float64(queueDelay.Milliseconds()) {
+			t.Errorf("sample %.1fms shorter than the queue delay", ms)
+		}
+	}
+	if n := s.pings.Load(); int(n) != len(samples)+1 && int(n) != len(samples)+2 {
+		t.Errorf("%d pings sent for %d samples", n, len(samples))
+	}
+	if len(s.loadedPings) != 1 {
+		t.Errorf("pings used %d connections, want one kept warm", len(s.loadedPings))
+	}
+}
+
+func TestLoadedLatency(t *testing.T) {
+	s, ep := newLoadedServer(t)
+	opts := Options{
+		LoadedLatency: true,
+		PingCount:     3,
+		DownloadBytes: 1_600_000, // ~0.5s at the server's pace
+		UploadBytes:   1_000_000,
+		OpTimeout:     10 * time.Second,
+	}
+	opts.defaults()
+	res := testLocation(context.Background(), endpoints.LocationEndpoints{Name: "test", Endpoints: []endpoints.Endpoint{ep}}, opts, func(Progress) {})
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+
+	delay := float64(queueDelay.Milliseconds())
+	if res.IdleDownloadMs >= delay || res.IdleUploadMs >= delay {
+		t.Errorf("idle latency %.1fms down, %.1fms up: includes the queue delay", res.IdleDownloadMs, res.IdleUploadMs)
+	}
+	if res.LoadedDownloadMs < delay || res.LoadedUploadMs < delay {
+		t.Errorf("loaded latency %.1fms down, %.1fms up: misses the %.0fms queue delay", res.LoadedDownloadMs, res.LoadedUploadMs, delay)
+	}
+	// RPM is 60000 over the median of all loaded samples, which lies
+	// between the download and upload medians.
+	lo, hi := min(res.LoadedDownloadMs, res.LoadedUploadMs), max(res.LoadedDownloadMs, res.LoadedUploadMs)
+	if res.ResponsivenessRPM < 60_000/hi || res.ResponsivenessRPM > 60_000/lo {
+		t.Errorf("RPM %.0f, want between %.0f and %.0f", res.ResponsivenessRPM, 60_000/hi, 60_000/lo)
+	}
+
+	// Pings under load never shared a transfer's connection.
+	if len(s.loadedPings) == 0 {
+		t.Fatal("no pings during the transfers")
+	}
+	for addr := range s.loadedPings {
+		if s.transfers[addr] {
+			t.Errorf("loaded ping on transfer connection %s", addr)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	for _, tt := range []struct {
+		xs   []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{7}, 7},
+		{[]float64{40, 10, 30}, 30},
+		{[]float64{40, 10, 30, 20}, 25},
+	} {
+		if got := Median(tt.xs); got != tt.want {
+			t.Errorf("Median(%v) = %v, want %v", tt.xs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+)
+
+// A Measurer speaks the protocol of one endpoint kind. Every endpoint's
+// latency is measured through its kind's Measurer; throughput additionally
+// needs the optional Downloader and Uploader (or Transferer) capabilities,
+// which the engine discovers by type assertion. Streams, durations,
+// sampling and loaded latency are handled by the engine on top of these
+// primitives.
+type Measurer interface {
+	// Bases returns candidate base addresses for ep, tried in order until
+	// one answers every ping of a latency run. Most kinds return [""].
+	Bases(ep endpoints.Endpoint) []string
+	// Ping makes one small round trip to ep through base.
+	Ping(ctx context.Context, ep endpoints.Endpoint, base string) error
+}
+
+// A Downloader fetches up to size bytes from ep, writing them to w as they
+// arrive. It must not write more than size bytes.
+type Downloader interface {
+	Download(ctx context.Context, ep endpoints.Endpoint, base string, size int64, w io.Writer) error
+}
+
+// An Uploader sends size bytes read from body to ep. The engine only
+// uploads to endpoints whose registry entry has Upload set.
+type Uploader interface {
+	Upload(ctx context.Context, ep endpoints.Endpoint, base string, body io.Reader, size int64) error
+}
+
+// A Transferer runs a whole throughput test as one session instead of the
+// engine driving Download/Upload per stream, for protocols whose servers
+// take a single multi-stream session at a time (iperf3). It takes
+// precedence over Downloader and Uploader.
+type Transferer interface {
+	// Transfer moves data for t.Duration on len(t.Counts) streams. UDP
+	// sessions return the receiver's loss/jitter stats; TCP ones nil.
+	Transfer(ctx context.Context, ep endpoints.Endpoint, base string, t Transfer) (*UDPStats, error)
+}
+
+// ErrBusy is what a Transferer returns (wrapped or not) when its server is
+// taken by another client; the engine then moves on to the next endpoint.
+var ErrBusy = errors.New("endpoint busy")
+
+// Transfer describes one session for a Transferer.
+type Transfer struct {
+	Upload   bool          // client→server; otherwise server→client
+	Duration time.Duration // how long to move data
+	UDPRate  int64         // total target bits/s for UDP; 0 = use TCP
+	// Counts has one counter per stream; the Transferer adds each stream's
+	// payload bytes as they are sent or received, for the engine's sampler.
+	Counts []*atomic.Int64
+}
+
+// UDPStats is what the receiving side of a UDP throughput test saw.
+type UDPStats struct {
+	JitterMs    float64 `json:"jitter_ms"`
+	Packets     int64   `json:"packets"`
+	Lost        int64   `json:"lost"`
+	LossPercent float64 `json:"loss_percent"`
+}
+
+// A LossMeasurer sends probes small datagrams, one per interval, and
+// reports what the path did to them (reflector endpoints).
+type LossMeasurer interface {
+	Loss(ctx context.Context, ep endpoints.Endpoint, base string, probes int, interval time.Duration) (*LossStats, error)
+}
+
+// LossStats is the outcome of a packet-loss test. Forward is client→server;
+// the direction split and one-way jitter need a reflector that timestamps
+// and counts what it receives, and UnknownLost is loss it couldn't split.
+type LossStats struct {
+	Sent            int     `json:"sent"`
+	Received        int     `json:"received"`
+	LossPercent     float64 `json:"loss_percent"`
+	ForwardLost     int     `json:"forward_lost"`
+	ReturnLost      int     `json:"return_lost"`
+	UnknownLost     int     `json:"unknown_lost"`
+	Reordered       int     `json:"reordered"`
+	Duplicates      int     `json:"duplicates"`
+	ForwardJitterMs float64 `json:"forward_jitter_ms"`
+	ReturnJitterMs  float64 `json:"return_jitter_ms"`
+	AvgRTTMs        float64 `json:"avg_rtt_ms"`
+}
+
+// isSession reports whether m runs single-client sessions. Such endpoints
+// are only tested when asked for (Options.Kinds, or added by the user), and
+// their latency is a bare handshake that doesn't compete with round trips.
+func isSession(m Measurer) bool {
+	_, ok := m.(Transferer)
+	return ok
+}
+
+func canDownload(m Measurer) bool {
+	switch m.(type) {
+	case Transferer, Downloader:
+		return true
+	}
+	return false
+}
+
+func canUpload(m Measurer) bool {
+	switch m.(type) {
+	case Transferer, Uploader:
+		return true
+	}
+	return false
+}
+
+var (
+	measurersMu sync.RWMutex
+	measurers   = map[string]Measurer{}
+)
+
+// Register makes m the Measurer for endpoints of the given kind, replacing
+// any earlier registration. Endpoints of unregistered kinds are reported as
+// errors rather than measured.
+func Register(kind string, m Measurer) {
+	measurersMu.Lock()
+	defer measurersMu.Unlock()
+	measurers[kind] = m
+}
+
+func measurerFor(kind string) (Measurer, bool) {
+	measurersMu.RLock()
+	defer measurersMu.RUnlock()
+	m, ok := measurers[kind]
+	return m, ok
+}
+
+// HTTPClient returns the client the built-in kinds use for requests made
+// with ctx, for HTTP-based Measurers that want the same connection behavior
+// (one TCP connection per stream, Options.Family, on native builds).
+func HTTPClient(ctx context.Context) *http.Client { return clientFor(ctx) }
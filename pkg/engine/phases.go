@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Phases splits an endpoint's request latency into its parts, so e.g. a
+// fast TCP connect followed by a slow TLS handshake points at a middlebox.
+// Only native builds fill these in: under js/wasm, fetch never reports
+// connection events and the hooks simply don't fire.
+type Phases struct {
+	DNSMs     float64 `json:"dns_ms,omitempty"`
+	ConnectMs float64 `json:"connect_ms,omitempty"`
+	TLSMs     float64 `json:"tls_ms,omitempty"`
+	TTFBMs    float64 `json:"ttfb_ms,omitempty"` // request written → first response byte
+	// Reused means every ping went over a connection that was already
+	// open (kept alive from an earlier endpoint on the same host), so DNS,
+	// connect and TLS were not measured; their zeros mean nothing.
+	Reused bool `json:"reused,omitempty"`
+}
+
+// phaseTrace collects the httptrace events of a single request.
+type phaseTrace struct {
+	mu                                  sync.Mutex
+	dnsStart, connStart, tlsStart, sent time.Time
+	reused                              bool
+	p                                   Phases
+}
+
+func withPhaseTrace(ctx context.Context) (context.Context, *phaseTrace) {
+	t := &phaseTrace{}
+	since := func(start time.Time) float64 {
+		return float64(time.Since(start).Nanoseconds()) / 1e6
+	}
+	ct := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.p.DNSMs = since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		// Dual-stack dials may race several addresses; the first connect
+		// start and the first successful connect bound the phase.
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connStart.IsZero() {
+				t.connStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil && t.p.ConnectMs == 0 {
+				t.p.ConnectMs = since(t.connStart)
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.p.TLSMs = since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.sent = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			if !t.sent.IsZero() {
+				t.p.TTFBMs = since(t.sent)
+			}
+			t.mu.Unlock()
+		},
+	}
+	return httptrace.WithClientTrace(ctx, ct), t
+}
+
+// result returns the recorded phases and whether the request had to set up
+// a fresh connection (so DNS/connect/TLS are meaningful).
+func (t *phaseTrace) result() (Phases, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.p, !t.reused
+}
@@ -0,0 +1,79 @@
+//go:build !js
+
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/web"
+)
+
+func TestPhaseTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := srv.Client()
+
+	get := func() (Phases, bool) {
+		ctx, pt := withPhaseTrace(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body) // so the connection is kept alive
+		resp.Body.Close()
+		return pt.result()
+	}
+
+	p, fresh := get()
+	if !fresh || p.ConnectMs <= 0 || p.TLSMs <= 0 || p.TTFBMs <= 0 {
+		t.Errorf("first request: %+v, fresh %v; want connect, TLS and TTFB timed on a fresh connection", p, fresh)
+	}
+	if p.DNSMs != 0 {
+		t.Errorf("first request to an address resolved nothing, yet DNS took %.3fms", p.DNSMs)
+	}
+	p, fresh = get()
+	if fresh || p.ConnectMs != 0 || p.TLSMs != 0 || p.TTFBMs <= 0 {
+		t.Errorf("second request: %+v, fresh %v; want only TTFB, on the reused connection", p, fresh)
+	}
+}
+
+func TestLatencyPhases(t *testing.T) {
+	srv := httptest.NewTLSServer(web.ProbeHandler())
+	defer srv.Close()
+	// Trust the test server's certificate in the shared client.
+	tr := clients[FamilyAny].Transport.(*http.Transport)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	saved := tr.TLSClientConfig
+	tr.TLSClientConfig = &tls.Config{RootCAs: roots}
+	defer func() {
+		tr.CloseIdleConnections()
+		tr.TLSClientConfig = saved
+	}()
+
+	m, _ := measurerFor("probe")
+	opts := Options{PingCount: 3, OpTimeout: 5 * time.Second}
+	// Two endpoints on one host: the second finds the first's connection.
+	for i, reused := range []bool{false, true} {
+		ep := endpoints.Endpoint{Name: "probe", Kind: "probe", URL: srv.URL}
+		_, _, _, _, ph, err := measureLatency(context.Background(), m, ep, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ph.Reused != reused || ph.TTFBMs <= 0 {
+			t.Errorf("endpoint %d: %+v, want Reused %v and a TTFB", i, ph, reused)
+		}
+		if connected := ph.ConnectMs > 0 && ph.TLSMs > 0; connected == reused {
+			t.Errorf("endpoint %d: connect %.3fms, TLS %.3fms with Reused %v", i, ph.ConnectMs, ph.TLSMs, ph.Reused)
+		}
+	}
+}
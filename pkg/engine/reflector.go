@@ -0,0 +1,46 @@
+//go:build !js
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+	"github.com/rotkonetworks/intspeed/pkg/reflector"
+)
+
+// Reflectors are plain UDP, which the browser can't send.
+func init() {
+	Register("reflector", reflectorKind{})
+}
+
+// reflectorKind measures against intspeed UDP echo reflectors (Host is
+// host[:port]): latency and packet loss only, no throughput.
+type reflectorKind struct{}
+
+func (reflectorKind) Bases(endpoints.Endpoint) []string { return []string{""} }
+
+func (reflectorKind) Ping(ctx context.Context, ep endpoints.Endpoint, _ string) error {
+	return reflector.Ping(ctx, ep.Host, Network(ctx, "udp"))
+}
+
+func (reflectorKind) Loss(ctx context.Context, ep endpoints.Endpoint, _ string, probes int, interval time.Duration) (*LossStats, error) {
+	st, err := reflector.Probe(ctx, ep.Host, reflector.Config{Count: probes, Interval: interval, Network: Network(ctx, "udp")})
+	if err != nil {
+		return nil, err
+	}
+	return &LossStats{
+		Sent:            st.Sent,
+		Received:        st.Received,
+		LossPercent:     st.LossPercent,
+		ForwardLost:     st.ForwardLost,
+		ReturnLost:      st.ReturnLost,
+		UnknownLost:     st.UnknownLost,
+		Reordered:       st.Reordered,
+		Duplicates:      st.Duplicates,
+		ForwardJitterMs: st.ForwardJitterMs,
+		ReturnJitterMs:  st.ReturnJitterMs,
+		AvgRTTMs:        st.AvgRTTMs,
+	}, nil
+}
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rotkonetworks/intspeed/pkg/endpoints"
+)
+
+// sampleInterval is the resolution at which transfers are metered.
+const sampleInterval = 100 * time.Millisecond
+
+// sessionDuration is how long a Transferer session runs when the sweep is
+// in fixed-size mode: session protocols (iperf3) are time-based only.
+const sessionDuration = 10 * time.Second
+
+// throughput is the outcome of one (possibly multi-stream) transfer.
+type throughput struct {
+	mbps    float64       // aggregate over all streams
+	streams []float64     // each connection's own rate
+	series  []int64       // aggregate bytes per sampleInterval, ramp-up included
+	tail    time.Duration // length of the last series entry if shorter than sampleInterval
+	udp     *UDPStats     // receiver-side stats of a UDP session, if any
+}
+
+// runStreams runs fn on opts.Streams concurrent connections, each adding
+// the bytes it moves to its own counter.
+//
+// In fixed-size mode every stream makes one transfer and the aggregate is
+// all bytes over total wall time. In duration mode (opts.TestDuration > 0)
+// streams repeat fn until the deadline; a sampler meters the counters every
+// sampleInterval and only the samples after opts.RampUp count, so slow start
+// and handshakes don't drag the steady-state rate down.
+//
+// Each sample is also passed to onSample (if non-nil) as it is taken, with
+// the time it covers.
+//
+// The first failing stream cancels the rest and fails the measurement,
+// since a partial aggregate would under-report the path.
+func runStreams(ctx context.Context, opts Options, onSample func(int64, time.Duration), fn func(ctx context.Context, count *atomic.Int64) error) (throughput, error) {
+	timed := opts.TestDuration > 0
+	return meter(ctx, opts.Streams, timed, opts.RampUp, onSample, func(counts []atomic.Int64, ends []time.Duration) error {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx := ctx
+		if timed {
+			ctx, cancel = context.WithTimeout(ctx, opts.TestDuration)
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+
+		var (
+			wg       sync.WaitGroup
+			errOnce  sync.Once
+			firstErr error
+		)
+		start := time.Now()
+		for i := range counts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { ends[i] = time.Since(start) }()
+				for {
+					err := fn(ctx, &counts[i])
+					if timed && ctx.Err() != nil && parent.Err() == nil {
+						return // our own deadline: the normal end of a timed test
+					}
+					if err != nil {
+						errOnce.Do(func() { firstErr = err })
+						cancel()
+						return
+					}
+					if !timed {
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		return firstErr
+	})
+}
+
+// runSession hands the whole transfer to a Transferer, metering the
+// per-stream counters it fills exactly like runStreams does in duration
+// mode.
+func runSession(ctx context.Context, t Transferer, ep endpoints.Endpoint, base string, opts Options, upload bool, onSample func(int64, time.Duration)) (throughput, error) {
+	dur, ramp := opts.TestDuration, opts.RampUp
+	if dur == 0 {
+		dur, ramp = sessionDuration, sessionDuration/4
+	}
+	var udp *UDPStats
+	tp, err := meter(ctx, opts.Streams, true, ramp, onSample, func(counts []atomic.Int64, ends []time.Duration) error {
+		tr := Transfer{Upload: upload, Duration: dur, UDPRate: opts.UDPRate}
+		for i := range counts {
+			tr.Counts = append(tr.Counts, &counts[i])
+		}
+		start := time.Now()
+		var err error
+		udp, err = t.Transfer(ctx, ep, base, tr)
+		for i := range ends {
+			ends[i] = time.Since(start)
+		}
+		return err
+	})
+	tp.udp = udp
+	return tp, err
+}
+
+// meter runs transfer with one byte counter per stream (transfer records
+// when each stream finished in ends) and samples the counters every
+// sampleInterval, plus once more when it returns so the series adds up to
+// every byte moved. With timed set, the rates only count samples after the
+// ramp-up window; otherwise they cover the whole run.
+func meter(ctx context.Context, n int, timed bool, rampUp time.Duration, onSample func(int64, time.Duration), transfer func(counts []atomic.Int64, ends []time.Duration) error) (throughput, error) {
+	counts := make([]atomic.Int64, n)
+	ends := make([]time.Duration, n)
+	start := time.Now()
+
+	// The sampler snapshots every stream once the ramp-up window has passed,
+	// and keeps the per-interval aggregate byte counts.
+	var (
+		samples  []int64
+		tail     time.Duration
+		rampSnap []int64
+		rampAt   time.Duration
+	)
+	rampTicks := int(rampUp / sampleInterval)
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		t := time.NewTicker(sampleInterval)
+		defer t.Stop()
+		var last int64
+		lastAt := start
+		for {
+			final := false
+			select {
+			case <-stop:
+				final = true
+			case <-t.C:
+			}
+			var total int64
+			snap := make([]int64, n)
+			for i := range counts {
+				snap[i] = counts[i].Load()
+				total += snap[i]
+			}
+			d := sampleInterval
+			if final {
+				// The partial interval since the last tick, if anything
+				// moved in it.
+				if total == last {
+					return
+				}
+				d = time.Since(lastAt)
+				tail = d
+			}
+			samples = append(samples, total-last)
+			if onSample != nil {
+				onSample(total-last, d)
+			}
+			if final {
+				return
+			}
+			last, lastAt = total, time.Now()
+			if timed && len(samples) == rampTicks {
+				rampSnap, rampAt = snap, time.Since(start)
+			}
+		}
+	}()
+
+	err := transfer(counts, ends)
+	elapsed := time.Since(start)
+	close(stop)
+	<-sampled
+	if err != nil {
+		return throughput{}, err
+	}
+
+	tp := throughput{streams: make([]float64, n), series: samples, tail: tail}
+	if timed && rampSnap != nil && len(samples) > rampTicks {
+		steady := samples[rampTicks:]
+		var sum int64
+		for _, b := range steady {
+			sum += b
+		}
+		d := time.Duration(len(steady)) * sampleInterval
+		if tail > 0 {
+			d += tail - sampleInterval
+		}
+		tp.mbps = mbps(sum, d)
+		for i := range counts {
+			tp.streams[i] = mbps(counts[i].Load()-rampSnap[i], ends[i]-rampAt)
+		}
+		return tp, nil
+	}
+	// Fixed-size mode, or a timed test too short to leave the ramp-up.
+	var total int64
+	for i := range counts {
+		b := counts[i].Load()
+		total += b
+		tp.streams[i] = mbps(b, ends[i])
+	}
+	tp.mbps = mbps(total, elapsed)
+	return tp, nil
+}
+
+func mbps(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / d.Seconds() / 1e6
+}
+
+// countingWriter discards what it is given, adding the length to n.
+type countingWriter struct{ n *atomic.Int64 }
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// countingReader adds every byte read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	k, err := r.r.Read(p)
+	r.n.Add(int64(k))
+	return k, err
+}
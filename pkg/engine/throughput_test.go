@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pace adds bytes to count so that it grows at rate bytes/s from now
+// until ctx ends, independent of how late the scheduler wakes it.
+func pace(ctx context.Context, count *atomic.Int64, rate float64) {
+	start := time.Now()
+	var sent int64
+	for ctx.Err() == nil {
+		want := int64(rate * time.Since(start).Seconds())
+		count.Add(want - sent)
+		sent = want
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func sum(xs []int64) int64 {
+	var s int64
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+func near(got, want, tol float64) bool {
+	return math.Abs(got-want) <= tol*want
+}
+
+func TestRunStreamsSeriesAddsUp(t *testing.T) {
+	// Two full intervals and a partial one: the last sample must carry the
+	// bytes moved after the last tick and say how long it covers.
+	var samples []int64
+	var covered []time.Duration
+	onSample := func(b int64, d time.Duration) {
+		samples = append(samples, b)
+		covered = append(covered, d)
+	}
+	var calls atomic.Int32
+	tp, err := runStreams(context.Background(), Options{Streams: 2}, onSample, func(ctx context.Context, count *atomic.Int64) error {
+		if calls.Add(1) == 1 {
+			count.Add(1000)
+			time.Sleep(2*sampleInterval + sampleInterval/2)
+			count.Add(500)
+			return nil
+		}
+		count.Add(2000)
+		time.Sleep(2*sampleInterval + sampleInterval/2)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sum(tp.series); got != 3500 {
+		t.Errorf("series sums to %d bytes, want 3500", got)
+	}
+	if len(tp.series) != 3 {
+		t.Fatalf("series = %v, want 2 full intervals and a tail", tp.series)
+	}
+	if tp.series[2] != 500 {
+		t.Errorf("tail sample = %d bytes, want 500", tp.series[2])
+	}
+	if tp.tail <= 0 || tp.tail >= sampleInterval {
+		t.Errorf("tail = %v, want a partial interval", tp.tail)
+	}
+	if len(samples) != len(tp.series) || covered[0] != sampleInterval || covered[2] != tp.tail {
+		t.Errorf("onSample got %v covering %v", samples, covered)
+	}
+	// Fixed-size: the rate is every byte over the whole run.
+	if want := mbps(3500, 250*time.Millisecond); !near(tp.mbps, want, 0.2) {
+		t.Errorf("mbps = %.4f, want about %.4f", tp.mbps, want)
+	}
+}
+
+func TestRunStreamsNoTailWhenIdle(t *testing.T) {
+	// Nothing moved after the last tick: no empty partial sample.
+	tp, err := runStreams(context.Background(), Options{Streams: 1}, nil, func(ctx context.Context, count *atomic.Int64) error {
+		count.Add(100)
+		time.Sleep(sampleInterval + sampleInterval/2)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp.tail != 0 || len(tp.series) != 1 || tp.series[0] != 100 {
+		t.Errorf("series = %v, tail = %v; want [100] and none", tp.series, tp.tail)
+	}
+}
+
+func TestRunStreamsExcludesRampUp(t *testing.T) {
+	// A burst during ramp-up, then a steady 1 Mbps per stream: the timed
+	// rates must only see the steady part.
+	const (
+		burst = 1_000_000
+		rate  = 125_000 // bytes/s = 1 Mbps
+	)
+	opts := Options{Streams: 2, TestDuration: 700 * time.Millisecond, RampUp: 2 * sampleInterval}
+	tp, err := runStreams(context.Background(), opts, nil, func(ctx context.Context, count *atomic.Int64) error {
+		if count.Load() == 0 {
+			count.Add(burst)
+		}
+		pace(ctx, count, rate)
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(tp.mbps, 2, 0.15) {
+		t.Errorf("aggregate = %.3f Mbps, want about 2 (ramp-up burst excluded)", tp.mbps)
+	}
+	for i, s := range tp.streams {
+		if !near(s, 1, 0.15) {
+			t.Errorf("stream %d = %.3f Mbps, want about 1", i, s)
+		}
+	}
+	if got := sum(tp.series); got < 2*burst {
+		t.Errorf("series sums to %d bytes, want the ramp-up burst included", got)
+	}
+}
+
+func TestRunStreamsFixedSize(t *testing.T) {
+	var calls atomic.Int32
+	tp, err := runStreams(context.Background(), Options{Streams: 3}, nil, func(ctx context.Context, count *atomic.Int64) error {
+		calls.Add(1)
+		count.Add(1000)
+		time.Sleep(sampleInterval / 2)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("fn ran %d times, want once per stream", calls.Load())
+	}
+	if got := sum(tp.series); got != 3000 {
+		t.Errorf("series sums to %d bytes, want 3000", got)
+	}
+	if len(tp.streams) != 3 || tp.mbps <= 0 {
+		t.Errorf("streams = %v, mbps = %v", tp.streams, tp.mbps)
+	}
+}
+
+func TestRunStreamsTimed(t *testing.T) {
+	opts := Options{Streams: 2, TestDuration: 500 * time.Millisecond, RampUp: 2 * sampleInterval}
+	tp, err := runStreams(context.Background(), opts, nil, func(ctx context.Context, count *atomic.Int64) error {
+		pace(ctx, count, 250_000) // 2 Mbps
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("a timed test ending at its deadline failed: %v", err)
+	}
+	if !near(tp.mbps, 4, 0.15) {
+		t.Errorf("aggregate = %.3f Mbps, want about 4", tp.mbps)
+	}
+}
+
+func TestRunStreamsFailure(t *testing.T) {
+	// The first stream fails; the other must be cancelled rather than run
+	// out the test, and the measurement fails with the first error.
+	boom := errors.New("boom")
+	var (
+		started   atomic.Int32
+		cancelled atomic.Bool
+	)
+	_, err := runStreams(context.Background(), Options{Streams: 2, TestDuration: 10 * time.Second}, nil, func(ctx context.Context, count *atomic.Int64) error {
+		if started.Add(1) == 1 {
+			time.Sleep(sampleInterval)
+			return boom
+		}
+		<-ctx.Done()
+		cancelled.Store(true)
+		return ctx.Err()
+	})
+	if !errors.Is(err, boom) {
+		t.Errorf("err = %v, want the failing stream's", err)
+	}
+	if !cancelled.Load() {
+		t.Error("the other stream was not cancelled")
+	}
+}
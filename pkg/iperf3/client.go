@@ -0,0 +1,375 @@
+//go:build !js
+
+package iperf3
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config describes one client session.
+type Config struct {
+	Streams  int
+	Duration time.Duration // rounded up to whole seconds on the wire
+	Reverse  bool          // server sends, client receives
+	UDPRate  int64         // total bits/s over all streams; 0 = TCP
+	Network  string        // "tcp" (default), "tcp4" or "tcp6"; UDP streams follow
+	// Counters, if set, holds one counter per stream that receives each
+	// payload byte as it is sent or received.
+	Counters []*atomic.Int64
+}
+
+// Result summarizes a session from the receiver's point of view.
+type Result struct {
+	Bytes   int64   // payload bytes the receiver got
+	Seconds float64 // data phase length
+	// UDP only, from whichever side received.
+	JitterMs float64
+	Packets  int64
+	Lost     int64
+}
+
+// Mbps is the receiver-side rate.
+func (r *Result) Mbps() float64 {
+	if r.Seconds <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / r.Seconds / 1e6
+}
+
+// LossPercent is the share of UDP packets that never arrived.
+func (r *Result) LossPercent() float64 {
+	if r.Packets <= 0 {
+		return 0
+	}
+	return float64(r.Lost) / float64(r.Packets) * 100
+}
+
+// Run performs one session against the iperf3 server at addr (host or
+// host:port, default port 5201).
+func Run(ctx context.Context, addr string, cfg Config) (*Result, error) {
+	if cfg.Streams <= 0 {
+		cfg.Streams = 1
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	var d net.Dialer
+	ctrl, err := d.DialContext(ctx, cfg.Network, HostPort(addr))
+	if err != nil {
+		return nil, err
+	}
+	defer ctrl.Close()
+	stop := context.AfterFunc(ctx, func() { ctrl.SetDeadline(time.Now()) })
+	defer stop()
+
+	c := &client{cfg: cfg, cookie: newCookie(), ctrl: ctrl}
+	defer c.closeStreams()
+	if _, err := ctrl.Write(c.cookie); err != nil {
+		return nil, err
+	}
+	for {
+		state, err := readState(ctrl)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("iperf3 control: %w", err)
+		}
+		switch state {
+		case paramExchange:
+			err = writeJSON(ctrl, c.params())
+		case createStreams:
+			err = c.dialStreams(ctx)
+		case testStart:
+		case testRunning:
+			err = c.run(ctx)
+		case exchangeResults:
+			err = c.exchangeResults()
+		case displayResults:
+			if err := writeState(ctrl, iperfDone); err != nil {
+				return nil, err
+			}
+			// Wait for the server to hang up: until then it may turn the
+			// next session away as busy.
+			ctrl.SetReadDeadline(time.Now().Add(time.Second))
+			io.Copy(io.Discard, ctrl)
+			return c.result(), nil
+		case accessDenied:
+			return nil, ErrBusy
+		case serverError:
+			var codes [8]byte
+			io.ReadFull(ctrl, codes[:])
+			return nil, fmt.Errorf("iperf3 server error %d (errno %d)",
+				int32(binary.BigEndian.Uint32(codes[:4])), int32(binary.BigEndian.Uint32(codes[4:])))
+		case serverTerminate:
+			return nil, fmt.Errorf("iperf3 server terminated the test")
+		default:
+			return nil, fmt.Errorf("iperf3: unexpected state %d", state)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type client struct {
+	cfg    Config
+	cookie []byte
+	ctrl   net.Conn
+
+	streams []net.Conn
+	wg      sync.WaitGroup
+	sent    []int64        // forward: payload bytes (TCP) or packets (UDP) per stream
+	recv    []*udpReceiver // reverse: per-stream receive stats
+	secs    float64
+	remote  results
+}
+
+func (c *client) udp() bool { return c.cfg.UDPRate > 0 }
+
+func (c *client) params() params {
+	p := params{
+		Time:          int(math.Ceil(c.cfg.Duration.Seconds())),
+		Parallel:      c.cfg.Streams,
+		Reverse:       c.cfg.Reverse,
+		Len:           tcpBlock,
+		ClientVersion: "3.9",
+	}
+	if c.udp() {
+		p.UDP, p.Len = true, udpBlock
+		p.Bandwidth = c.cfg.UDPRate / int64(c.cfg.Streams)
+	} else {
+		p.TCP = true
+	}
+	return p
+}
+
+// dialStreams opens the data streams in order: TCP streams identify
+// themselves with the session cookie, UDP ones with the connect handshake.
+func (c *client) dialStreams(ctx context.Context) error {
+	raddr := c.ctrl.RemoteAddr().(*net.TCPAddr)
+	network := "udp" + c.cfg.Network[len("tcp"):]
+	var d net.Dialer
+	for i := 0; i < c.cfg.Streams; i++ {
+		if !c.udp() {
+			conn, err := d.DialContext(ctx, c.cfg.Network, raddr.String())
+			if err != nil {
+				return err
+			}
+			c.streams = append(c.streams, conn)
+			if _, err := conn.Write(c.cookie); err != nil {
+				return err
+			}
+			continue
+		}
+		conn, err := d.DialContext(ctx, network, raddr.String())
+		if err != nil {
+			return err
+		}
+		c.streams = append(c.streams, conn)
+		msg := binary.LittleEndian.AppendUint32(nil, udpConnectMsg)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("iperf3 UDP connect: %w", err)
+		}
+		conn.SetReadDeadline(time.Time{})
+		switch binary.LittleEndian.Uint32(reply) {
+		case udpConnectReply, legacyConnectReply, udpConnectMsg:
+		default:
+			if binary.BigEndian.Uint32(reply) != udpConnectReply {
+				return fmt.Errorf("iperf3 UDP connect: bad reply %x", reply)
+			}
+		}
+	}
+	return nil
+}
+
+// run moves data for the configured duration, then tells the server the
+// test is over. In reverse mode the receivers keep draining until the
+// result exchange closes the streams.
+func (c *client) run(ctx context.Context) error {
+	n := len(c.streams)
+	c.sent = make([]int64, n)
+	c.recv = make([]*udpReceiver, n)
+	done := make(chan struct{})
+	start := time.Now()
+	for i, conn := range c.streams {
+		var counter *atomic.Int64
+		if i < len(c.cfg.Counters) {
+			counter = c.cfg.Counters[i]
+		}
+		c.recv[i] = &udpReceiver{}
+		c.wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer c.wg.Done()
+			if c.cfg.Reverse {
+				c.receive(conn, c.recv[i], counter)
+			} else {
+				c.sent[i] = c.send(conn, start, done, counter)
+			}
+		}(i, conn)
+	}
+
+	t := time.NewTimer(c.cfg.Duration)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	close(done)
+	c.secs = time.Since(start).Seconds()
+	if !c.cfg.Reverse {
+		for _, conn := range c.streams {
+			conn.SetWriteDeadline(time.Now()) // unblock senders
+		}
+		c.wg.Wait()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return writeState(c.ctrl, testEnd)
+}
+
+// send writes until done closes, returning TCP payload bytes or UDP
+// packets sent.
+func (c *client) send(conn net.Conn, start time.Time, done <-chan struct{}, counter *atomic.Int64) int64 {
+	if !c.udp() {
+		buf := make([]byte, tcpBlock)
+		var total int64
+		for {
+			select {
+			case <-done:
+				return total
+			default:
+			}
+			n, err := conn.Write(buf)
+			total += int64(n)
+			if counter != nil {
+				counter.Add(int64(n))
+			}
+			if err != nil {
+				return total
+			}
+		}
+	}
+	rate := c.cfg.UDPRate / int64(c.cfg.Streams)
+	buf := make([]byte, udpBlock)
+	var count uint32
+	for pace(start, int64(count)*udpBlock, rate, done) {
+		count++
+		udpStamp(buf, time.Now(), count)
+		if _, err := conn.Write(buf); err != nil {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+				return int64(count)
+			}
+			continue // transient (e.g. ENOBUFS); the receiver counts it lost
+		}
+		if counter != nil {
+			counter.Add(udpBlock)
+		}
+	}
+	return int64(count)
+}
+
+func (c *client) receive(conn net.Conn, u *udpReceiver, counter *atomic.Int64) {
+	buf := make([]byte, tcpBlock)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if c.udp() {
+				u.add(buf[:n], time.Now())
+			} else {
+				u.bytes += int64(n)
+			}
+			if counter != nil {
+				counter.Add(int64(n))
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *client) closeStreams() {
+	for _, conn := range c.streams {
+		conn.Close()
+	}
+	c.wg.Wait()
+}
+
+func (c *client) exchangeResults() error {
+	if c.cfg.Reverse {
+		c.closeStreams()
+	}
+	local := results{SenderHasRetransmits: -1}
+	if c.cfg.Reverse {
+		local.SenderHasRetransmits = 0
+	}
+	for i := range c.streams {
+		s := streamResult{ID: streamID(i), Retransmits: -1, EndTime: c.secs}
+		if c.cfg.Reverse {
+			u := c.recv[i]
+			s.Bytes, s.Jitter, s.Errors, s.Packets = u.bytes, u.jitter, u.lost, u.packets
+		} else if c.udp() {
+			s.Packets = c.sent[i]
+			s.Bytes = c.sent[i] * udpBlock
+		} else {
+			s.Bytes = c.sent[i]
+		}
+		local.Streams = append(local.Streams, s)
+	}
+	if err := writeJSON(c.ctrl, local); err != nil {
+		return err
+	}
+	return readJSON(c.ctrl, &c.remote)
+}
+
+// result takes receiver-side numbers: our own in reverse mode, the
+// server's otherwise.
+func (c *client) result() *Result {
+	r := &Result{Seconds: c.secs}
+	var jitter float64
+	if c.cfg.Reverse {
+		for _, u := range c.recv {
+			r.Bytes += u.bytes
+			r.Packets += u.packets
+			r.Lost += u.lost
+			jitter += u.jitter
+		}
+		jitter /= float64(len(c.recv))
+	} else {
+		for _, s := range c.remote.Streams {
+			r.Bytes += s.Bytes
+			r.Packets += s.Packets
+			r.Lost += s.Errors
+			jitter += s.Jitter
+		}
+		if len(c.remote.Streams) > 0 {
+			jitter /= float64(len(c.remote.Streams))
+		}
+	}
+	if c.udp() {
+		r.JitterMs = jitter * 1000
+	} else {
+		r.Packets, r.Lost = 0, 0
+	}
+	return r
+}
@@ -0,0 +1,260 @@
+//go:build !js
+
+package iperf3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer runs Serve on a loopback port until the test ends.
+func startServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+// startLossyRelay forwards TCP and UDP on one loopback port to target,
+// dropping every dropEvery-th UDP data datagram in each direction, so UDP
+// sessions through it see loss.
+func startLossyRelay(t *testing.T, target string, dropEvery int) string {
+	t.Helper()
+	var (
+		ln  net.Listener
+		pc  *net.UDPConn
+		err error
+	)
+	// The UDP socket needs the TCP listener's port, which may be taken.
+	for range 10 {
+		if ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		port := ln.Addr().(*net.TCPAddr).Port
+		if pc, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err == nil {
+			break
+		}
+		ln.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		pc.Close()
+	})
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				up, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				go func() {
+					io.Copy(up, c)
+					up.Close()
+				}()
+				io.Copy(c, up)
+			}()
+		}
+	}()
+
+	taddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// drop picks the datagrams to lose: every dropEvery-th data one, never
+	// the 4-byte connect handshake.
+	drop := func(n int, count *int) bool {
+		if n <= 4 {
+			return false
+		}
+		*count++
+		return *count%dropEvery == 0
+	}
+	go func() {
+		ups := map[string]*net.UDPConn{} // one upstream socket per client stream
+		defer func() {
+			for _, up := range ups {
+				up.Close()
+			}
+		}()
+		forward := 0
+		buf := make([]byte, 64<<10)
+		for {
+			n, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			up := ups[from.String()]
+			if up == nil {
+				if up, err = net.DialUDP("udp", nil, taddr); err != nil {
+					continue
+				}
+				ups[from.String()] = up
+				go func() {
+					back := 0
+					buf := make([]byte, 64<<10)
+					for {
+						n, err := up.Read(buf)
+						if err != nil {
+							return
+						}
+						if !drop(n, &back) {
+							pc.WriteToUDP(buf[:n], from)
+						}
+					}
+				}()
+			}
+			if !drop(n, &forward) {
+				up.Write(buf[:n])
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		reverse bool
+		udp     bool
+	}{
+		{"tcp forward", false, false},
+		{"tcp reverse", true, false},
+		{"udp forward", false, true},
+		{"udp reverse", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startServer(t)
+			cfg := Config{Streams: 2, Duration: 500 * time.Millisecond, Reverse: tt.reverse}
+			if tt.udp {
+				addr = startLossyRelay(t, addr, 10)
+				cfg.UDPRate = 20_000_000
+			}
+			counts := make([]atomic.Int64, cfg.Streams)
+			for i := range counts {
+				cfg.Counters = append(cfg.Counters, &counts[i])
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			res, err := Run(ctx, addr, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var counted int64
+			for i := range counts {
+				if counts[i].Load() == 0 {
+					t.Errorf("stream %d moved no bytes", i)
+				}
+				counted += counts[i].Load()
+			}
+			if res.Bytes <= 0 || res.Seconds <= 0 || res.Mbps() <= 0 {
+				t.Fatalf("result %+v: want bytes, seconds and a rate", res)
+			}
+			// The receiver can't get more than was sent; in reverse mode
+			// we are the receiver and counted the same bytes.
+			if tt.reverse && res.Bytes != counted {
+				t.Errorf("received %d bytes, counters saw %d", res.Bytes, counted)
+			}
+			if !tt.reverse && res.Bytes > counted {
+				t.Errorf("server received %d bytes, more than the %d sent", res.Bytes, counted)
+			}
+
+			if !tt.udp {
+				if res.Packets != 0 || res.Lost != 0 || res.JitterMs != 0 {
+					t.Errorf("TCP result %+v has UDP stats", res)
+				}
+				return
+			}
+			if res.Packets <= 0 || res.Lost <= 0 || res.JitterMs <= 0 {
+				t.Fatalf("UDP result %+v: want packets, loss and jitter", res)
+			}
+			if got := (res.Packets - res.Lost) * udpBlock; res.Bytes != got {
+				t.Errorf("received %d bytes, want %d for %d of %d packets", res.Bytes, got, res.Packets-res.Lost, res.Packets)
+			}
+			// The relay drops one datagram in ten.
+			if loss := res.LossPercent(); loss < 5 || loss > 15 {
+				t.Errorf("loss %.1f%%, want about 10%%", loss)
+			}
+		})
+	}
+}
+
+func TestBusy(t *testing.T) {
+	addr := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var count atomic.Int64
+	first := make(chan error, 1)
+	go func() {
+		_, err := Run(ctx, addr, Config{Duration: time.Second, Counters: []*atomic.Int64{&count}})
+		first <- err
+	}()
+	for count.Load() == 0 {
+		select {
+		case err := <-first:
+			t.Fatalf("first session ended early: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if _, err := Run(ctx, addr, Config{Duration: time.Second}); !errors.Is(err, ErrBusy) {
+		t.Errorf("second client during a session: got %v, want ErrBusy", err)
+	}
+	if err := <-first; err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+	// The server takes clients again once the session is over.
+	if _, err := Run(ctx, addr, Config{Duration: 200 * time.Millisecond}); err != nil {
+		t.Errorf("client after the session: %v", err)
+	}
+}
+
+func TestProbeKeepsServerFree(t *testing.T) {
+	addr := startServer(t)
+	// A latency probe connects and hangs up, or lingers without a cookie;
+	// neither may take the session from the client that follows.
+	probe, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Run(ctx, addr, Config{Duration: 200 * time.Millisecond}); err != nil {
+		t.Errorf("client after a probe: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+//go:build !js
+
+// Package iperf3 speaks the iperf3 control and data protocol: a client for
+// the public iperf3 servers many transit providers and IXPs run (TCP and
+// UDP, forward and reverse), and a minimal server that stands in for
+// `iperf3 -s` in local tests and self-hosted deployments.
+//
+// A session is one control connection plus Parallel data streams. The
+// server drives it with one-byte state messages; parameters and results
+// travel as length-prefixed JSON on the control connection.
+package iperf3
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DefaultPort is the iperf3 server's well-known port.
+const DefaultPort = "5201"
+
+// Control-connection states (a signed char on the wire).
+const (
+	testStart       = 1
+	testRunning     = 2
+	testEnd         = 4
+	paramExchange   = 9
+	createStreams   = 10
+	serverTerminate = 11
+	clientTerminate = 12
+	exchangeResults = 13
+	displayResults  = 14
+	iperfDone       = 16
+	accessDenied    = -1
+	serverError     = -2
+)
+
+const (
+	cookieSize = 37 // 36 printable characters and a NUL
+	tcpBlock   = 128 << 10
+	udpBlock   = 1400 // stays under common tunnel MTUs
+	udpHeader  = 12   // sec, usec, 32-bit packet count
+
+	// UDP streams open with a datagram handshake. iperf3 writes these
+	// 32-bit values in host byte order, which is little-endian in practice.
+	udpConnectMsg      = 0x36373839
+	udpConnectReply    = 0x39383736
+	legacyConnectReply = 987654321
+)
+
+// ErrBusy is returned when the server is already running another session.
+var ErrBusy = fmt.Errorf("iperf3 server busy")
+
+// params is the session parameter object the client sends. Boolean flags
+// are significant by presence, hence omitempty.
+type params struct {
+	TCP           bool   `json:"tcp,omitempty"`
+	UDP           bool   `json:"udp,omitempty"`
+	Omit          int    `json:"omit"`
+	Time          int    `json:"time"`
+	Parallel      int    `json:"parallel"`
+	Reverse       bool   `json:"reverse,omitempty"`
+	Len           int    `json:"len"`
+	Bandwidth     int64  `json:"bandwidth,omitempty"` // bits/s per stream
+	ClientVersion string `json:"client_version"`
+}
+
+// results is what each side reports about its streams at the end.
+type results struct {
+	CPUUtilTotal         float64        `json:"cpu_util_total"`
+	CPUUtilUser          float64        `json:"cpu_util_user"`
+	CPUUtilSystem        float64        `json:"cpu_util_system"`
+	SenderHasRetransmits int            `json:"sender_has_retransmits"`
+	Streams              []streamResult `json:"streams"`
+}
+
+type streamResult struct {
+	ID          int     `json:"id"`
+	Bytes       int64   `json:"bytes"`
+	Retransmits int64   `json:"retransmits"`
+	Jitter      float64 `json:"jitter"` // seconds
+	Errors      int64   `json:"errors"` // UDP packets lost
+	Packets     int64   `json:"packets"`
+	StartTime   float64 `json:"start_time"`
+	EndTime     float64 `json:"end_time"`
+}
+
+// streamID numbers streams the way iperf3 does: 1, 3, 4, 5, …
+func streamID(i int) int {
+	if i == 0 {
+		return 1
+	}
+	return i + 2
+}
+
+func newCookie() []byte {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	b := make([]byte, cookieSize)
+	rand.Read(b)
+	for i := range b[:cookieSize-1] {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	b[cookieSize-1] = 0
+	return b
+}
+
+func writeState(w io.Writer, s int8) error {
+	_, err := w.Write([]byte{byte(s)})
+	return err
+}
+
+func readState(r io.Reader) (int8, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int8(b[0]), nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	_, err = w.Write(append(buf, data...))
+	return err
+}
+
+func readJSON(r io.Reader, v any) error {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > 1<<20 {
+		return fmt.Errorf("iperf3: %d-byte JSON message", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// udpStamp writes the iperf3 UDP payload header into b.
+func udpStamp(b []byte, now time.Time, count uint32) {
+	binary.BigEndian.PutUint32(b[0:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(now.Nanosecond()/1000))
+	binary.BigEndian.PutUint32(b[8:], count)
+}
+
+// udpReceiver tracks one UDP stream on the receiving side: loss from gaps
+// in the packet counter and RFC 3550 interarrival jitter.
+type udpReceiver struct {
+	bytes, packets, lost, outOfOrder int64
+	last                             uint32
+	jitter, prevTransit              float64 // seconds
+	seen                             bool
+}
+
+func (u *udpReceiver) add(b []byte, arrival time.Time) {
+	if len(b) < udpHeader {
+		return
+	}
+	u.bytes += int64(len(b))
+	sent := time.Unix(int64(binary.BigEndian.Uint32(b[0:])), int64(binary.BigEndian.Uint32(b[4:]))*1000)
+	count := binary.BigEndian.Uint32(b[8:])
+	if count > u.last {
+		if count > u.last+1 {
+			u.lost += int64(count - u.last - 1)
+		}
+		u.last = count
+	} else {
+		u.outOfOrder++
+		if u.lost > 0 {
+			u.lost--
+		}
+	}
+	u.packets = int64(u.last)
+
+	// Sender and receiver clocks differ by a constant that cancels out in
+	// the transit-time differences.
+	transit := arrival.Sub(sent).Seconds()
+	if u.seen {
+		d := transit - u.prevTransit
+		if d < 0 {
+			d = -d
+		}
+		u.jitter += (d - u.jitter) / 16
+	}
+	u.prevTransit, u.seen = transit, true
+}
+
+// pace sleeps until sending sent more bytes stays within rate bits/s since
+// start. It returns false once stop is closed.
+func pace(start time.Time, sent int64, rate int64, stop <-chan struct{}) bool {
+	due := start.Add(time.Duration(float64(sent*8) / float64(rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-stop:
+			return false
+		case <-t.C:
+		}
+	}
+	select {
+	case <-stop:
+		return false
+	default:
+		return true
+	}
+}
+
+// HostPort adds DefaultPort to addr if it has no port of its own.
+func HostPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, DefaultPort)
+}
@@ -0,0 +1,347 @@
+//go:build !js
+
+package iperf3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Serve answers iperf3 clients on ln, one session at a time, until ctx is
+// done. Like iperf3 -s, it tells clients that connect while a session runs
+// that it is busy (ErrBusy on their side); connections that hang up before
+// sending a cookie (latency probes) never take the session. UDP tests use a UDP socket on
+// the listener's port, as iperf3 does. It implements enough of iperf3 -s
+// for this package's client and the stock iperf3 client (TCP/UDP,
+// forward/reverse); it skips CPU accounting, omit periods, bidirectional
+// mode and the JSON server-output extension.
+func Serve(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	var (
+		mu     sync.Mutex
+		active *session
+	)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			cookie := make([]byte, cookieSize)
+			if _, err := io.ReadFull(conn, cookie); err != nil {
+				conn.Close()
+				return
+			}
+			conn.SetReadDeadline(time.Time{})
+			mu.Lock()
+			cur := active
+			if cur == nil {
+				active = &session{ln: ln, ctrl: conn, cookie: cookie, streams: make(chan net.Conn), done: make(chan struct{})}
+				cur = active
+			}
+			mu.Unlock()
+			if cur.ctrl != conn {
+				cur.admit(conn, cookie)
+				return
+			}
+			cur.serve(ctx)
+			mu.Lock()
+			active = nil
+			mu.Unlock()
+			close(cur.done)
+			conn.Close()
+		}()
+	}
+}
+
+// session is one client test on the server side.
+type session struct {
+	ln   net.Listener
+	ctrl net.Conn
+	// streams carries the connections presenting the session cookie, from
+	// Serve's accept loop to acceptTCP; done closes when the session ends.
+	streams chan net.Conn
+	done    chan struct{}
+
+	cookie []byte
+	p      params
+
+	tcp    []net.Conn
+	udp    *net.UDPConn
+	peers  []*net.UDPAddr
+	recv   []*udpReceiver
+	sent   []atomic.Int64 // forward-from-server bytes (TCP) or packets (UDP)
+	secs   float64
+	client results
+}
+
+func (s *session) serve(ctx context.Context) error {
+	defer s.closeStreams()
+	stop := context.AfterFunc(ctx, func() { s.ctrl.SetDeadline(time.Now()) })
+	defer stop()
+	// A client that vanishes mid-test must not wedge the server.
+	s.ctrl.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := writeState(s.ctrl, paramExchange); err != nil {
+		return err
+	}
+	if err := readJSON(s.ctrl, &s.p); err != nil {
+		return err
+	}
+	if s.p.Parallel <= 0 {
+		s.p.Parallel = 1
+	}
+	if s.p.Len <= 0 {
+		s.p.Len = tcpBlock
+		if s.p.UDP {
+			s.p.Len = udpBlock
+		}
+	}
+	if s.p.UDP && s.p.Bandwidth <= 0 {
+		s.p.Bandwidth = 1_000_000 // iperf3's UDP default
+	}
+	s.ctrl.SetDeadline(time.Now().Add(time.Duration(s.p.Time)*time.Second + 30*time.Second))
+
+	if err := writeState(s.ctrl, createStreams); err != nil {
+		return err
+	}
+	var err error
+	if s.p.UDP {
+		err = s.acceptUDP()
+	} else {
+		err = s.acceptTCP()
+	}
+	if err != nil {
+		// SERVER_ERROR carries iperf3's i_errno and errno; 0/0 = unspecified.
+		if writeState(s.ctrl, serverError) == nil {
+			s.ctrl.Write(make([]byte, 8))
+		}
+		return err
+	}
+	if err := writeState(s.ctrl, testStart); err != nil {
+		return err
+	}
+	if err := writeState(s.ctrl, testRunning); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	start := time.Now()
+	s.run(&wg, start, done)
+	state, err := readState(s.ctrl) // the client ends the test
+	s.secs = time.Since(start).Seconds()
+	close(done)
+	s.closeStreams()
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	switch state {
+	case testEnd:
+	case clientTerminate:
+		return nil
+	default:
+		return fmt.Errorf("iperf3: client sent state %d", state)
+	}
+
+	if err := writeState(s.ctrl, exchangeResults); err != nil {
+		return err
+	}
+	if err := readJSON(s.ctrl, &s.client); err != nil {
+		return err
+	}
+	if err := writeJSON(s.ctrl, s.results()); err != nil {
+		return err
+	}
+	if err := writeState(s.ctrl, displayResults); err != nil {
+		return err
+	}
+	_, err = readState(s.ctrl) // IPERF_DONE
+	return err
+}
+
+// admit takes a connection that sent cookie while the session runs: with
+// the session's cookie it is a data stream, any other client is told the
+// server is busy.
+func (s *session) admit(conn net.Conn, cookie []byte) {
+	if bytes.Equal(cookie, s.cookie) {
+		select {
+		case s.streams <- conn:
+			return
+		case <-s.done:
+		}
+	} else {
+		writeState(conn, accessDenied)
+	}
+	conn.Close()
+}
+
+// acceptTCP waits for the session's data connections from admit.
+func (s *session) acceptTCP() error {
+	timeout := time.After(10 * time.Second)
+	for len(s.tcp) < s.p.Parallel {
+		select {
+		case conn := <-s.streams:
+			s.tcp = append(s.tcp, conn)
+		case <-timeout:
+			return fmt.Errorf("iperf3: %d of %d streams connected", len(s.tcp), s.p.Parallel)
+		}
+	}
+	return nil
+}
+
+// acceptUDP waits for each stream's connect datagram on a UDP socket
+// bound to the control port and answers it; streams are then told apart by
+// their source address.
+func (s *session) acceptUDP() error {
+	laddr := s.ln.Addr().(*net.TCPAddr)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: laddr.Port})
+	if err != nil {
+		return err
+	}
+	s.udp = conn
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 64)
+	for len(s.peers) < s.p.Parallel {
+		n, peer, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if n != 4 || s.peerIndex(peer) >= 0 {
+			continue
+		}
+		s.peers = append(s.peers, peer)
+		reply := binary.LittleEndian.AppendUint32(nil, udpConnectReply)
+		if _, err := conn.WriteToUDP(reply, peer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) peerIndex(a *net.UDPAddr) int {
+	for i, p := range s.peers {
+		if p.IP.Equal(a.IP) && p.Port == a.Port {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *session) run(wg *sync.WaitGroup, start time.Time, done <-chan struct{}) {
+	n := s.p.Parallel
+	s.recv = make([]*udpReceiver, n)
+	s.sent = make([]atomic.Int64, n)
+	for i := range s.recv {
+		s.recv[i] = &udpReceiver{}
+	}
+	udp := s.udp // closeStreams clears the field while these still run
+	switch {
+	case s.p.UDP && s.p.Reverse:
+		for i, peer := range s.peers {
+			wg.Add(1)
+			go func(i int, peer *net.UDPAddr) {
+				defer wg.Done()
+				buf := make([]byte, s.p.Len)
+				var count uint32
+				for pace(start, int64(count)*int64(s.p.Len), s.p.Bandwidth, done) {
+					count++
+					udpStamp(buf, time.Now(), count)
+					if _, err := udp.WriteToUDP(buf, peer); errors.Is(err, net.ErrClosed) {
+						break
+					}
+					s.sent[i].Store(int64(count))
+				}
+			}(i, peer)
+		}
+	case s.p.UDP:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 64<<10)
+			for {
+				n, peer, err := udp.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				if i := s.peerIndex(peer); i >= 0 {
+					s.recv[i].add(buf[:n], time.Now())
+				}
+			}
+		}()
+	case s.p.Reverse:
+		for i, conn := range s.tcp {
+			wg.Add(1)
+			go func(i int, conn net.Conn) {
+				defer wg.Done()
+				buf := make([]byte, s.p.Len)
+				for {
+					n, err := conn.Write(buf)
+					s.sent[i].Add(int64(n))
+					if err != nil {
+						return
+					}
+				}
+			}(i, conn)
+		}
+	default:
+		for i, conn := range s.tcp {
+			wg.Add(1)
+			go func(i int, conn net.Conn) {
+				defer wg.Done()
+				buf := make([]byte, 64<<10)
+				for {
+					n, err := conn.Read(buf)
+					s.recv[i].bytes += int64(n)
+					if err != nil {
+						return
+					}
+				}
+			}(i, conn)
+		}
+	}
+}
+
+func (s *session) results() results {
+	r := results{SenderHasRetransmits: -1}
+	for i := 0; i < s.p.Parallel; i++ {
+		st := streamResult{ID: streamID(i), Retransmits: -1, EndTime: s.secs}
+		switch {
+		case s.p.Reverse && s.p.UDP:
+			st.Packets = s.sent[i].Load()
+			st.Bytes = st.Packets * int64(s.p.Len)
+		case s.p.Reverse:
+			st.Bytes = s.sent[i].Load()
+		default:
+			u := s.recv[i]
+			st.Bytes, st.Jitter, st.Errors, st.Packets = u.bytes, u.jitter, u.lost, u.packets
+		}
+		r.Streams = append(r.Streams, st)
+	}
+	return r
+}
+
+func (s *session) closeStreams() {
+	for _, c := range s.tcp {
+		c.Close()
+	}
+	s.tcp = nil
+	if s.udp != nil {
+		s.udp.Close()
+		s.udp = nil
+	}
+}
@@ -0,0 +1,228 @@
+//go:build !js
+
+package reflector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// Config describes one probe run.
+type Config struct {
+	Count    int           // probes to send (default 200)
+	Interval time.Duration // spacing between probes (default 20ms)
+	Size     int           // datagram size in bytes (default 64)
+	Wait     time.Duration // how long to wait for stragglers after the last probe (default 1s)
+	Network  string        // "udp" (default), "udp4" or "udp6"
+}
+
+// Stats is the outcome of a probe run.
+type Stats struct {
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+	Lost     int `json:"lost"`
+	// Loss split by direction, from the reflector's own probe counter as of
+	// the last probe that came back; UnknownLost were sent after it, so
+	// there is no counter to tell which way they were lost.
+	ForwardLost int     `json:"forward_lost"`
+	ReturnLost  int     `json:"return_lost"`
+	UnknownLost int     `json:"unknown_lost"`
+	LossPercent float64 `json:"loss_percent"`
+	Reordered   int     `json:"reordered"`  // echoes arriving after a later-sent one
+	Duplicates  int     `json:"duplicates"` // extra copies of an echo already seen
+	// RFC 3550 interarrival jitter of each direction's one-way transit time.
+	ForwardJitterMs float64 `json:"forward_jitter_ms"`
+	ReturnJitterMs  float64 `json:"return_jitter_ms"`
+	MinRTTMs        float64 `json:"min_rtt_ms"`
+	AvgRTTMs        float64 `json:"avg_rtt_ms"`
+}
+
+// Ping sends a single probe to addr and waits for its echo.
+func Ping(ctx context.Context, addr, network string) error {
+	if network == "" {
+		network = "udp"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, hostPort(addr))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	probe := make([]byte, headerSize)
+	copy(probe, magic)
+	binary.BigEndian.PutUint64(probe[8:], uint64(time.Now().UnixNano()))
+	if _, err := conn.Write(probe); err != nil {
+		return err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n >= headerSize && bytes.Equal(buf[:4], magic) && bytes.Equal(buf[8:16], probe[8:16]) {
+			return nil
+		}
+	}
+}
+
+// Probe sends a paced stream of probes to the reflector at addr (host or
+// host:port, default port 8862) and measures what came back.
+func Probe(ctx context.Context, addr string, cfg Config) (*Stats, error) {
+	if cfg.Count <= 0 {
+		cfg.Count = 200
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 20 * time.Millisecond
+	}
+	if cfg.Size < headerSize {
+		cfg.Size = 64
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = time.Second
+	}
+	if cfg.Network == "" {
+		cfg.Network = "udp"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, cfg.Network, hostPort(addr))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	type echo struct {
+		seq                 uint32
+		sent, remote, local int64 // ns; remote is on the reflector's clock
+		seen                uint32
+	}
+	echoes := make(chan echo, cfg.Count)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			local := time.Now().UnixNano()
+			if n < headerSize || !bytes.Equal(buf[:4], magic) {
+				continue
+			}
+			select {
+			case echoes <- echo{
+				seq:    binary.BigEndian.Uint32(buf[4:]),
+				sent:   int64(binary.BigEndian.Uint64(buf[8:])),
+				remote: int64(binary.BigEndian.Uint64(buf[16:])),
+				local:  local,
+				seen:   binary.BigEndian.Uint32(buf[24:]),
+			}:
+			default: // more echoes than probes: only duplicates overflow
+			}
+		}
+	}()
+
+	probe := make([]byte, cfg.Size)
+	copy(probe, magic)
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+	sent := 0
+	for ; sent < cfg.Count; sent++ {
+		binary.BigEndian.PutUint32(probe[4:], uint32(sent))
+		binary.BigEndian.PutUint64(probe[8:], uint64(time.Now().UnixNano()))
+		if _, err := conn.Write(probe); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("send probe: %w", err)
+		}
+		if sent < cfg.Count-1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(cfg.Wait):
+	}
+	conn.SetReadDeadline(time.Now())
+	<-readDone
+	close(echoes)
+
+	s := &Stats{Sent: sent}
+	got := map[uint32]bool{}
+	var (
+		maxSeq       int64 = -1
+		lastSeen     uint32
+		rttSum       float64
+		fwdPrev      float64
+		retPrev      float64
+		haveTransits bool
+	)
+	s.MinRTTMs = math.Inf(1)
+	for e := range echoes {
+		if got[e.seq] {
+			s.Duplicates++
+			continue
+		}
+		got[e.seq] = true
+		s.Received++
+		if int64(e.seq) < maxSeq {
+			s.Reordered++
+		} else {
+			maxSeq, lastSeen = int64(e.seq), e.seen
+		}
+
+		rtt := float64(e.local-e.sent) / 1e6
+		rttSum += rtt
+		s.MinRTTMs = math.Min(s.MinRTTMs, rtt)
+		// One-way transits carry the unknown clock offset, which cancels
+		// out of their differences.
+		fwd := float64(e.remote-e.sent) / 1e6
+		ret := float64(e.local-e.remote) / 1e6
+		if haveTransits {
+			s.ForwardJitterMs += (math.Abs(fwd-fwdPrev) - s.ForwardJitterMs) / 16
+			s.ReturnJitterMs += (math.Abs(ret-retPrev) - s.ReturnJitterMs) / 16
+		}
+		fwdPrev, retPrev, haveTransits = fwd, ret, true
+	}
+	if s.Received == 0 {
+		return nil, fmt.Errorf("no echoes from %s (%d probes)", addr, sent)
+	}
+	s.Lost = s.Sent - s.Received
+	s.LossPercent = float64(s.Lost) / float64(s.Sent) * 100
+	s.AvgRTTMs = rttSum / float64(s.Received)
+	// Of the probes up to maxSeq, the reflector got lastSeen (high if the
+	// network duplicated some on the way in, hence the clamp) and we got
+	// every echo that came back; the rest never had an echo to report on.
+	upTo := int(maxSeq) + 1
+	s.ForwardLost = max(0, upTo-int(lastSeen))
+	s.ReturnLost = max(0, upTo-s.ForwardLost-s.Received)
+	s.UnknownLost = s.Sent - upTo
+	return s, nil
+}
+
+func hostPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, DefaultPort)
+}
@@ -0,0 +1,92 @@
+//go:build !js
+
+// Package reflector implements intspeed's UDP echo reflector and the
+// client that measures packet loss, reordering, duplication and one-way
+// jitter against it. Deploy reflectors (intspeed-server --reflector) on
+// hosts in the registry cities to see loss on the international path
+// itself, which TCP throughput tests only show indirectly.
+//
+// Every probe is a datagram of at least headerSize bytes:
+//
+//	0  magic "ISRF"
+//	4  sequence number (uint32)
+//	8  client send time (int64 ns)
+//	16 reflector receive time (int64 ns, set by the reflector)
+//	24 probes the reflector has seen from this client (uint32, ditto)
+//
+// The reflector echoes the datagram back to its sender with the last two
+// fields filled in, which lets the client split loss and jitter by
+// direction. Clocks need not be synchronized: the constant offset between
+// them cancels out of both jitter and loss.
+package reflector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+const headerSize = 28
+
+var magic = []byte("ISRF")
+
+// DefaultPort is the reflector's conventional UDP port.
+const DefaultPort = "8862"
+
+// clientIdle is how long a client's probe counter is kept after its last
+// probe.
+const clientIdle = time.Minute
+
+// Serve echoes probes arriving on conn until ctx is done. Datagrams without
+// the magic are ignored, so the reflector can't be used to bounce arbitrary
+// traffic; replies are never larger than the request.
+func Serve(ctx context.Context, conn *net.UDPConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	type client struct {
+		seen uint32
+		last time.Time
+	}
+	var (
+		clients = map[string]*client{}
+		swept   = time.Now()
+	)
+	buf := make([]byte, 64<<10)
+	for {
+		n, peer, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		now := time.Now()
+		if n < headerSize || !bytes.Equal(buf[:4], magic) {
+			continue
+		}
+
+		c := clients[peer.String()]
+		if c == nil {
+			c = &client{}
+			clients[peer.String()] = c
+		}
+		c.seen++
+		c.last = now
+		seen := c.seen
+		if now.Sub(swept) > clientIdle {
+			for k, v := range clients {
+				if now.Sub(v.last) > clientIdle {
+					delete(clients, k)
+				}
+			}
+			swept = now
+		}
+
+		binary.BigEndian.PutUint64(buf[16:], uint64(now.UnixNano()))
+		binary.BigEndian.PutUint32(buf[24:], seen)
+		conn.WriteToUDP(buf[:n], peer)
+	}
+}
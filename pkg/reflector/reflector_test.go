@@ -0,0 +1,147 @@
+//go:build !js
+
+package reflector
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startReflector runs Serve on a loopback port until the test ends.
+func startReflector(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Serve(ctx, conn)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// startLossyRelay forwards one client's datagrams to target and the replies
+// back, dropping every fwdEvery-th on the way there and every retEvery-th
+// on the way back (0 = none).
+func startLossyRelay(t *testing.T, target *net.UDPAddr, fwdEvery, retEvery int) string {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := net.DialUDP("udp", nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		up.Close()
+	})
+	drop := func(every int, count *int) bool {
+		*count++
+		return every > 0 && *count%every == 0
+	}
+	client := make(chan *net.UDPAddr, 1)
+	go func() {
+		fwd := 0
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case client <- from:
+			default:
+			}
+			if !drop(fwdEvery, &fwd) {
+				up.Write(buf[:n])
+			}
+		}
+	}()
+	go func() {
+		from := <-client
+		ret := 0
+		buf := make([]byte, 2048)
+		for {
+			n, err := up.Read(buf)
+			if err != nil {
+				return
+			}
+			if !drop(retEvery, &ret) {
+				pc.WriteToUDP(buf[:n], from)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name               string
+		fwdEvery, retEvery int
+		// With 100 probes: which are lost, and where.
+		received, fwd, ret, unknown int
+	}{
+		{"clean", 0, 0, 100, 0, 0, 0},
+		// Probes 9, 19, ... 99 never reach the reflector; of the 90 echoes,
+		// every 7th is lost. Probe 99 was the last sent, so no echo after
+		// it can say which way it went.
+		{"both ways", 10, 7, 78, 9, 12, 1},
+		// Echoes 9, 19, ... 99 are lost. The last probe's echo is among
+		// them, which must not read as forward loss.
+		{"return only", 0, 10, 90, 0, 9, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startLossyRelay(t, startReflector(t), tt.fwdEvery, tt.retEvery)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			st, err := Probe(ctx, addr, Config{Count: 100, Interval: time.Millisecond, Wait: 200 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Sent != 100 || st.Received != tt.received || st.Lost != 100-tt.received {
+				t.Errorf("sent %d, received %d, lost %d; want 100, %d, %d", st.Sent, st.Received, st.Lost, tt.received, 100-tt.received)
+			}
+			if st.ForwardLost != tt.fwd || st.ReturnLost != tt.ret || st.UnknownLost != tt.unknown {
+				t.Errorf("lost %d forward, %d return, %d unknown; want %d, %d, %d",
+					st.ForwardLost, st.ReturnLost, st.UnknownLost, tt.fwd, tt.ret, tt.unknown)
+			}
+			if st.ForwardLost+st.ReturnLost+st.UnknownLost != st.Lost {
+				t.Errorf("split %d+%d+%d doesn't add up to %d lost", st.ForwardLost, st.ReturnLost, st.UnknownLost, st.Lost)
+			}
+			if st.Duplicates != 0 || st.AvgRTTMs <= 0 {
+				t.Errorf("stats %+v: want no duplicates and a round-trip time", st)
+			}
+		})
+	}
+}
+
+func TestServeIgnoresStrangers(t *testing.T) {
+	conn, err := net.DialUDP("udp", nil, startReflector(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(make([]byte, headerSize)) // no magic
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("reflector echoed %d bytes of a datagram without the magic", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := Ping(ctx, conn.RemoteAddr().String(), ""); err != nil {
+		t.Errorf("ping: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxProbeBytes caps a single /download response.
+const maxProbeBytes = 1 << 30
+
+// ProbeHandler serves the intspeed probe protocol (the registry's "probe"
+// endpoint kind) relative to where it is mounted:
+//
+//	GET  /ping               empty 200, for latency
+//	GET  /download?bytes=N   N bytes of incompressible data
+//	POST /upload             body is read and discarded
+//
+// Responses are CORS-open with Timing-Allow-Origin, so the browser build
+// can measure against it too.
+//
+// Transfers clear the server's read and write deadlines, so a handler
+// mounted next to timeout-bound routes doesn't cut off a slow link mid-test.
+func ProbeHandler() http.Handler {
+	chunk := make([]byte, 64<<10)
+	rand.Read(chunk)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if err != nil || n <= 0 || n > maxProbeBytes {
+			http.Error(w, "bytes must be in 1..2^30", http.StatusBadRequest)
+			return
+		}
+		noDeadline(w)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		for n > 0 {
+			k := min(n, int64(len(chunk)))
+			if _, err := w.Write(chunk[:k]); err != nil {
+				return
+			}
+			n -= k
+		}
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		noDeadline(w)
+		io.Copy(io.Discard, r.Body)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Timing-Allow-Origin", "*")
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// noDeadline lifts the connection's read and write deadlines for the rest
+// of the request. A server without deadlines, or a writer that can't set
+// them, is left as it is.
+func noDeadline(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}